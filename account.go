@@ -0,0 +1,145 @@
+package cexio
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Wallet balance of one currency.
+type Balance struct {
+	Currency  string
+	Available Decimal
+	OnOrder   Decimal
+}
+
+// Wallet transaction pushed as tx message, e.g. the fill of an order.
+type Transaction struct {
+	Id      string      `json:"id"`
+	Order   json.Number `json:"order"`
+	Type    string      `json:"type"`
+	Symbol  string      `json:"symbol"`
+	Amount  Decimal     `json:"amount"`
+	Balance Decimal     `json:"balance"`
+	Fee     Decimal     `json:"fee_amount"`
+	Time    time.Time   `json:"time"`
+}
+
+// Balance after a balance, obalance or tx push, given by Type.
+// Transaction is only set for tx pushes.
+type BalanceEvent struct {
+	Balance
+	Type        string
+	Transaction *Transaction
+}
+
+type balanceResponse struct {
+	Balance map[string]Decimal `json:"balance"`
+	OnOrder map[string]Decimal `json:"obalance"`
+}
+
+type balancePush struct {
+	Symbol  string  `json:"symbol"`
+	Balance Decimal `json:"balance"`
+}
+
+// GetBalance returns the wallet balances by currency. They also seed
+// the balances kept up to date by pushes, see Balances.
+func (context *Context) GetBalance() (map[string]Balance, error) {
+	context.balanceLock.Lock()
+	requested := context.balanceSeq
+	context.balanceLock.Unlock()
+
+	response := balanceResponse{}
+	if error := context.request("get-balance", struct{}{}, &response); error != nil {
+		return nil, error
+	}
+	balances := make(map[string]Balance, len(response.Balance))
+	for currency, available := range response.Balance {
+		balances[currency] = Balance{Currency: currency, Available: available}
+	}
+	for currency, on_order := range response.OnOrder {
+		balance := balances[currency]
+		balance.Currency = currency
+		balance.OnOrder = on_order
+		balances[currency] = balance
+	}
+
+	context.balanceLock.Lock()
+	for currency, balance := range balances {
+		// Keep what pushes received meanwhile have set
+		if context.balanceUpdated[currency] <= requested {
+			context.balances[currency] = balance
+		}
+	}
+	context.balanceLock.Unlock()
+	return balances, nil
+}
+
+// Balances returns the last known balances by currency.
+func (context *Context) Balances() map[string]Balance {
+	context.balanceLock.Lock()
+	defer context.balanceLock.Unlock()
+	balances := make(map[string]Balance, len(context.balances))
+	for currency, balance := range context.balances {
+		balances[currency] = balance
+	}
+	return balances
+}
+
+// OnBalance registers handler for every balance change pushed by the
+// server once authenticated. Handlers run on the reader goroutine and
+// must not block.
+func (context *Context) OnBalance(handler func(BalanceEvent)) {
+	context.balanceLock.Lock()
+	context.balanceHandlers = append(context.balanceHandlers, handler)
+	context.balanceLock.Unlock()
+}
+
+// Applies a balance change and notifies the handlers.
+func (context *Context) updateBalance(event BalanceEvent, on_order bool) {
+	context.balanceLock.Lock()
+	balance := context.balances[event.Currency]
+	balance.Currency = event.Currency
+	if on_order {
+		balance.OnOrder = event.OnOrder
+	} else {
+		balance.Available = event.Available
+	}
+	context.balances[event.Currency] = balance
+	context.balanceSeq++
+	context.balanceUpdated[event.Currency] = context.balanceSeq
+	handlers := context.balanceHandlers
+	context.balanceLock.Unlock()
+
+	event.Balance = balance
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
+
+// Handles balance and obalance pushes, which carry the available and
+// the on-order amount respectively.
+func (context *Context) handleBalancePush(push *Response) {
+	data := balancePush{}
+	if error := json.Unmarshal(push.Data, &data); error != nil {
+		context.log().Errorf("Unable to parse %s: %s", push.Type, error)
+		return
+	}
+	event := BalanceEvent{Type: push.Type}
+	event.Currency = data.Symbol
+	event.Available = data.Balance
+	event.OnOrder = data.Balance
+	context.updateBalance(event, push.Type == "obalance")
+}
+
+func (context *Context) handleTransactionPush(push *Response) {
+	transaction := &Transaction{}
+	if error := json.Unmarshal(push.Data, transaction); error != nil {
+		context.log().Errorf("Unable to parse transaction: %s", error)
+		return
+	}
+	event := BalanceEvent{Type: "tx", Transaction: transaction}
+	event.Currency = transaction.Symbol
+	event.Available = transaction.Balance
+	context.updateBalance(event, false)
+}
@@ -0,0 +1,87 @@
+package cexio
+
+import (
+	"github.com/sahmad98/cex.io/cexiotest"
+	"testing"
+	"time"
+)
+
+func TestGetBalance(t *testing.T) {
+	server := newHandledServer(t, func(request cexiotest.Request, conn *cexiotest.Conn) {
+		if request.Type != "get-balance" {
+			conn.Fail("unexpected request")
+			return
+		}
+		conn.Reply(map[string]interface{}{
+			"balance":  map[string]string{"BTC": "9.00000000", "USD": "1024.00", "ETH": "0"},
+			"obalance": map[string]string{"BTC": "0.12000000", "USD": "512.00"},
+			"time":     1435927928597,
+		})
+	})
+	context := newTestContext(t, server)
+
+	balances, error := context.GetBalance()
+	if error != nil {
+		t.Fatal(error)
+	}
+	if balances["BTC"] != (Balance{"BTC", d("9"), d("0.12")}) || balances["USD"] != (Balance{"USD", d("1024"), d("512")}) {
+		t.Fatalf("Unexpected balances %+v", balances)
+	}
+	if len(balances) != 3 || context.Balances()["USD"].OnOrder != d("512") {
+		t.Fatalf("Balances not cached %+v", context.Balances())
+	}
+}
+
+func TestOnBalance(t *testing.T) {
+	server := newHandledServer(t, func(request cexiotest.Request, conn *cexiotest.Conn) {
+		if request.Type == "get-balance" {
+			conn.Reply(map[string]interface{}{"balance": map[string]string{"BTC": "1"}, "obalance": map[string]string{}})
+			return
+		}
+		// Anything else triggers the pushes of an order being filled
+		conn.Send(map[string]interface{}{"e": "obalance", "data": map[string]string{"symbol": "BTC", "balance": "0.5"}})
+		conn.Send(map[string]interface{}{"e": "balance", "data": map[string]string{"symbol": "BTC", "balance": "0.5"}})
+		conn.Send(map[string]interface{}{"e": "tx", "data": map[string]interface{}{
+			"d": "order:3644838498:a:BTC", "c": "user:up105393824:a:BTC", "a": "0.1", "ds": 0, "cs": "0.6",
+			"user": "up105393824", "symbol": "BTC", "order": 3644838498, "amount": "0.1", "type": "buy",
+			"time": "2016-04-08T12:47:00.786Z", "balance": "0.6", "fee_amount": "0.0001", "id": "15865681"}})
+	})
+	context := newTestContext(t, server)
+
+	events := make(chan BalanceEvent, 16)
+	context.OnBalance(func(event BalanceEvent) { events <- event })
+	if _, error := context.GetBalance(); error != nil {
+		t.Fatal(error)
+	}
+	if _, error := context.Send("get-order", nil); error != nil {
+		t.Fatal(error)
+	}
+
+	expected := []BalanceEvent{
+		{Balance: Balance{"BTC", d("1"), d("0.5")}, Type: "obalance"},
+		{Balance: Balance{"BTC", d("0.5"), d("0.5")}, Type: "balance"},
+		{Balance: Balance{"BTC", d("0.6"), d("0.5")}, Type: "tx"},
+	}
+	for _, want := range expected {
+		select {
+		case event := <-events:
+			if event.Balance != want.Balance || event.Type != want.Type {
+				t.Fatalf("Expected %+v got %+v", want, event)
+			}
+			if event.Type == "tx" && (event.Transaction.Order.String() != "3644838498" || event.Transaction.Fee != d("0.0001")) {
+				t.Fatalf("Unexpected transaction %+v", event.Transaction)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("No %s event", want.Type)
+		}
+	}
+	if balance := context.Balances()["BTC"]; balance.Available != d("0.6") || balance.OnOrder != d("0.5") {
+		t.Fatalf("Unexpected balance %+v", balance)
+	}
+	// Only the connected message of the server is left
+	for context.RecvChannel.Len() > 0 {
+		if message, _ := context.RecvChannel.Get(); message.(*Message).Type != "connected" {
+			t.Fatalf("Push leaked into RecvChannel %+v", message)
+		}
+	}
+}
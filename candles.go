@@ -0,0 +1,385 @@
+package cexio
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/google/flatbuffers/go"
+	buffer "github.com/sahmad98/cex.io/types"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Pushes of the ohlcv subscription started by init-ohlcv, see
+// SubscribeCandles.
+const (
+	kInitOhlcvPush = "init-ohlcv-new"
+	kOhlcvPush     = "ohlcv"
+	kOhlcvNewPush  = "ohlcv-new"
+	kOhlcv1mPush   = "ohlcv1m"
+)
+
+// File identifier telling candles apart from orderbooks on the UDP
+// channel, see schema/candle.fbs.
+const kCandleIdentifier = "CNDL"
+
+// Names of the candle intervals the server supports.
+var candleIntervals = map[time.Duration]string{
+	time.Minute:        "1m",
+	3 * time.Minute:    "3m",
+	5 * time.Minute:    "5m",
+	15 * time.Minute:   "15m",
+	30 * time.Minute:   "30m",
+	time.Hour:          "1h",
+	2 * time.Hour:      "2h",
+	4 * time.Hour:      "4h",
+	6 * time.Hour:      "6h",
+	12 * time.Hour:     "12h",
+	24 * time.Hour:     "1d",
+	3 * 24 * time.Hour: "3d",
+	7 * 24 * time.Hour: "1w",
+}
+
+// Candle of Pair starting at Time. Candles received from the server
+// may be sent again while their interval is still running, local ones
+// are only sent once complete.
+type Candle struct {
+	Pair     string
+	Interval time.Duration
+	Time     time.Time
+	Open     Decimal
+	High     Decimal
+	Low      Decimal
+	Close    Decimal
+	Volume   Decimal
+	// Built from trades by a CandleAggregator
+	Local bool
+}
+
+type candleKey struct {
+	pair     string
+	interval time.Duration
+}
+
+type OhlcvRequest struct {
+	Type     string   `json:"e"`
+	Interval string   `json:"i"`
+	Rooms    []string `json:"rooms"`
+}
+
+// Candles as arrays of time in seconds, open, high, low, close and
+// volume in the smallest unit of the currency.
+type ohlcvRows [][]json.RawMessage
+
+type ohlcv1mPush struct {
+	Pair   string      `json:"pair"`
+	Time   json.Number `json:"time"`
+	Open   Decimal     `json:"o"`
+	High   Decimal     `json:"h"`
+	Low    Decimal     `json:"l"`
+	Close  Decimal     `json:"c"`
+	Volume json.Number `json:"v"`
+}
+
+func intervalByName(name string) (time.Duration, bool) {
+	for interval, interval_name := range candleIntervals {
+		if interval_name == name {
+			return interval, true
+		}
+	}
+	return 0, false
+}
+
+// Volumes are integers in the smallest unit of the currency, which is
+// the unit of the last decimal place.
+func parseVolume(volume json.Number) (Decimal, error) {
+	value, error := strconv.ParseInt(strings.TrimSpace(volume.String()), 10, 64)
+	return Decimal(value), error
+}
+
+func parseCandleRow(pair string, interval time.Duration, row []json.RawMessage) (Candle, error) {
+	candle := Candle{Pair: pair, Interval: interval}
+	if len(row) < 6 {
+		return candle, fmt.Errorf("candle of %d fields", len(row))
+	}
+	var seconds int64
+	if error := json.Unmarshal(row[0], &seconds); error != nil {
+		return candle, error
+	}
+	candle.Time = time.Unix(seconds, 0)
+	for i, field := range []*Decimal{&candle.Open, &candle.High, &candle.Low, &candle.Close} {
+		if error := json.Unmarshal(row[i+1], field); error != nil {
+			return candle, error
+		}
+	}
+	var volume json.Number
+	if error := json.Unmarshal(row[5], &volume); error != nil {
+		return candle, error
+	}
+	var error error
+	candle.Volume, error = parseVolume(volume)
+	return candle, error
+}
+
+// SubscribeCandles requests the recent candles of sym1:sym2 and the
+// following updates, delivered through OnCandle. The server supports
+// intervals from 1m to 1w, e.g. 1m, 5m, 1h or 24h. With Options.Pairs
+// set, unknown pairs fail with ErrUnknownPair.
+func (md *MarketDataAdapter) SubscribeCandles(sym1, sym2 string, interval time.Duration) error {
+	name, ok := candleIntervals[interval]
+	if !ok {
+		return fmt.Errorf("cexio: unsupported candle interval %s", interval)
+	}
+	if error := md.Context.checkPair(sym1, sym2); error != nil {
+		return error
+	}
+	pair := NewPair(sym1, sym2)
+	sym1, sym2 = pair.Symbol1, pair.Symbol2
+	md.lock.Lock()
+	md.candleIntervals[pair.String()] = interval
+	md.lock.Unlock()
+	return md.initCandles(sym1, sym2, name)
+}
+
+func (md *MarketDataAdapter) initCandles(sym1, sym2, interval string) error {
+	request := OhlcvRequest{Type: "init-ohlcv", Interval: interval, Rooms: []string{"pair-" + sym1 + "-" + sym2}}
+	request_string, _ := json.Marshal(request)
+	return md.Context.sendJson(request_string)
+}
+
+// Interval of candles of pair pushed without one.
+func (md *MarketDataAdapter) candleInterval(pair, name string) time.Duration {
+	if interval, ok := intervalByName(name); ok {
+		return interval
+	}
+	md.lock.RLock()
+	defer md.lock.RUnlock()
+	if interval, ok := md.candleIntervals[pair]; ok {
+		return interval
+	}
+	return time.Minute
+}
+
+func (md *MarketDataAdapter) handleOhlcvPush(push *Response) {
+	rows := ohlcvRows{}
+	if error := json.Unmarshal(push.Data, &rows); error != nil {
+		md.Context.log().Errorf("Unable to parse %s: %s", push.Type, error)
+		return
+	}
+	pair := push.pair()
+	interval := md.candleInterval(pair, push.Interval)
+	candles := make([]Candle, 0, len(rows))
+	for _, row := range rows {
+		candle, error := parseCandleRow(pair, interval, row)
+		if error != nil {
+			md.Context.log().Errorf("Unable to parse %s candle: %s", push.Type, error)
+			return
+		}
+		candles = append(candles, candle)
+	}
+	md.UpdateChannel.Put(candles)
+}
+
+func (md *MarketDataAdapter) handleOhlcv1mPush(push *Response) {
+	data := ohlcv1mPush{}
+	if error := json.Unmarshal(push.Data, &data); error != nil {
+		md.Context.log().Errorf("Unable to parse ohlcv1m: %s", error)
+		return
+	}
+	seconds, error := data.Time.Int64()
+	volume, volume_error := parseVolume(data.Volume)
+	if error != nil || volume_error != nil {
+		md.Context.log().Errorf("Unable to parse ohlcv1m time %q or volume %q", data.Time, data.Volume)
+		return
+	}
+	md.UpdateChannel.Put([]Candle{{
+		Pair:     data.Pair,
+		Interval: time.Minute,
+		Time:     time.Unix(seconds, 0),
+		Open:     data.Open,
+		High:     data.High,
+		Low:      data.Low,
+		Close:    data.Close,
+		Volume:   volume,
+	}})
+}
+
+// Publishes candles received from the server, which suppress local
+// candles of the same pair and interval for a while.
+func (md *MarketDataAdapter) handleCandles(candles []Candle) {
+	now := md.Context.now()
+	md.lock.Lock()
+	for _, candle := range candles {
+		md.serverCandles[candleKey{candle.Pair, candle.Interval}] = now
+	}
+	md.lock.Unlock()
+	for _, candle := range candles {
+		md.publish(kTopicCandle, candle.Pair, candle)
+	}
+}
+
+// Publishes a local candle unless the server sent candles of the same
+// pair and interval within the last two intervals.
+func (md *MarketDataAdapter) publishLocalCandle(candle Candle) {
+	md.lock.RLock()
+	received, ok := md.serverCandles[candleKey{candle.Pair, candle.Interval}]
+	md.lock.RUnlock()
+	if ok && md.Context.now().Sub(received) < 2*candle.Interval {
+		return
+	}
+	md.publish(kTopicCandle, candle.Pair, candle)
+}
+
+// AggregateCandles builds candles of pair, or of every pair if empty,
+// from its trades while the server does not send candles of the same
+// interval. Intervals default to 1m, 5m and 1h. Candles of intervals
+// without trades are completed by the receive time of the frames, and
+// every second unless Options.Clock is set, so that a Replay gives the
+// same candles at any speed. Unsubscribe the returned subscription to
+// stop.
+func (md *MarketDataAdapter) AggregateCandles(pair string, intervals ...time.Duration) *Subscription {
+	aggregator := NewCandleAggregator(intervals...)
+	subscription := md.subscribe(kTopicTrade, pair, func(value interface{}) {
+		completed := []Candle{}
+		switch value := value.(type) {
+		case TradeEvent:
+			completed = aggregator.Add(value)
+		case time.Time:
+			completed = aggregator.Flush(value)
+		}
+		for _, candle := range completed {
+			md.publishLocalCandle(candle)
+		}
+	}, []SubscriptionOption{WithPolicy(PolicyBlock), func(subscription *Subscription) { subscription.clock = true }})
+	if md.Context.options.Clock != nil {
+		return subscription
+	}
+	md.spawn(func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				md.UpdateChannel.Put(clockTick(now))
+			case <-subscription.Done():
+				return
+			}
+		}
+	})
+	return subscription
+}
+
+// Time of the adapter while no frames arrive, put on the update
+// channel.
+type clockTick time.Time
+
+// Hands now to the candle aggregators once per second of the receive
+// time of frames. Only called by the update goroutine.
+func (md *MarketDataAdapter) advanceClock(now time.Time) {
+	if now.IsZero() || !now.Truncate(time.Second).After(md.clock) {
+		return
+	}
+	md.clock = now.Truncate(time.Second)
+	md.publishClock(now)
+}
+
+// CandleAggregator builds candles of fixed intervals from trades. It
+// is safe for concurrent use.
+type CandleAggregator struct {
+	intervals []time.Duration
+	lock      sync.Mutex
+	open      map[candleKey]*Candle
+	// Start of the last candle flushed per pair and interval
+	flushed map[candleKey]time.Time
+}
+
+func NewCandleAggregator(intervals ...time.Duration) *CandleAggregator {
+	if len(intervals) == 0 {
+		intervals = []time.Duration{time.Minute, 5 * time.Minute, time.Hour}
+	}
+	return &CandleAggregator{intervals: intervals, open: make(map[candleKey]*Candle), flushed: make(map[candleKey]time.Time)}
+}
+
+// Add adds trade to the candle of each interval and returns the
+// candles trade completes, trades older than the open candle or of a
+// flushed one are ignored.
+func (aggregator *CandleAggregator) Add(trade TradeEvent) []Candle {
+	aggregator.lock.Lock()
+	defer aggregator.lock.Unlock()
+	completed := []Candle{}
+	for _, interval := range aggregator.intervals {
+		key := candleKey{trade.Pair, interval}
+		start := trade.Time.Truncate(interval)
+		candle := aggregator.open[key]
+		if candle != nil && start.Before(candle.Time) {
+			continue
+		}
+		if flushed, ok := aggregator.flushed[key]; ok && !start.After(flushed) {
+			continue
+		}
+		if candle != nil && start.After(candle.Time) {
+			completed = append(completed, *candle)
+			candle = nil
+		}
+		if candle == nil {
+			candle = &Candle{Pair: trade.Pair, Interval: interval, Time: start, Open: trade.Price,
+				High: trade.Price, Low: trade.Price, Local: true}
+			aggregator.open[key] = candle
+		}
+		if trade.Price > candle.High {
+			candle.High = trade.Price
+		}
+		if trade.Price < candle.Low {
+			candle.Low = trade.Price
+		}
+		candle.Close = trade.Price
+		candle.Volume += trade.Amount
+	}
+	sortCandles(completed)
+	return completed
+}
+
+// Flush returns and forgets the open candles whose interval has ended
+// before now.
+func (aggregator *CandleAggregator) Flush(now time.Time) []Candle {
+	aggregator.lock.Lock()
+	defer aggregator.lock.Unlock()
+	completed := []Candle{}
+	for key, candle := range aggregator.open {
+		if !now.Before(candle.Time.Add(candle.Interval)) {
+			completed = append(completed, *candle)
+			delete(aggregator.open, key)
+			aggregator.flushed[key] = candle.Time
+		}
+	}
+	sortCandles(completed)
+	return completed
+}
+
+func sortCandles(candles []Candle) {
+	sort.Slice(candles, func(i, j int) bool {
+		if candles[i].Pair != candles[j].Pair {
+			return candles[i].Pair < candles[j].Pair
+		}
+		return candles[i].Interval < candles[j].Interval
+	})
+}
+
+func (candle *Candle) getBuffer() []byte {
+	builder := flatbuffers.NewBuilder(128)
+	pair := builder.CreateString(candle.Pair)
+	buffer.CandleStart(builder)
+	buffer.CandleAddPair(builder, pair)
+	buffer.CandleAddInterval(builder, int32(candle.Interval/time.Second))
+	buffer.CandleAddTime(builder, candle.Time.UnixNano()/int64(time.Millisecond))
+	buffer.CandleAddOpen(builder, int64(candle.Open))
+	buffer.CandleAddHigh(builder, int64(candle.High))
+	buffer.CandleAddLow(builder, int64(candle.Low))
+	buffer.CandleAddClose(builder, int64(candle.Close))
+	buffer.CandleAddVolume(builder, int64(candle.Volume))
+	buffer.CandleAddLocal(builder, candle.Local)
+	builder.FinishWithFileIdentifier(buffer.CandleEnd(builder), []byte(kCandleIdentifier))
+	return builder.FinishedBytes()
+}
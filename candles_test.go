@@ -0,0 +1,127 @@
+package cexio
+
+import (
+	"github.com/sahmad98/cex.io/cexiotest"
+	buffer "github.com/sahmad98/cex.io/types"
+	"testing"
+	"time"
+)
+
+func receiveCandle(t *testing.T, candles chan Candle) Candle {
+	select {
+	case candle := <-candles:
+		return candle
+	case <-time.After(2 * time.Second):
+		t.Fatal("No candle received")
+	}
+	return Candle{}
+}
+
+func TestSubscribeCandles(t *testing.T) {
+	server := newHandledServer(t, func(request cexiotest.Request, conn *cexiotest.Conn) {
+		if request.Type == "init-ohlcv" {
+			conn.Send(map[string]interface{}{"e": "init-ohlcv-new", "pair": "BTC:USD", "data": [][]interface{}{
+				{1457519400, "414.5", "415", "414", "414.75", 150000000},
+				{1457519700, 414.75, 416, 414.5, 415.25, 20000000}}})
+			conn.Send(map[string]interface{}{"e": "ohlcv1m", "data": map[string]interface{}{
+				"pair": "BTC:USD", "time": "1457520000", "o": "415.25", "h": "415.5", "l": "415", "c": "415.5", "v": 5000000}})
+		}
+	})
+	context := newTestContext(t, server)
+	md := NewMarketDataAdapter(context)
+	candles := make(chan Candle, 16)
+	md.OnCandle("BTC:USD", func(candle Candle) { candles <- candle })
+
+	if error := md.SubscribeCandles("BTC", "USD", 7*time.Minute); error == nil {
+		t.Fatal("Unsupported interval accepted")
+	}
+	if error := md.SubscribeCandles("BTC", "USD", 5*time.Minute); error != nil {
+		t.Fatal(error)
+	}
+	first := receiveCandle(t, candles)
+	if first.Interval != 5*time.Minute || first.Time.Unix() != 1457519400 || first.Open != d("414.5") ||
+		first.High != d("415") || first.Close != d("414.75") || first.Volume != d("1.5") || first.Local {
+		t.Fatalf("Unexpected candle %+v", first)
+	}
+	if second := receiveCandle(t, candles); second.High != d("416") || second.Volume != d("0.2") {
+		t.Fatalf("Unexpected candle %+v", second)
+	}
+	if minute := receiveCandle(t, candles); minute.Interval != time.Minute || minute.Close != d("415.5") || minute.Volume != d("0.05") {
+		t.Fatalf("Unexpected ohlcv1m candle %+v", minute)
+	}
+}
+
+func TestCandleAggregator(t *testing.T) {
+	aggregator := NewCandleAggregator(time.Minute, 5*time.Minute)
+	start := time.Unix(1457519400, 0)
+	trade := func(offset time.Duration, price, amount string) []Candle {
+		return aggregator.Add(TradeEvent{Pair: "BTC:USD", Price: d(price), Amount: d(amount), Time: start.Add(offset)})
+	}
+	trade(0, "100", "1")
+	trade(10*time.Second, "102", "0.5")
+	trade(20*time.Second, "99", "0.25")
+	if completed := trade(-time.Minute, "50", "1"); len(completed) != 0 {
+		t.Fatalf("Late trade completed %v", completed)
+	}
+	completed := trade(time.Minute, "101", "1")
+	if len(completed) != 1 {
+		t.Fatalf("Expected one completed candle, got %v", completed)
+	}
+	candle := completed[0]
+	if candle.Interval != time.Minute || !candle.Time.Equal(start) || candle.Open != d("100") || candle.High != d("102") ||
+		candle.Low != d("99") || candle.Close != d("99") || candle.Volume != d("1.75") || !candle.Local {
+		t.Fatalf("Unexpected candle %+v", candle)
+	}
+
+	completed = aggregator.Flush(start.Add(5 * time.Minute))
+	if len(completed) != 2 || completed[0].Interval != time.Minute || completed[1].Interval != 5*time.Minute {
+		t.Fatalf("Unexpected flushed candles %+v", completed)
+	}
+	if five := completed[1]; five.Open != d("100") || five.Close != d("101") || five.Volume != d("2.75") {
+		t.Fatalf("Unexpected 5m candle %+v", five)
+	}
+	if completed := aggregator.Flush(start.Add(time.Hour)); len(completed) != 0 {
+		t.Fatalf("Candles flushed twice %v", completed)
+	}
+}
+
+func TestLocalCandlesFallback(t *testing.T) {
+	md := newMarketDataAdapter(&Context{SendChannel: make(chan Message, 16)})
+	candles := make(chan Candle, 16)
+	md.OnCandle("", func(candle Candle) { candles <- candle })
+	subscription := md.AggregateCandles("BTC:USD", time.Minute)
+	defer subscription.Unsubscribe()
+
+	start := time.Now().Truncate(time.Minute).Add(-10 * time.Minute)
+	md.publish(kTopicTrade, "BTC:USD", TradeEvent{Pair: "BTC:USD", Price: d("100"), Amount: d("1"), Time: start})
+	md.publish(kTopicTrade, "BTC:USD", TradeEvent{Pair: "BTC:USD", Price: d("101"), Amount: d("1"), Time: start.Add(time.Minute)})
+	if candle := receiveCandle(t, candles); !candle.Local || !candle.Time.Equal(start) || candle.Close != d("100") {
+		t.Fatalf("Unexpected local candle %+v", candle)
+	}
+
+	// Server candles take over
+	md.handleCandles([]Candle{{Pair: "BTC:USD", Interval: time.Minute, Time: start.Add(time.Minute), Close: d("101")}})
+	if candle := receiveCandle(t, candles); candle.Local {
+		t.Fatalf("Unexpected candle %+v", candle)
+	}
+	md.publish(kTopicTrade, "BTC:USD", TradeEvent{Pair: "BTC:USD", Price: d("102"), Amount: d("1"), Time: start.Add(2 * time.Minute)})
+	select {
+	case candle := <-candles:
+		t.Fatalf("Local candle published next to server candles %+v", candle)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestCandleBuffer(t *testing.T) {
+	candle := Candle{Pair: "BTC:USD", Interval: 5 * time.Minute, Time: time.Unix(1457519400, 0), Open: d("414.5"),
+		High: d("415"), Low: d("414"), Close: d("414.75"), Volume: d("1.5"), Local: true}
+	data := candle.getBuffer()
+	if string(data[4:8]) != "CNDL" {
+		t.Fatalf("Unexpected file identifier %q", data[4:8])
+	}
+	decoded := buffer.GetRootAsCandle(data, 0)
+	if string(decoded.Pair()) != "BTC:USD" || decoded.Interval() != 300 || decoded.Time() != 1457519400000 ||
+		Decimal(decoded.High()) != d("415") || Decimal(decoded.Volume()) != d("1.5") || !decoded.Local() {
+		t.Fatalf("Unexpected buffer contents")
+	}
+}
@@ -0,0 +1,715 @@
+// Package cexiotest runs a fake CEX.IO websocket server, so that
+// clients of the exchange can be tested offline:
+//
+//	server := cexiotest.NewServer()
+//	defer server.Close()
+//	server.SetCredentials("key", "secret")
+//	server.SetBook("BTC:USD", 1, []cexiotest.Level{{"100", "1"}}, []cexiotest.Level{{"101", "1"}})
+//	context, _ := cexio.NewContext(cexio.Options{Endpoint: server.URL, Key: "key", Secret: "secret"})
+//
+// It does not import the client, so the tests of the client can use it.
+package cexiotest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Max difference between the timestamp of an auth request and the
+// clock of the server.
+const kAuthWindow = time.Minute
+
+// Level of a book as price and amount. An amount of 0 in an update
+// removes the level.
+type Level [2]string
+
+// Update of a book pushed as md_update.
+type Update struct {
+	Bids []Level
+	Asks []Level
+}
+
+// Ticker answered to ticker requests of a pair.
+type Ticker struct {
+	Last   string
+	Low    string
+	High   string
+	Volume string
+	Bid    string
+	Ask    string
+}
+
+// Balance of a currency answered to get-balance.
+type Balance struct {
+	Available string
+	OnOrder   string
+}
+
+type book struct {
+	id   int
+	bids map[string]string
+	asks map[string]string
+	// Pushed after the next snapshot, see Script
+	script []Update
+	// Ids skipped by the next update, see Gap
+	gap int
+}
+
+// Levels of side, best first.
+func (book *book) levels(side map[string]string, descending bool, depth int) [][]json.Number {
+	prices := make([]string, 0, len(side))
+	for price := range side {
+		prices = append(prices, price)
+	}
+	sort.Slice(prices, func(i, j int) bool {
+		a, _ := strconv.ParseFloat(prices[i], 64)
+		b, _ := strconv.ParseFloat(prices[j], 64)
+		return (a > b) == descending
+	})
+	if depth > 0 && len(prices) > depth {
+		prices = prices[:depth]
+	}
+	levels := make([][]json.Number, 0, len(prices))
+	for _, price := range prices {
+		levels = append(levels, []json.Number{json.Number(price), json.Number(side[price])})
+	}
+	return levels
+}
+
+func applyLevels(side map[string]string, levels []Level) {
+	for _, level := range levels {
+		if amount, _ := strconv.ParseFloat(level[1], 64); amount == 0 {
+			delete(side, level[0])
+		} else {
+			side[level[0]] = level[1]
+		}
+	}
+}
+
+func encodeLevels(levels []Level) [][]json.Number {
+	encoded := make([][]json.Number, 0, len(levels))
+	for _, level := range levels {
+		encoded = append(encoded, []json.Number{json.Number(level[0]), json.Number(level[1])})
+	}
+	return encoded
+}
+
+// Request as received by the server, see Handle.
+type Request struct {
+	Type string          `json:"e"`
+	Data json.RawMessage `json:"data"`
+	Oid  string          `json:"oid"`
+}
+
+// Handler answers a request instead of the server, see Handle. It runs
+// on the read loop of the connection, so its replies and pushes arrive
+// in the order they are sent.
+type Handler func(request Request, conn *Conn)
+
+// Conn is the connection a request was received on, see Handler. It
+// stays usable after the handler returns, e.g. to answer late.
+type Conn struct {
+	connection *connection
+	request    request
+}
+
+// Reply answers the request with data.
+func (conn *Conn) Reply(data interface{}) {
+	conn.connection.reply(conn.request, data)
+}
+
+// Fail answers the request with an error.
+func (conn *Conn) Fail(message string) {
+	conn.connection.fail(conn.request, message)
+}
+
+// Send pushes message as is, e.g. a map with the fields of a push.
+func (conn *Conn) Send(message interface{}) {
+	conn.connection.send(message)
+}
+
+type order struct {
+	id     string
+	pair   []string
+	kind   string
+	amount string
+	price  string
+	time   int64
+}
+
+// Server is a fake CEX.IO websocket server. It checks auth signatures,
+// serves order books, tickers, balances and orders, and injects faults
+// on request. Other requests are answered by handlers, see Handle. It
+// is safe for concurrent use.
+type Server struct {
+	// Websocket endpoint of the server, like ws://127.0.0.1:port
+	URL string
+
+	server *httptest.Server
+	lock   sync.Mutex
+	// Secrets by key
+	secrets     map[string]string
+	books       map[string]*book
+	tickers     map[string]Ticker
+	balances    map[string]Balance
+	orders      map[string]*order
+	last_order  int
+	connections map[*connection]struct{}
+	accepted    int
+	requests    map[string]int
+	// Handlers by request type, see Handle
+	handlers map[string]Handler
+	// Faults, see SetLatency, CorruptNext and DropAfter
+	latency time.Duration
+	corrupt int
+	drops   map[string]bool
+}
+
+// NewServer starts a server on a local port, see URL.
+func NewServer() *Server {
+	server := &Server{
+		secrets:     make(map[string]string),
+		books:       make(map[string]*book),
+		tickers:     make(map[string]Ticker),
+		balances:    make(map[string]Balance),
+		orders:      make(map[string]*order),
+		connections: make(map[*connection]struct{}),
+		requests:    make(map[string]int),
+		handlers:    make(map[string]Handler),
+		drops:       make(map[string]bool),
+	}
+	server.server = httptest.NewServer(http.HandlerFunc(server.serve))
+	server.URL = "ws" + strings.TrimPrefix(server.server.URL, "http")
+	return server
+}
+
+// Close drops every connection and stops the server.
+func (server *Server) Close() {
+	server.Disconnect()
+	server.server.Close()
+}
+
+// SetCredentials accepts auth requests signed with key and secret.
+func (server *Server) SetCredentials(key, secret string) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	server.secrets[key] = secret
+}
+
+// SetBook replaces the book of pair, like "BTC:USD", served to
+// order-book-subscribe. Updates continue from id.
+func (server *Server) SetBook(pair string, id int, bids, asks []Level) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	book := &book{id: id, bids: make(map[string]string), asks: make(map[string]string)}
+	if previous, ok := server.books[pair]; ok {
+		book.script, book.gap = previous.script, previous.gap
+	}
+	applyLevels(book.bids, bids)
+	applyLevels(book.asks, asks)
+	server.books[pair] = book
+}
+
+// Script pushes updates of pair right after the next snapshot of it,
+// as a subscriber would receive them from the exchange.
+func (server *Server) Script(pair string, updates ...Update) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	book := server.book(pair)
+	book.script = append(book.script, updates...)
+}
+
+// Update applies update to the book of pair and pushes it to its
+// subscribers with the next id.
+func (server *Server) Update(pair string, update Update) {
+	server.lock.Lock()
+	message, subscribers := server.applyUpdate(pair, update)
+	server.lock.Unlock()
+	for _, connection := range subscribers {
+		connection.send(message)
+	}
+}
+
+// Gap makes the next update of pair skip skipped ids, like updates
+// lost by the exchange.
+func (server *Server) Gap(pair string, skipped int) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	server.book(pair).gap += skipped
+}
+
+func (server *Server) SetTicker(pair string, ticker Ticker) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	server.tickers[pair] = ticker
+}
+
+func (server *Server) SetBalance(currency string, balance Balance) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	server.balances[currency] = balance
+}
+
+// Handle answers requests of request_type with handler instead of the
+// server, authenticated or not. A handler of the empty type answers
+// every request without a handler of its own.
+func (server *Server) Handle(request_type string, handler Handler) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	server.handlers[request_type] = handler
+}
+
+// SetLatency delays every response by latency, pushes are not delayed.
+func (server *Server) SetLatency(latency time.Duration) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	server.latency = latency
+}
+
+// CorruptNext sends the next count frames as malformed JSON.
+func (server *Server) CorruptNext(count int) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	server.corrupt += count
+}
+
+// DropAfter drops the connection that sends the next request of
+// request_type, right after answering it.
+func (server *Server) DropAfter(request_type string) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	server.drops[request_type] = true
+}
+
+// Disconnect drops every connection, clients see a lost connection.
+func (server *Server) Disconnect() {
+	for _, connection := range server.current() {
+		connection.close()
+	}
+}
+
+// SendRaw pushes frame as is to every connection, e.g. malformed JSON.
+func (server *Server) SendRaw(frame []byte) {
+	for _, connection := range server.current() {
+		connection.write(frame)
+	}
+}
+
+// Ping pings every connection, clients are expected to answer with a
+// pong, see Requests.
+func (server *Server) Ping() {
+	for _, connection := range server.current() {
+		connection.send(map[string]string{"e": "ping", "time": strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)})
+	}
+}
+
+// Requests returns how many requests of request_type were received,
+// e.g. "auth" or "pong".
+func (server *Server) Requests(request_type string) int {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	return server.requests[request_type]
+}
+
+// Connections returns how many connections were accepted so far.
+func (server *Server) Connections() int {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	return server.accepted
+}
+
+func (server *Server) current() []*connection {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	connections := make([]*connection, 0, len(server.connections))
+	for connection := range server.connections {
+		connections = append(connections, connection)
+	}
+	return connections
+}
+
+// Requires lock to be held.
+func (server *Server) book(pair string) *book {
+	if book, ok := server.books[pair]; ok {
+		return book
+	}
+	book := &book{bids: make(map[string]string), asks: make(map[string]string)}
+	server.books[pair] = book
+	return book
+}
+
+// Requires lock to be held.
+func (server *Server) applyUpdate(pair string, update Update) (interface{}, []*connection) {
+	book := server.book(pair)
+	book.id += 1 + book.gap
+	book.gap = 0
+	applyLevels(book.bids, update.Bids)
+	applyLevels(book.asks, update.Asks)
+	message := map[string]interface{}{"e": "md_update", "data": map[string]interface{}{
+		"id":   book.id,
+		"pair": pair,
+		"time": time.Now().UnixNano() / int64(time.Millisecond),
+		"bids": encodeLevels(update.Bids),
+		"asks": encodeLevels(update.Asks),
+	}}
+	subscribers := []*connection{}
+	for connection := range server.connections {
+		if connection.subscribed[pair] {
+			subscribers = append(subscribers, connection)
+		}
+	}
+	return message, subscribers
+}
+
+func (server *Server) serve(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{}
+	conn, error := upgrader.Upgrade(w, r, nil)
+	if error != nil {
+		return
+	}
+	connection := &connection{server: server, conn: conn, subscribed: make(map[string]bool)}
+	server.lock.Lock()
+	server.connections[connection] = struct{}{}
+	server.accepted++
+	server.lock.Unlock()
+	defer func() {
+		server.lock.Lock()
+		delete(server.connections, connection)
+		server.lock.Unlock()
+		connection.close()
+	}()
+
+	connection.send(map[string]string{"e": "connected"})
+	for {
+		_, frame, error := conn.ReadMessage()
+		if error != nil {
+			return
+		}
+		request := request{}
+		if json.Unmarshal(frame, &request) != nil {
+			continue
+		}
+		server.lock.Lock()
+		server.requests[request.Type]++
+		latency := server.latency
+		drop := server.drops[request.Type]
+		delete(server.drops, request.Type)
+		server.lock.Unlock()
+		if latency > 0 {
+			time.Sleep(latency)
+		}
+		connection.handle(request)
+		if drop {
+			return
+		}
+	}
+}
+
+type request struct {
+	Type string          `json:"e"`
+	Data json.RawMessage `json:"data"`
+	Oid  string          `json:"oid"`
+	Auth struct {
+		Key       string `json:"key"`
+		Signature string `json:"signature"`
+		Timestamp int64  `json:"timestamp"`
+	} `json:"auth"`
+}
+
+// Number sent either as JSON number or string.
+type number string
+
+func (value *number) UnmarshalJSON(data []byte) error {
+	text := strings.Trim(string(data), `"`)
+	if _, error := strconv.ParseFloat(text, 64); error != nil {
+		return fmt.Errorf("invalid number %s", data)
+	}
+	*value = number(text)
+	return nil
+}
+
+type orderRequest struct {
+	OrderId string   `json:"order_id"`
+	Pair    []string `json:"pair"`
+	Amount  number   `json:"amount"`
+	Price   number   `json:"price"`
+	Type    string   `json:"type"`
+}
+
+type connection struct {
+	server *Server
+	conn   *websocket.Conn
+	// Guards writes to conn
+	lock sync.Mutex
+	// Only accessed by the read loop, and by the server under its lock
+	authenticated bool
+	subscribed    map[string]bool
+}
+
+func (connection *connection) send(message interface{}) {
+	frame, error := json.Marshal(message)
+	if error != nil {
+		return
+	}
+	connection.write(frame)
+}
+
+func (connection *connection) write(frame []byte) {
+	server := connection.server
+	server.lock.Lock()
+	if server.corrupt > 0 {
+		server.corrupt--
+		frame = frame[:len(frame)/2]
+	}
+	server.lock.Unlock()
+	connection.lock.Lock()
+	defer connection.lock.Unlock()
+	connection.conn.WriteMessage(websocket.TextMessage, frame)
+}
+
+func (connection *connection) close() {
+	connection.conn.Close()
+}
+
+func (connection *connection) reply(request request, data interface{}) {
+	connection.send(map[string]interface{}{"e": request.Type, "data": data, "oid": request.Oid, "ok": "ok"})
+}
+
+func (connection *connection) fail(request request, message string) {
+	connection.send(map[string]interface{}{"e": request.Type, "data": map[string]string{"error": message}, "oid": request.Oid, "ok": "error"})
+}
+
+func (connection *connection) handle(request request) {
+	server := connection.server
+	server.lock.Lock()
+	handler, ok := server.handlers[request.Type]
+	if !ok {
+		handler, ok = server.handlers[""]
+	}
+	server.lock.Unlock()
+	if ok {
+		handler(Request{request.Type, request.Data, request.Oid}, &Conn{connection, request})
+		return
+	}
+	switch request.Type {
+	case "auth":
+		connection.authenticate(request)
+		return
+	case "pong", "subscribe":
+		return
+	case "order-book-subscribe":
+		connection.subscribe(request)
+		return
+	case "order-book-unsubscribe":
+		connection.unsubscribe(request)
+		return
+	case "ticker":
+		connection.ticker(request)
+		return
+	}
+	if !connection.authenticated {
+		connection.fail(request, "Please Login")
+		return
+	}
+	switch request.Type {
+	case "get-balance":
+		connection.balance(request)
+	case "place-order", "cancel-order", "cancel-replace-order", "get-order", "open-orders":
+		connection.order(request)
+	default:
+		connection.fail(request, "Unknown request "+request.Type)
+	}
+}
+
+func (connection *connection) authenticate(request request) {
+	auth := request.Auth
+	connection.server.lock.Lock()
+	secret, ok := connection.server.secrets[auth.Key]
+	connection.server.lock.Unlock()
+	if !ok {
+		connection.fail(request, "Invalid API key")
+		return
+	}
+	skew := time.Since(time.Unix(auth.Timestamp, 0))
+	if skew > kAuthWindow || skew < -kAuthWindow {
+		connection.fail(request, "Timestamp is not in range")
+		return
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(auth.Timestamp, 10) + auth.Key))
+	if !strings.EqualFold(auth.Signature, hex.EncodeToString(mac.Sum(nil))) {
+		connection.fail(request, "Invalid signature")
+		return
+	}
+	connection.authenticated = true
+	connection.reply(request, map[string]string{"ok": "ok"})
+}
+
+// Returns the pair of a request like ["BTC","USD"] as "BTC:USD".
+func requestPair(pair []string) (string, bool) {
+	if len(pair) != 2 {
+		return "", false
+	}
+	return strings.ToUpper(pair[0]) + ":" + strings.ToUpper(pair[1]), true
+}
+
+func (connection *connection) subscribe(request request) {
+	data := struct {
+		Pair      []string `json:"pair"`
+		Subscribe bool     `json:"subscribe"`
+		Depth     int      `json:"depth"`
+	}{}
+	json.Unmarshal(request.Data, &data)
+	pair, ok := requestPair(data.Pair)
+	server := connection.server
+	server.lock.Lock()
+	book, known := server.books[pair]
+	if !ok || !known {
+		server.lock.Unlock()
+		connection.fail(request, "Unknown pair")
+		return
+	}
+	snapshot := map[string]interface{}{
+		"timestamp": time.Now().Unix(),
+		"pair":      pair,
+		"id":        book.id,
+		"bids":      book.levels(book.bids, true, data.Depth),
+		"asks":      book.levels(book.asks, false, data.Depth),
+	}
+	if data.Subscribe {
+		connection.subscribed[pair] = true
+	}
+	script := book.script
+	book.script = nil
+	server.lock.Unlock()
+
+	connection.reply(request, snapshot)
+	for _, update := range script {
+		server.Update(pair, update)
+	}
+}
+
+func (connection *connection) unsubscribe(request request) {
+	data := struct {
+		Pair []string `json:"pair"`
+	}{}
+	json.Unmarshal(request.Data, &data)
+	pair, ok := requestPair(data.Pair)
+	if !ok {
+		connection.fail(request, "Unknown pair")
+		return
+	}
+	connection.server.lock.Lock()
+	delete(connection.subscribed, pair)
+	connection.server.lock.Unlock()
+	connection.reply(request, map[string]string{"pair": pair})
+}
+
+func (connection *connection) ticker(request request) {
+	symbols := []string{}
+	json.Unmarshal(request.Data, &symbols)
+	pair, ok := requestPair(symbols)
+	connection.server.lock.Lock()
+	ticker, known := connection.server.tickers[pair]
+	connection.server.lock.Unlock()
+	if !ok || !known {
+		connection.fail(request, "Unknown pair")
+		return
+	}
+	connection.reply(request, map[string]interface{}{
+		"timestamp": strconv.FormatInt(time.Now().Unix(), 10),
+		"pair":      symbols,
+		"last":      ticker.Last,
+		"low":       ticker.Low,
+		"high":      ticker.High,
+		"volume":    ticker.Volume,
+		"bid":       ticker.Bid,
+		"ask":       ticker.Ask,
+	})
+}
+
+func (connection *connection) balance(request request) {
+	available, on_order := map[string]string{}, map[string]string{}
+	connection.server.lock.Lock()
+	for currency, balance := range connection.server.balances {
+		available[currency], on_order[currency] = balance.Available, balance.OnOrder
+	}
+	connection.server.lock.Unlock()
+	connection.reply(request, map[string]interface{}{"balance": available, "obalance": on_order})
+}
+
+func (connection *connection) order(request request) {
+	data := orderRequest{}
+	if error := json.Unmarshal(request.Data, &data); error != nil {
+		connection.fail(request, error.Error())
+		return
+	}
+	server := connection.server
+	server.lock.Lock()
+	existing, found := server.orders[data.OrderId]
+	switch request.Type {
+	case "get-order":
+		server.lock.Unlock()
+		if !found {
+			connection.fail(request, "Error: Order not found")
+			return
+		}
+		connection.reply(request, map[string]interface{}{
+			"orderId": existing.id, "type": existing.kind, "symbol1": existing.pair[0], "symbol2": existing.pair[1],
+			"amount": existing.amount, "remains": existing.amount, "price": existing.price, "time": existing.time, "status": "a",
+		})
+		return
+	case "open-orders":
+		pair, _ := requestPair(data.Pair)
+		orders := []map[string]interface{}{}
+		for _, open := range server.orders {
+			if open_pair, _ := requestPair(open.pair); open_pair == pair {
+				orders = append(orders, map[string]interface{}{"id": open.id, "time": strconv.FormatInt(open.time, 10),
+					"type": open.kind, "price": open.price, "amount": open.amount, "pending": open.amount})
+			}
+		}
+		server.lock.Unlock()
+		sort.Slice(orders, func(i, j int) bool { return orders[i]["time"].(string) < orders[j]["time"].(string) })
+		connection.reply(request, orders)
+		return
+	case "cancel-order":
+		delete(server.orders, data.OrderId)
+		server.lock.Unlock()
+		if !found {
+			connection.fail(request, "Error: Order not found")
+			return
+		}
+		connection.reply(request, map[string]string{"order_id": existing.id, "fremains": existing.amount})
+		return
+	case "cancel-replace-order":
+		if !found {
+			server.lock.Unlock()
+			connection.fail(request, "Error: Order not found")
+			return
+		}
+		delete(server.orders, data.OrderId)
+	}
+	if _, ok := requestPair(data.Pair); !ok || (data.Type != "buy" && data.Type != "sell") || data.Amount == "" || data.Price == "" {
+		server.lock.Unlock()
+		connection.fail(request, "Invalid order")
+		return
+	}
+	server.last_order++
+	placed := &order{id: strconv.Itoa(server.last_order), pair: data.Pair, kind: data.Type,
+		amount: string(data.Amount), price: string(data.Price), time: time.Now().UnixNano() / int64(time.Millisecond)}
+	server.orders[placed.id] = placed
+	server.lock.Unlock()
+	connection.reply(request, map[string]interface{}{"complete": false, "id": placed.id, "time": placed.time,
+		"pending": placed.amount, "amount": placed.amount, "type": placed.kind, "price": placed.price})
+}
@@ -0,0 +1,251 @@
+package cexio
+
+import (
+	gocontext "context"
+	"math/rand"
+	"time"
+)
+
+// Bounds of the exponential reconnect backoff.
+var RECONNECT_MIN_BACKOFF = 500 * time.Millisecond
+var RECONNECT_MAX_BACKOFF = 30 * time.Second
+
+// The server pings idle connections every 15 seconds, a connection
+// without any message for this long is considered dead.
+var PING_TIMEOUT = 45 * time.Second
+
+// Longest wait for the server to answer a close frame.
+var CLOSE_TIMEOUT = time.Second
+
+type ConnectionState int
+
+const (
+	StateDisconnected ConnectionState = iota
+	StateConnecting
+	StateConnected
+	StateClosed
+)
+
+func (state ConnectionState) String() string {
+	switch state {
+	case StateDisconnected:
+		return "disconnected"
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// Called on every connection state change.
+type StateFunc func(state ConnectionState)
+
+// Jittered exponential backoff for the given reconnect attempt,
+// somewhere between half and all of the exponential delay.
+func reconnectBackoff(attempt int) time.Duration {
+	delay := RECONNECT_MAX_BACKOFF
+	if attempt < 32 && RECONNECT_MIN_BACKOFF<<uint(attempt) < RECONNECT_MAX_BACKOFF {
+		delay = RECONNECT_MIN_BACKOFF << uint(attempt)
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func (context *Context) setState(state ConnectionState) {
+	context.connectionLock.Lock()
+	if context.state == state || context.state == StateClosed {
+		context.connectionLock.Unlock()
+		return
+	}
+	context.state = state
+	handler := context.StateHandler
+	context.connectionLock.Unlock()
+	context.log().Infof("Connection %s", state)
+	if handler != nil {
+		handler(state)
+	}
+}
+
+// State returns the current connection state.
+func (context *Context) State() ConnectionState {
+	context.connectionLock.Lock()
+	defer context.connectionLock.Unlock()
+	return context.state
+}
+
+// OnReconnect registers a function run after every successful
+// reconnect, once authentication has been replayed.
+func (context *Context) OnReconnect(hook func()) {
+	context.connectionLock.Lock()
+	context.reconnectHooks = append(context.reconnectHooks, hook)
+	context.connectionLock.Unlock()
+}
+
+// Blocks until a connection is available, returns nil once the
+// context is closed.
+func (context *Context) waitConnection() Transport {
+	for {
+		context.connectionLock.Lock()
+		connection, up, state := context.Connection, context.up, context.state
+		context.connectionLock.Unlock()
+		if state == StateClosed {
+			return nil
+		}
+		if connection != nil {
+			return connection
+		}
+		<-up
+	}
+}
+
+// Reports a failure on connection. Failures of a connection that was
+// already replaced are ignored.
+func (context *Context) connectionLost(connection Transport, error error) {
+	context.connectionLock.Lock()
+	if context.Connection != connection || context.state == StateClosed {
+		context.connectionLock.Unlock()
+		return
+	}
+	context.Connection = nil
+	context.up = make(chan struct{})
+	context.connectionLock.Unlock()
+	connection.Close()
+	context.log().Errorf("Connection lost: %s", error)
+	context.setState(StateDisconnected)
+	select {
+	case context.reconnect <- struct{}{}:
+	default:
+	}
+}
+
+func (context *Context) connected(connection Transport) {
+	context.connectionLock.Lock()
+	if context.state == StateClosed {
+		context.connectionLock.Unlock()
+		connection.Close()
+		return
+	}
+	context.Connection = connection
+	close(context.up)
+	context.connectionLock.Unlock()
+	context.setState(StateConnected)
+}
+
+// Closes the connection for good and wakes up everybody waiting on it.
+func (context *Context) closeConnection() {
+	context.setState(StateClosed)
+	context.connectionLock.Lock()
+	if context.Connection != nil {
+		context.Connection.Close()
+	} else if context.up != nil {
+		close(context.up)
+	}
+	context.connectionLock.Unlock()
+}
+
+// Sends a close frame and waits for the reader to see the server
+// close, so that nothing written before is lost to a reset.
+func (context *Context) closeHandshake(ctx gocontext.Context) {
+	context.setState(StateClosed)
+	context.connectionLock.Lock()
+	connection := context.Connection
+	context.connectionLock.Unlock()
+	closer, graceful := connection.(gracefulCloser)
+	if !graceful {
+		return
+	}
+	deadline := time.Now().Add(CLOSE_TIMEOUT)
+	if ctx_deadline, ok := ctx.Deadline(); ok && ctx_deadline.Before(deadline) {
+		deadline = ctx_deadline
+	}
+	if closer.closeGracefully(deadline) != nil {
+		return
+	}
+	timeout := time.NewTimer(time.Until(deadline))
+	defer timeout.Stop()
+	select {
+	case <-context.readerDone:
+	case <-timeout.C:
+	case <-ctx.Done():
+	}
+}
+
+func (context *Context) dial() (Transport, error) {
+	if context.options.Dial != nil {
+		return context.options.Dial(context.lifetime)
+	}
+	return dialWebsocket(context.lifetime, context.options)
+}
+
+// Dials the endpoint once. On failure the supervisor keeps retrying
+// in the background if Options.RetryFirstDial is set, else the error
+// is returned.
+func initConnection(context *Context) error {
+	context.up = make(chan struct{})
+	context.reconnect = make(chan struct{}, 1)
+	context.setState(StateConnecting)
+	connection, error := context.dial()
+	if error != nil {
+		context.log().Errorf("Error opening websocket connection: %s", error)
+		context.setState(StateDisconnected)
+		if !context.options.RetryFirstDial {
+			return error
+		}
+		context.reconnect <- struct{}{}
+		return nil
+	}
+	context.connected(connection)
+	return nil
+}
+
+// Reconnects with backoff whenever the connection is lost, then
+// replays authentication and the registered reconnect hooks.
+func runConnectionSupervisor(context *Context) {
+	for {
+		select {
+		case <-context.reconnect:
+		case <-context.done:
+			return
+		}
+		for attempt := 0; context.State() != StateClosed; attempt++ {
+			delay := reconnectBackoff(attempt)
+			context.log().Infof("Reconnecting in %s", delay)
+			select {
+			case <-time.After(delay):
+			case <-context.done:
+				return
+			}
+			context.setState(StateConnecting)
+			connection, error := context.dial()
+			if error != nil {
+				context.log().Errorf("Reconnect failed: %s", error)
+				context.setState(StateDisconnected)
+				continue
+			}
+			context.connected(connection)
+			break
+		}
+		if context.State() == StateClosed {
+			return
+		}
+		context.replay()
+	}
+}
+
+func (context *Context) replay() {
+	context.connectionLock.Lock()
+	authenticated := context.authenticated
+	hooks := append([]func(){}, context.reconnectHooks...)
+	context.connectionLock.Unlock()
+
+	if authenticated {
+		if error := context.Authenticate(); error != nil {
+			context.log().Errorf("Re-authentication failed: %s", error)
+		}
+	}
+	for _, hook := range hooks {
+		hook()
+	}
+}
@@ -0,0 +1,157 @@
+package cexio
+
+import (
+	gocontext "context"
+	"errors"
+	"github.com/gorilla/websocket"
+	"github.com/sahmad98/cex.io/cexiotest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReconnect(t *testing.T) {
+	backoff := RECONNECT_MIN_BACKOFF
+	RECONNECT_MIN_BACKOFF = 10 * time.Millisecond
+	defer func() { RECONNECT_MIN_BACKOFF = backoff }()
+
+	// Drops the first client right after its subscription
+	server := newFakeExchange(t)
+	server.DropAfter("order-book-subscribe")
+
+	var lock sync.Mutex
+	states := []ConnectionState{}
+	context, error := NewContext(Options{
+		Endpoint: server.URL,
+		Key:      "key",
+		Secret:   "secret",
+		StateHandler: func(state ConnectionState) {
+			lock.Lock()
+			states = append(states, state)
+			lock.Unlock()
+		},
+	})
+	if error != nil {
+		t.Fatal(error)
+	}
+	defer context.closeConnection()
+
+	md := NewMarketDataAdapter(context)
+	resynced := make(chan Orderbook, 1)
+	md.ResyncHandler = func(orderbook Orderbook) {
+		resynced <- orderbook
+	}
+	if error := context.Authenticate(); error != nil {
+		t.Fatal(error)
+	}
+	md.Subscribe("BTC", "USD", 5)
+
+	select {
+	case orderbook := <-resynced:
+		if orderbook.Pair != "BTC:USD" || orderbook.Stale {
+			t.Fatalf("Unexpected orderbook %+v", orderbook)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Orderbook not resynced after reconnect")
+	}
+
+	if server.Requests("auth") != 2 || server.Requests("order-book-subscribe") != 2 {
+		t.Fatalf("Expected auth and subscribe to be replayed, got %d and %d", server.Requests("auth"), server.Requests("order-book-subscribe"))
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	expected := []ConnectionState{StateConnecting, StateConnected, StateDisconnected, StateConnecting, StateConnected}
+	if len(states) != len(expected) {
+		t.Fatalf("Unexpected state changes %v", states)
+	}
+	for i := range expected {
+		if states[i] != expected[i] {
+			t.Fatalf("Unexpected state changes %v", states)
+		}
+	}
+}
+
+func TestFirstDialFails(t *testing.T) {
+	server := cexiotest.NewServer()
+	endpoint := server.URL
+	server.Close()
+	if context, error := NewContext(Options{Endpoint: endpoint}); error == nil || context != nil {
+		t.Fatalf("Expected a dial error, got %v", error)
+	}
+	dial_error := errors.New("dial failed")
+	dial := func(gocontext.Context) (Transport, error) { return nil, dial_error }
+	if _, error := NewContext(Options{Dial: dial}); !errors.Is(error, dial_error) {
+		t.Fatalf("Expected the dial error, got %v", error)
+	}
+}
+
+func TestRetryFirstDial(t *testing.T) {
+	backoff := RECONNECT_MIN_BACKOFF
+	RECONNECT_MIN_BACKOFF = 10 * time.Millisecond
+	defer func() { RECONNECT_MIN_BACKOFF = backoff }()
+
+	server := newFakeExchange(t)
+	dial_error := errors.New("dial failed")
+	dials := 0
+	connected := make(chan struct{}, 1)
+	context, error := NewContext(Options{
+		RetryFirstDial: true,
+		Dial: func(ctx gocontext.Context) (Transport, error) {
+			if dials++; dials == 1 {
+				return nil, dial_error
+			}
+			return dialWebsocket(ctx, Options{Endpoint: server.URL, Dialer: websocket.DefaultDialer})
+		},
+		StateHandler: func(state ConnectionState) {
+			if state == StateConnected {
+				connected <- struct{}{}
+			}
+		},
+	})
+	if error != nil {
+		t.Fatal(error)
+	}
+	defer context.closeConnection()
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("First dial not retried")
+	}
+}
+
+func TestReconnectOnMissedPing(t *testing.T) {
+	backoff, timeout := RECONNECT_MIN_BACKOFF, PING_TIMEOUT
+	RECONNECT_MIN_BACKOFF = 10 * time.Millisecond
+	PING_TIMEOUT = 100 * time.Millisecond
+	defer func() { RECONNECT_MIN_BACKOFF, PING_TIMEOUT = backoff, timeout }()
+
+	// Never pings
+	server := newFakeExchange(t)
+
+	context, error := NewContext(Options{Endpoint: server.URL})
+	if error != nil {
+		t.Fatal(error)
+	}
+	defer context.closeConnection()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if server.Connections() >= 2 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("No reconnect after missed pings")
+}
+
+func TestReconnectBackoff(t *testing.T) {
+	for attempt := 0; attempt < 64; attempt++ {
+		delay := reconnectBackoff(attempt)
+		if delay < RECONNECT_MIN_BACKOFF/2 || delay > RECONNECT_MAX_BACKOFF {
+			t.Fatalf("Backoff %s out of bounds for attempt %d", delay, attempt)
+		}
+	}
+	if reconnectBackoff(40) < RECONNECT_MAX_BACKOFF/2 {
+		t.Fatal("Backoff not capped at maximum")
+	}
+}
@@ -4,26 +4,29 @@
 package cexio
 
 import (
+	gocontext "context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/golang-collections/go-datastructures/queue"
 	"github.com/google/logger"
-	"github.com/gorilla/websocket"
-	"github.com/spf13/viper"
+	"io/ioutil"
 	"log"
-	"os"
 	"strconv"
+	"sync"
 	"time"
 )
 
-var WS_ENDPOINT = "wss://ws.cex.io/ws"
-var API_KEY = ""
-var API_SECRET = ""
-var LOG_PATH = "."
-var LOG_FILE = "marketdata.log"
-var l *logger.Logger = nil
+var REQUEST_TIMEOUT = 10 * time.Second
+
+var ErrClosed = errors.New("cexio: context closed")
+
+// Passed through both send queues to find out when everything queued
+// before it has been written.
+const kFlushMarker = "@flush"
 
 // Request/Response structure used to parse outgoing/incoming json
 // string into a golang structure.
@@ -39,153 +42,456 @@ type Message struct {
 		Pair      interface{} `json:"pair"`
 		Subscribe bool        `json:"subscribe"`
 		Depth     int         `json:"depth"`
-		Bids      [][]float32 `json:"bids"`
-		Asks      [][]float32 `json:"asks"`
-		Low       string      `json:"low"`
-		High      string      `json:"high"`
-		Last      string      `json:"last"`
-		Volume    string      `json:"volume"`
-		Volume30  string      `json:"volume30d"`
-		Bid       float32     `json:"bid"`
-		Ask       float32     `json:"ask"`
+		Bids      [][]Decimal `json:"bids"`
+		Asks      [][]Decimal `json:"asks"`
+		Low       Decimal     `json:"low"`
+		High      Decimal     `json:"high"`
+		Last      Decimal     `json:"last"`
+		Volume    Decimal     `json:"volume"`
+		Volume30  Decimal     `json:"volume30d"`
+		Bid       Decimal     `json:"bid"`
+		Ask       Decimal     `json:"ask"`
 		Ok        string      `json:"ok"`
 		Error     string      `json:"error"`
 		Timestamp int64       `json:"time"`
 	} `json:"data"`
+	Oid string `json:"oid,omitempty"`
 	// Custom data for performace calculations
 	RecvTimestamp int64
 }
 
 type Context struct {
-	Connection      *websocket.Conn
+	Connection      Transport
 	RecvChannel     *queue.RingBuffer
 	SendChannel     chan Message
 	SendJsonChannel chan []byte
 	Logger          *logger.Logger
-}
+	options         Options
+	risk            *RiskGate
+	recorder        *Recorder
+	sendQueue       *sendQueue
 
-func readConfig() {
-	viper.SetConfigName("config")
-	viper.AddConfigPath("./config")
-	viper.SetConfigType("toml")
-	err := viper.ReadInConfig()
-	if err != nil {
-		log.Fatal("Config Error: ", err)
-	}
+	// Requests waiting for a response, see request.go
+	pending     map[string]*Future
+	expired     map[string]struct{}
+	expiredOids []string
+	pendingLock sync.Mutex
+	oidCounter  uint64
+	stats       RequestStats
 
-	API_KEY = viper.GetString("auth.key")
-	API_SECRET = viper.GetString("auth.secret")
-	if viper.IsSet("log.path") {
-		LOG_PATH = viper.GetString("log.path")
-	}
-	if viper.IsSet("log.filename") {
-		LOG_FILE = viper.GetString("log.filename")
-	}
-	if viper.IsSet("websocket.endpoing") {
-		WS_ENDPOINT = viper.GetString("websocket.endpoint")
-	}
-}
+	// Handlers of server pushes by type and the balances they maintain
+	pushHandlers    map[string][]func(*Response)
+	pushLock        sync.RWMutex
+	balances        map[string]Balance
+	balanceUpdated  map[string]uint64
+	balanceSeq      uint64
+	balanceHandlers []func(BalanceEvent)
+	balanceLock     sync.Mutex
+	// Handlers of positions closed by the server
+	positionHandlers []func(ClosedPosition)
+	positionLock     sync.Mutex
 
-func initLogger() {
-	file_handle, err := os.Create(LOG_PATH + "/" + LOG_FILE)
-	if err != nil {
-		log.Printf("Error creating logfile: ", LOG_FILE)
-		panic(err)
-	}
-	l = logger.Init("", false, false, file_handle)
+	// Connection supervision, see connection.go
+	StateHandler   StateFunc
+	state          ConnectionState
+	authenticated  bool
+	up             chan struct{}
+	reconnect      chan struct{}
+	reconnectHooks []func()
+	connectionLock sync.Mutex
+
+	// Lifetime of the goroutines, see Close
+	lifetime   gocontext.Context
+	cancel     gocontext.CancelFunc
+	done       <-chan struct{}
+	routines   sync.WaitGroup
+	closeOnce  sync.Once
+	closeHooks []func()
+	flushed    chan struct{}
+	flushLock  sync.Mutex
+	readerDone chan struct{}
 }
 
-func initChannels(context *Context, q_size int) {
-	context.RecvChannel = queue.NewRingBuffer(16)
-	context.SendChannel = make(chan Message, q_size)
-	context.SendJsonChannel = make(chan []byte, q_size)
+// Used by contexts without a logger, only errors go to stderr
+var stderrLogger *logger.Logger
+var stderrOnce sync.Once
+
+// Returns a logger discarding infos and warnings. google/logger writes
+// errors to stderr besides the log file, so errors still show.
+func newStderrLogger() *logger.Logger {
+	return logger.Init("", false, false, ioutil.Discard)
 }
 
-func initConnection(context *Context) {
-	connection, _, error := websocket.DefaultDialer.Dial(WS_ENDPOINT, nil)
-	if error != nil {
-		l.Fatalf("Error opening websocket connection: %s", error)
-		panic(error)
+func (context *Context) log() *logger.Logger {
+	if context.Logger != nil {
+		return context.Logger
 	}
-	context.Connection = connection
+	stderrOnce.Do(func() {
+		stderrLogger = newStderrLogger()
+	})
+	return stderrLogger
 }
 
+// Goroutines of context stop once ctx is done or the context is closed.
+func initChannels(context *Context, ctx gocontext.Context) {
+	context.lifetime, context.cancel = gocontext.WithCancel(ctx)
+	context.done = context.lifetime.Done()
+	context.readerDone = make(chan struct{})
+	context.RecvChannel = queue.NewRingBuffer(uint64(context.options.RecvQueueSize))
+	context.SendChannel = make(chan Message, context.options.SendQueueSize)
+	context.SendJsonChannel = make(chan []byte, context.options.SendQueueSize)
+	context.sendQueue = newSendQueue(context.options)
+	context.pending = make(map[string]*Future)
+	context.expired = make(map[string]struct{})
+	context.balances = make(map[string]Balance)
+	context.balanceUpdated = make(map[string]uint64)
+	context.risk = NewRiskGate(context.options.Risk)
+	context.risk.balances = context.Balances
+	context.onPush("balance", context.handleBalancePush)
+	context.onPush("obalance", context.handleBalancePush)
+	context.onPush("tx", context.handleTransactionPush)
+	context.onPush("close-position", context.handlePositionClosedPush)
+}
+
+// Reads each connection until it fails, so that after Close the
+// reader still sees the end of the close handshake.
 func runWebsocketReader(context *Context) {
+	defer close(context.readerDone)
 	for {
-		_, message, error := context.Connection.ReadMessage()
-		l.Infof("RECV: %s", message)
-		if error != nil {
-			l.Errorf("Error reciveing messages: %s", error)
+		connection := context.waitConnection()
+		if connection == nil {
+			return
 		}
-		response := Message{}
-		error = json.Unmarshal(message, &response)
-		response.RecvTimestamp = time.Now().UnixNano()
-		if error != nil {
-			l.Errorf("Unable to parse response: %s", error)
-		} else {
-			context.RecvChannel.Put(&response)
+		for {
+			message, error := connection.ReadFrame()
+			if error != nil {
+				if context.State() == StateClosed {
+					return
+				}
+				context.log().Errorf("Error reciveing messages: %s", error)
+				context.connectionLost(connection, error)
+				break
+			}
+			recv_time := context.now()
+			if context.recorder != nil {
+				context.recorder.Write(message, recv_time)
+			}
+			context.log().Infof("RECV: %s", message)
+			response, error := decodeResponse(message)
+			if error != nil {
+				context.log().Errorf("Unable to parse response: %s", error)
+				continue
+			}
+			if context.dispatchResponse(response) {
+				continue
+			}
+			unhandled, error := response.message()
+			if error != nil {
+				context.log().Errorf("Unable to parse response: %s", error)
+				continue
+			}
+			unhandled.RecvTimestamp = recv_time.UnixNano()
+			if context.RecvChannel.Put(unhandled) != nil {
+				return
+			}
 		}
 	}
 }
 
 func runWebsocketSender(context *Context) {
-	for request := range context.SendChannel {
-		json_string, error := json.Marshal(request)
-		if error != nil {
-			l.Errorf("Unable to convert to json payload: %s", error)
+	for {
+		select {
+		case request := <-context.SendChannel:
+			var json_string []byte
+			if request.Type != kFlushMarker {
+				var error error
+				json_string, error = json.Marshal(request)
+				if error != nil {
+					context.log().Errorf("Unable to convert to json payload: %s", error)
+					continue
+				}
+			}
+			select {
+			case context.SendJsonChannel <- json_string:
+			case <-context.done:
+				return
+			}
+		case <-context.done:
+			return
 		}
-		context.SendJsonChannel <- json_string
 	}
 }
 
+// Writes requests by priority and at the rate of Options.SendRate.
 func runWebsocketJsonSender(context *Context) {
-	for request := range context.SendJsonChannel {
-		l.Infof("SEND: %s", request)
-		error := context.Connection.WriteMessage(websocket.TextMessage, request)
+	for {
+		request, ok := context.nextRequest()
+		if !ok {
+			return
+		}
+		if request == nil {
+			context.flushLock.Lock()
+			if context.flushed != nil {
+				close(context.flushed)
+				context.flushed = nil
+			}
+			context.flushLock.Unlock()
+			continue
+		}
+		connection := context.waitConnection()
+		if connection == nil {
+			return
+		}
+		context.log().Infof("SEND: %s", request)
+		error := connection.WriteFrame(request)
 		if error != nil {
-			l.Error("Unable to send message: %s", error)
+			context.log().Errorf("Unable to send message: %s", error)
+			context.connectionLost(connection, error)
 		}
 	}
 }
 
+// Runs routine and tracks it until it returns, see Close.
+func (context *Context) spawn(routine func(*Context)) {
+	context.routines.Add(1)
+	go func() {
+		defer context.routines.Done()
+		routine(context)
+	}()
+}
+
 func runGoRoutines(context *Context) {
-	go runWebsocketJsonSender(context)
-	go runWebsocketSender(context)
-	go runWebsocketReader(context)
+	context.spawn(runConnectionSupervisor)
+	context.spawn(runRequestExpiry)
+	context.spawn(runWebsocketJsonSender)
+	context.spawn(runWebsocketSender)
+	context.spawn(runWebsocketReader)
+	// Cancelling the parent context closes without flushing
+	context.spawn(func(context *Context) {
+		<-context.done
+		context.shutdown()
+	})
 }
 
+// GetApplicationContext creates a Context from ./config/config.toml
+// and the environment, see LoadOptions. It exits on any error.
+//
+// Deprecated: use LoadOptions and NewContext.
 func GetApplicationContext() *Context {
-	initLogger()
-	readConfig()
-	context := &Context{}
-	initConnection(context)
-	initChannels(context, 16)
-	runGoRoutines(context)
+	opts, error := LoadOptions("./config/config.toml")
+	if error != nil {
+		log.Fatal("Config Error: ", error)
+	}
+	context, error := NewContext(opts)
+	if error != nil {
+		log.Fatal(error)
+	}
 	return context
 }
 
-func GenerateSignature(time int64) string {
-	key := strconv.FormatInt(time, 10) + API_KEY
-	mac := hmac.New(sha256.New, []byte(API_SECRET))
-	mac.Write([]byte(key))
+// NewContext connects to opts.Endpoint and starts the goroutines of
+// the context. Invalid options and a failed first connection are
+// errors, unless opts.RetryFirstDial is set.
+func NewContext(opts Options) (*Context, error) {
+	return NewContextWithContext(gocontext.Background(), opts)
+}
+
+// NewContextWithContext is like NewContext, the connection is closed
+// once ctx is done.
+func NewContextWithContext(ctx gocontext.Context, opts Options) (*Context, error) {
+	opts, error := opts.withDefaults()
+	if error != nil {
+		return nil, error
+	}
+	context := &Context{Logger: opts.Logger, StateHandler: opts.StateHandler, options: opts}
+	if opts.Record.Dir != "" {
+		if context.recorder, error = NewRecorder(opts.Record); error != nil {
+			return nil, error
+		}
+	}
+	initChannels(context, ctx)
+	if context.recorder != nil {
+		context.OnClose(func() {
+			if error := context.recorder.Close(); error != nil {
+				context.log().Errorf("Recorder: %s", error)
+			}
+		})
+	}
+	if error := initConnection(context); error != nil {
+		context.shutdown()
+		return nil, fmt.Errorf("cexio: connecting to %s: %w", opts.Endpoint, error)
+	}
+	runGoRoutines(context)
+	return context, nil
+}
+
+// Recorder returns the recorder of the frames received, nil unless
+// Options.Record.Dir is set.
+func (context *Context) Recorder() *Recorder {
+	return context.recorder
+}
+
+// Risk returns the gate every order placed on context passes through.
+func (context *Context) Risk() *RiskGate {
+	return context.risk
+}
+
+// GenerateSignature signs timestamp and key with secret as expected by
+// the auth request.
+func GenerateSignature(key, secret string, timestamp int64) string {
+	message := strconv.FormatInt(timestamp, 10) + key
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(message))
 	return hex.EncodeToString(mac.Sum(nil))
 }
 
+// Authenticate sends the auth request and waits up to REQUEST_TIMEOUT
+// for the server to accept or reject the credentials.
 func (context *Context) Authenticate() error {
+	ctx, cancel := gocontext.WithTimeout(gocontext.Background(), REQUEST_TIMEOUT)
+	defer cancel()
+	return context.AuthenticateContext(ctx)
+}
+
+func (context *Context) AuthenticateContext(ctx gocontext.Context) error {
 	payload := Message{}
 	payload.Type = "auth"
-	payload.Auth.Key = API_KEY
+	payload.Auth.Key = context.options.Key
 	payload.Auth.Timestamp = time.Now().Unix()
-	payload.Auth.Signature = GenerateSignature(payload.Auth.Timestamp)
-	l.Infof("Signature: %s", payload.Auth.Signature)
-	context.SendChannel <- payload
-	return nil
+	payload.Auth.Signature = GenerateSignature(context.options.Key, context.options.Secret, payload.Auth.Timestamp)
+	context.log().Infof("Signature: %s", payload.Auth.Signature)
+	// Auth responses carry no oid, they are matched by type
+	future, error := context.register(ctx, typeKey(payload.Type), payload.Type, true)
+	if error != nil {
+		return error
+	}
+	if error := context.send(payload); error != nil {
+		context.expire(future, error)
+		return error
+	}
+	_, error = future.Wait(ctx)
+	if error == nil {
+		context.connectionLock.Lock()
+		context.authenticated = true
+		context.connectionLock.Unlock()
+	}
+	return error
+}
+
+// Queues request for sending, fails once the context is closed.
+func (context *Context) send(request Message) error {
+	select {
+	case <-context.done:
+		return ErrClosed
+	default:
+	}
+	select {
+	case context.SendChannel <- request:
+		return nil
+	case <-context.done:
+		return ErrClosed
+	}
+}
+
+// Like send for an already encoded request.
+func (context *Context) sendJson(request []byte) error {
+	select {
+	case <-context.done:
+		return ErrClosed
+	default:
+	}
+	select {
+	case context.SendJsonChannel <- request:
+		return nil
+	case <-context.done:
+		return ErrClosed
+	}
+}
+
+// Waits until every request queued so far has been written to the
+// socket. Only one flush may run at a time.
+func (context *Context) flush(ctx gocontext.Context) error {
+	flushed := make(chan struct{})
+	context.flushLock.Lock()
+	context.flushed = flushed
+	context.flushLock.Unlock()
+	marker := Message{}
+	marker.Type = kFlushMarker
+	if context.send(marker) != nil {
+		return nil
+	}
+	select {
+	case <-flushed:
+		return nil
+	case <-context.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// OnClose registers a function run when the context is closed, after
+// the socket is closed and before waiting for the goroutines to exit.
+func (context *Context) OnClose(hook func()) {
+	context.connectionLock.Lock()
+	context.closeHooks = append(context.closeHooks, hook)
+	context.connectionLock.Unlock()
+}
+
+// Stops everything without flushing, only the first call has effect.
+func (context *Context) shutdown() {
+	context.closeOnce.Do(func() {
+		context.closeConnection()
+		context.cancel()
+		context.RecvChannel.Dispose()
+		context.failPending(ErrClosed)
+
+		context.connectionLock.Lock()
+		hooks := append([]func(){}, context.closeHooks...)
+		context.connectionLock.Unlock()
+		for _, hook := range hooks {
+			hook()
+		}
+		context.log().Infof("Context closed")
+	})
+}
+
+// Close sends the requests queued so far, closes the socket and waits
+// for every goroutine to exit. Pending requests fail with ErrClosed.
+// Once ctx is done the remaining steps stop waiting and ctx's error is
+// returned.
+func (context *Context) Close(ctx gocontext.Context) error {
+	error := context.flush(ctx)
+	context.closeHandshake(ctx)
+	context.shutdown()
+	if wait_error := waitRoutines(ctx, &context.routines); error == nil {
+		error = wait_error
+	}
+	return error
+}
+
+func waitRoutines(ctx gocontext.Context, routines *sync.WaitGroup) error {
+	exited := make(chan struct{})
+	go func() {
+		routines.Wait()
+		close(exited)
+	}()
+	select {
+	case <-exited:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 func (context *Context) Cleanup() {
-	context.Connection.Close()
-	context.RecvChannel.Dispose()
-	close(context.SendChannel)
-	l.Infof("Context Cleanup")
-	l.Close()
+	ctx, cancel := gocontext.WithTimeout(gocontext.Background(), REQUEST_TIMEOUT)
+	defer cancel()
+	if error := context.Close(ctx); error != nil {
+		context.log().Errorf("Context cleanup: %s", error)
+	}
+	context.log().Infof("Context Cleanup")
+	if context.Logger != nil {
+		context.Logger.Close()
+	}
 }
@@ -0,0 +1,129 @@
+package cexio
+
+import (
+	"bytes"
+	gocontext "context"
+	"errors"
+	"github.com/sahmad98/cex.io/cexiotest"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Waits for the number of goroutines to drop back to expected.
+func checkGoroutines(t *testing.T, expected int) {
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > expected {
+		if time.Now().After(deadline) {
+			stacks := &bytes.Buffer{}
+			pprof.Lookup("goroutine").WriteTo(stacks, 1)
+			t.Fatalf("Leaked %d goroutines:\n%s", runtime.NumGoroutine()-expected, stacks)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCloseStopsGoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	var lock sync.Mutex
+	requests := []string{}
+	server := newHandledServer(t, func(request cexiotest.Request, conn *cexiotest.Conn) {
+		lock.Lock()
+		requests = append(requests, request.Type)
+		lock.Unlock()
+		if request.Type == "order-book-subscribe" {
+			conn.Reply(map[string]interface{}{"pair": "BTC:USD", "id": 1, "bids": [][]string{{"100", "1"}}, "asks": [][]string{{"101", "1"}}})
+		}
+	})
+	context, error := NewContext(Options{Endpoint: server.URL})
+	if error != nil {
+		t.Fatal(error)
+	}
+
+	md := NewMarketDataAdapter(context)
+	books := make(chan BookEvent, 16)
+	md.OnBook("BTC:USD", func(event BookEvent) { books <- event }, WithPolicy(PolicyBlock))
+	md.Subscribe("BTC", "USD", 5)
+	select {
+	case <-books:
+	case <-time.After(2 * time.Second):
+		t.Fatal("No orderbook received")
+	}
+	// Never answered, fails on close
+	pending, error := context.Send("get-balance", nil)
+	if error != nil {
+		t.Fatal(error)
+	}
+
+	ctx, cancel := gocontext.WithTimeout(gocontext.Background(), 5*time.Second)
+	defer cancel()
+	if error := md.Close(ctx); error != nil {
+		t.Fatal(error)
+	}
+	if _, error := pending.Wait(ctx); !errors.Is(error, ErrClosed) {
+		t.Fatalf("Expected ErrClosed for pending request, got %v", error)
+	}
+	if _, error := context.Send("get-balance", nil); !errors.Is(error, ErrClosed) {
+		t.Fatalf("Expected ErrClosed after close, got %v", error)
+	}
+	if context.State() != StateClosed {
+		t.Fatalf("Unexpected state %s", context.State())
+	}
+	server.Close()
+	checkGoroutines(t, before)
+
+	lock.Lock()
+	defer lock.Unlock()
+	if requests[len(requests)-1] != "order-book-unsubscribe" {
+		t.Fatalf("Expected unsubscribe before close, got %v", requests)
+	}
+	// Closing twice is harmless
+	if error := md.Close(ctx); error != nil {
+		t.Fatal(error)
+	}
+}
+
+func TestCancelStopsGoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+	server := newHandledServer(t, func(cexiotest.Request, *cexiotest.Conn) {})
+	ctx, cancel := gocontext.WithCancel(gocontext.Background())
+	context, error := NewContextWithContext(ctx, Options{Endpoint: server.URL})
+	if error != nil {
+		t.Fatal(error)
+	}
+	md := NewMarketDataAdapter(context)
+	md.Subscribe("BTC", "USD", 5)
+
+	cancel()
+	if error := waitRoutines(gocontext.Background(), &md.routines); error != nil {
+		t.Fatal(error)
+	}
+	server.Close()
+	checkGoroutines(t, before)
+}
+
+// The logger of contexts without one keeps errors visible on stderr
+// and drops infos.
+func TestDefaultLoggerWritesErrorsToStderr(t *testing.T) {
+	reader, writer, error := os.Pipe()
+	if error != nil {
+		t.Fatal(error)
+	}
+	stderr := os.Stderr
+	os.Stderr = writer
+	log := newStderrLogger()
+	os.Stderr = stderr
+
+	log.Infof("info line")
+	log.Errorf("error line")
+	writer.Close()
+	output, _ := ioutil.ReadAll(reader)
+	if !bytes.Contains(output, []byte("error line")) || bytes.Contains(output, []byte("info line")) {
+		t.Fatalf("Unexpected stderr output %q", output)
+	}
+}
@@ -0,0 +1,199 @@
+package cexio
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// Decimal is a fixed point number with 8 decimal places, the finest
+// precision CEX.IO uses for prices and amounts. Arithmetic and
+// comparisons are exact, unlike float32.
+type Decimal int64
+
+const (
+	kDecimalPlaces         = 8
+	kDecimalScale  Decimal = 100000000
+	// Exponents beyond the 19 digits of int64 and the decimal places
+	// cannot give a valid Decimal, rejecting them bounds the padding
+	kMaxDecimalExponent = 19 + kDecimalPlaces
+)
+
+var ErrInvalidDecimal = errors.New("cexio: invalid decimal")
+
+// ParseDecimal parses a decimal number such as "6512.25", "-0.001" or
+// "1e-5". More than 8 significant decimal places or values out of
+// range are errors instead of being rounded.
+func ParseDecimal(data string) (Decimal, error) {
+	s := data
+	negative := false
+	if len(s) > 0 && (s[0] == '-' || s[0] == '+') {
+		negative = s[0] == '-'
+		s = s[1:]
+	}
+
+	exponent := 0
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		value, error := strconv.Atoi(s[i+1:])
+		if error != nil {
+			return 0, fmt.Errorf("%w %q", ErrInvalidDecimal, data)
+		}
+		if value > kMaxDecimalExponent || value < -kMaxDecimalExponent {
+			return 0, fmt.Errorf("%w %q: exponent out of range", ErrInvalidDecimal, data)
+		}
+		exponent = value
+		s = s[:i]
+	}
+
+	integer, fraction := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		integer, fraction = s[:i], s[i+1:]
+	}
+	if integer == "" && fraction == "" {
+		return 0, fmt.Errorf("%w %q", ErrInvalidDecimal, data)
+	}
+	digits := integer + fraction
+	for i := 0; i < len(digits); i++ {
+		if digits[i] < '0' || digits[i] > '9' {
+			return 0, fmt.Errorf("%w %q", ErrInvalidDecimal, data)
+		}
+	}
+
+	// Move the decimal point so that digits[:point] is the integer part
+	point := len(integer) + exponent
+	if point < 0 {
+		digits = strings.Repeat("0", -point) + digits
+		point = 0
+	}
+	if point > len(digits) {
+		digits = digits + strings.Repeat("0", point-len(digits))
+	}
+	fraction = strings.TrimRight(digits[point:], "0")
+	if len(fraction) > kDecimalPlaces {
+		return 0, fmt.Errorf("%w %q: more than %d decimal places", ErrInvalidDecimal, data, kDecimalPlaces)
+	}
+	digits = digits[:point] + fraction + strings.Repeat("0", kDecimalPlaces-len(fraction))
+
+	var value uint64
+	for i := 0; i < len(digits); i++ {
+		hi, lo := bits.Mul64(value, 10)
+		lo, carry := bits.Add64(lo, uint64(digits[i]-'0'), 0)
+		if hi != 0 || carry != 0 || lo > math.MaxInt64 {
+			return 0, fmt.Errorf("%w %q: out of range", ErrInvalidDecimal, data)
+		}
+		value = lo
+	}
+	if negative {
+		return -Decimal(value), nil
+	}
+	return Decimal(value), nil
+}
+
+// DecimalFromFloat rounds f to the nearest Decimal.
+func DecimalFromFloat(f float64) Decimal {
+	return Decimal(math.Round(f * float64(kDecimalScale)))
+}
+
+// DecimalFromInt returns the Decimal of a whole number.
+func DecimalFromInt(i int64) Decimal {
+	return Decimal(i) * kDecimalScale
+}
+
+func (d Decimal) Float64() float64 {
+	return float64(d) / float64(kDecimalScale)
+}
+
+func (d Decimal) IsZero() bool {
+	return d == 0
+}
+
+func (d Decimal) Abs() Decimal {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// Mul returns d * other truncated to 8 decimal places, saturating
+// on overflow.
+func (d Decimal) Mul(other Decimal) Decimal {
+	negative := (d < 0) != (other < 0)
+	hi, lo := bits.Mul64(uint64(d.Abs()), uint64(other.Abs()))
+	result := Decimal(math.MaxInt64)
+	if hi < uint64(kDecimalScale) {
+		quotient, _ := bits.Div64(hi, lo, uint64(kDecimalScale))
+		if quotient <= math.MaxInt64 {
+			result = Decimal(quotient)
+		}
+	}
+	if negative {
+		return -result
+	}
+	return result
+}
+
+// Div returns d / other truncated to 8 decimal places, saturating
+// on overflow and division by zero.
+func (d Decimal) Div(other Decimal) Decimal {
+	negative := (d < 0) != (other < 0)
+	hi, lo := bits.Mul64(uint64(d.Abs()), uint64(kDecimalScale))
+	result := Decimal(math.MaxInt64)
+	if hi < uint64(other.Abs()) {
+		quotient, _ := bits.Div64(hi, lo, uint64(other.Abs()))
+		if quotient <= math.MaxInt64 {
+			result = Decimal(quotient)
+		}
+	}
+	if negative {
+		return -result
+	}
+	return result
+}
+
+// String formats d without trailing zeros, e.g. "6512.25".
+func (d Decimal) String() string {
+	sign := ""
+	value := uint64(d)
+	if d < 0 {
+		sign = "-"
+		value = uint64(-d)
+	}
+	integer := strconv.FormatUint(value/uint64(kDecimalScale), 10)
+	fraction := strconv.FormatUint(value%uint64(kDecimalScale), 10)
+	fraction = strings.Repeat("0", kDecimalPlaces-len(fraction)) + fraction
+	fraction = strings.TrimRight(fraction, "0")
+	if fraction == "" {
+		return sign + integer
+	}
+	return sign + integer + "." + fraction
+}
+
+// Decimals are encoded as JSON numbers.
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// Accepts JSON numbers as well as quoted numbers, which is how CEX.IO
+// sends most amounts. Null and empty strings decode to zero.
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		s = s[1 : len(s)-1]
+	}
+	if s == "" {
+		*d = 0
+		return nil
+	}
+	value, error := ParseDecimal(s)
+	if error != nil {
+		return error
+	}
+	*d = value
+	return nil
+}
@@ -0,0 +1,289 @@
+package cexio
+
+import (
+	"sync"
+	"time"
+)
+
+// Policy decides what happens when an event arrives while the buffer
+// of a subscriber is full.
+type Policy int
+
+const (
+	// PolicyDropOldest discards the oldest buffered event.
+	PolicyDropOldest Policy = iota
+	// PolicyBlock makes the publisher wait until the subscriber
+	// catches up, stalling every other subscriber of the adapter.
+	PolicyBlock
+	// PolicyConflate keeps only the latest event per pair, so a slow
+	// subscriber always sees the most recent state.
+	PolicyConflate
+)
+
+const (
+	kDefaultBufferSize = 64
+	kTopicBook         = "book"
+	kTopicTicker       = "ticker"
+	kTopicTrade        = "trade"
+	kTopicCandle       = "candle"
+)
+
+// Orderbook of Pair after a snapshot, an update or a ticker.
+type BookEvent struct {
+	Pair string
+	Book Orderbook
+	// Set when the book was rebuilt from a full snapshot
+	Snapshot bool
+	Time     time.Time
+}
+
+type TickerEvent struct {
+	Pair   string
+	Low    Decimal
+	High   Decimal
+	Last   Decimal
+	Volume Decimal
+	Bid    Decimal
+	Ask    Decimal
+	Time   time.Time
+}
+
+type TradeEvent struct {
+	Pair   string
+	Id     string
+	Type   OrderType
+	Price  Decimal
+	Amount Decimal
+	Time   time.Time
+}
+
+type SubscriptionOption func(*Subscription)
+
+// WithPolicy sets the policy applied when the buffer is full.
+func WithPolicy(policy Policy) SubscriptionOption {
+	return func(subscription *Subscription) { subscription.policy = policy }
+}
+
+// WithBufferSize sets the number of events buffered for a subscriber.
+func WithBufferSize(size int) SubscriptionOption {
+	return func(subscription *Subscription) {
+		if size > 0 {
+			subscription.size = size
+		}
+	}
+}
+
+type event struct {
+	pair  string
+	value interface{}
+}
+
+// Subscription delivers events to one handler from its own goroutine
+// and buffer, so a slow handler does not delay the others.
+type Subscription struct {
+	id      int
+	topic   string
+	pair    string
+	policy  Policy
+	size    int
+	handler func(interface{})
+	owner   *MarketDataAdapter
+	// Also receives the time of the adapter, see advanceClock
+	clock bool
+
+	lock    sync.Mutex
+	cond    *sync.Cond
+	events  []event
+	dropped uint64
+	closed  bool
+	done    chan struct{}
+}
+
+// Dropped returns the number of events discarded or conflated.
+func (subscription *Subscription) Dropped() uint64 {
+	subscription.lock.Lock()
+	defer subscription.lock.Unlock()
+	return subscription.dropped
+}
+
+// Done is closed once the handler has returned for the last time.
+func (subscription *Subscription) Done() <-chan struct{} {
+	return subscription.done
+}
+
+// Unsubscribe stops delivery and discards buffered events. A handler
+// call in progress completes, it is safe to unsubscribe from within
+// the handler.
+func (subscription *Subscription) Unsubscribe() {
+	subscription.lock.Lock()
+	if subscription.closed {
+		subscription.lock.Unlock()
+		return
+	}
+	subscription.closed = true
+	subscription.events = nil
+	subscription.cond.Broadcast()
+	subscription.lock.Unlock()
+
+	owner := subscription.owner
+	owner.subscribersLock.Lock()
+	delete(owner.subscribers, subscription.id)
+	owner.subscribersLock.Unlock()
+}
+
+func (subscription *Subscription) push(pair string, value interface{}) {
+	subscription.lock.Lock()
+	defer subscription.lock.Unlock()
+	if subscription.closed {
+		return
+	}
+	if subscription.policy == PolicyConflate {
+		for i := range subscription.events {
+			if subscription.events[i].pair == pair {
+				subscription.events[i].value = value
+				subscription.dropped++
+				return
+			}
+		}
+	}
+	for len(subscription.events) >= subscription.size {
+		if subscription.policy == PolicyBlock {
+			subscription.cond.Wait()
+			if subscription.closed {
+				return
+			}
+			continue
+		}
+		subscription.events = subscription.events[1:]
+		subscription.dropped++
+	}
+	subscription.events = append(subscription.events, event{pair, value})
+	subscription.cond.Broadcast()
+}
+
+func (subscription *Subscription) run() {
+	defer close(subscription.done)
+	for {
+		subscription.lock.Lock()
+		for len(subscription.events) == 0 && !subscription.closed {
+			subscription.cond.Wait()
+		}
+		if subscription.closed {
+			subscription.lock.Unlock()
+			return
+		}
+		next := subscription.events[0]
+		subscription.events = subscription.events[1:]
+		subscription.cond.Broadcast()
+		subscription.lock.Unlock()
+		subscription.handler(next.value)
+	}
+}
+
+func (md *MarketDataAdapter) subscribe(topic, pair string, handler func(interface{}), options []SubscriptionOption) *Subscription {
+	subscription := &Subscription{
+		topic:   topic,
+		pair:    pair,
+		size:    kDefaultBufferSize,
+		handler: handler,
+		owner:   md,
+		done:    make(chan struct{}),
+	}
+	subscription.cond = sync.NewCond(&subscription.lock)
+	for _, option := range options {
+		option(subscription)
+	}
+
+	md.subscribersLock.Lock()
+	md.subscriberId++
+	subscription.id = md.subscriberId
+	md.subscribers[subscription.id] = subscription
+	md.subscribersLock.Unlock()
+	md.spawn(subscription.run)
+	return subscription
+}
+
+// Hands event to every subscriber of topic and pair. Subscribers are
+// collected first, so a blocking one does not hold the registry lock.
+func (md *MarketDataAdapter) publish(topic, pair string, value interface{}) {
+	md.subscribersLock.RLock()
+	matched := make([]*Subscription, 0, len(md.subscribers))
+	for _, subscription := range md.subscribers {
+		if subscription.topic == topic && (subscription.pair == "" || subscription.pair == pair) {
+			matched = append(matched, subscription)
+		}
+	}
+	md.subscribersLock.RUnlock()
+	for _, subscription := range matched {
+		subscription.push(pair, value)
+	}
+}
+
+// Hands now to every subscriber of the time of the adapter, in order
+// with the events published before.
+func (md *MarketDataAdapter) publishClock(now time.Time) {
+	md.subscribersLock.RLock()
+	matched := []*Subscription{}
+	for _, subscription := range md.subscribers {
+		if subscription.clock {
+			matched = append(matched, subscription)
+		}
+	}
+	md.subscribersLock.RUnlock()
+	for _, subscription := range matched {
+		subscription.push("", now)
+	}
+}
+
+// OnBook calls handler with every change of the orderbook of pair, or
+// of every pair if pair is empty. Events are buffered per subscriber,
+// by default dropping the oldest when kDefaultBufferSize is exceeded.
+func (md *MarketDataAdapter) OnBook(pair string, handler func(BookEvent), options ...SubscriptionOption) *Subscription {
+	return md.subscribe(kTopicBook, pair, func(value interface{}) { handler(value.(BookEvent)) }, options)
+}
+
+// OnTicker calls handler with every ticker of pair, or of every pair if
+// pair is empty.
+func (md *MarketDataAdapter) OnTicker(pair string, handler func(TickerEvent), options ...SubscriptionOption) *Subscription {
+	return md.subscribe(kTopicTicker, pair, func(value interface{}) { handler(value.(TickerEvent)) }, options)
+}
+
+// OnTrade calls handler with every trade of pair, or of every pair if
+// pair is empty. The server does not tell the pairs of the trades that
+// follow the history of a pair room, so those are only delivered while
+// a single pair is subscribed. Subscribing more pairs calls the
+// TradeGapHandler of each pair with a zero next trade, and as rooms
+// cannot be left, their trades stay dropped after Unsubscribe until a
+// reconnect.
+func (md *MarketDataAdapter) OnTrade(pair string, handler func(TradeEvent), options ...SubscriptionOption) *Subscription {
+	return md.subscribe(kTopicTrade, pair, func(value interface{}) { handler(value.(TradeEvent)) }, options)
+}
+
+// OnCandle calls handler with every candle of pair, or of every pair
+// if pair is empty, see SubscribeCandles and AggregateCandles.
+func (md *MarketDataAdapter) OnCandle(pair string, handler func(Candle), options ...SubscriptionOption) *Subscription {
+	return md.subscribe(kTopicCandle, pair, func(value interface{}) { handler(value.(Candle)) }, options)
+}
+
+func messageTime(m *Message) time.Time {
+	if m.RecvTimestamp == 0 {
+		return time.Now()
+	}
+	return time.Unix(0, m.RecvTimestamp)
+}
+
+func (md *MarketDataAdapter) publishBook(m *Message, orderbook Orderbook, snapshot bool) {
+	md.publish(kTopicBook, orderbook.Pair, BookEvent{orderbook.Pair, orderbook, snapshot, messageTime(m)})
+}
+
+// Publishes the ticker of the pair of m merged with the fields m
+// carries, see UpdateTicker.
+func (md *MarketDataAdapter) publishTicker(m *Message) {
+	pair := m.Data.Pair.(string)
+	ticker := md.lastTickers[pair]
+	ticker.Pair = pair
+	mergeTicker(m, &ticker)
+	ticker.Time = messageTime(m)
+	md.lastTickers[pair] = ticker
+	md.publish(kTopicTicker, pair, ticker)
+}
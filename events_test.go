@@ -0,0 +1,192 @@
+package cexio
+
+import (
+	"testing"
+	"time"
+)
+
+func bookEvent(pair string, id int32) BookEvent {
+	return BookEvent{Pair: pair, Book: Orderbook{Id: id, Pair: pair}}
+}
+
+func receiveBook(t *testing.T, events chan BookEvent) BookEvent {
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(2 * time.Second):
+		t.Fatal("No book event received")
+	}
+	return BookEvent{}
+}
+
+func TestOnBookAndTicker(t *testing.T) {
+	md := newMarketDataAdapter(&Context{SendChannel: make(chan Message, 16)})
+	md.subscriptions["BTC:USD"] = 5
+	btc := make(chan BookEvent, 16)
+	all := make(chan BookEvent, 16)
+	tickers := make(chan TickerEvent, 16)
+	md.OnBook("BTC:USD", func(event BookEvent) { btc <- event })
+	md.OnBook("", func(event BookEvent) { all <- event })
+	md.OnTicker("BTC:USD", func(event TickerEvent) { tickers <- event })
+
+	md.handleUpdate(snapshotMessage("BTC:USD", 10, d("100"), d("101")))
+	md.handleUpdate(updateMessage("BTC:USD", 11, d("99")))
+	md.handleUpdate(snapshotMessage("ETH:USD", 20, d("10"), d("11")))
+	ticker := &Message{}
+	ticker.Type = "ticker"
+	ticker.Data.Pair = "BTC:USD"
+	ticker.Data.Last = d("100.5")
+	md.handleUpdate(ticker)
+
+	if event := receiveBook(t, btc); !event.Snapshot || event.Book.Id != 10 {
+		t.Fatalf("Expected snapshot event, got %+v", event)
+	}
+	if event := receiveBook(t, btc); event.Snapshot || event.Book.Id != 11 || event.Book.Bids.Len() != 2 {
+		t.Fatalf("Expected update event, got %+v", event)
+	}
+	if event := receiveBook(t, btc); event.Book.LastPrice != d("100.5") {
+		t.Fatalf("Expected ticker in book, got %+v", event)
+	}
+	pairs := []string{}
+	for i := 0; i < 4; i++ {
+		pairs = append(pairs, receiveBook(t, all).Pair)
+	}
+	if pairs[2] != "ETH:USD" {
+		t.Fatalf("Unexpected pairs %v", pairs)
+	}
+	select {
+	case event := <-tickers:
+		if event.Pair != "BTC:USD" || event.Last != d("100.5") {
+			t.Fatalf("Unexpected ticker %+v", event)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("No ticker event received")
+	}
+	select {
+	case event := <-btc:
+		t.Fatalf("Unexpected event for other pair %+v", event)
+	default:
+	}
+}
+
+// Subscribes a handler which blocks on its first event until release
+// is closed, so that the following events pile up in the buffer.
+func stalledSubscriber(t *testing.T, md *MarketDataAdapter, options ...SubscriptionOption) (*Subscription, chan BookEvent, chan struct{}) {
+	events := make(chan BookEvent, 16)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	first := true
+	subscription := md.OnBook("", func(event BookEvent) {
+		if first {
+			first = false
+			close(started)
+			<-release
+		}
+		events <- event
+	}, options...)
+	md.publish(kTopicBook, "BTC:USD", bookEvent("BTC:USD", 0))
+	select {
+	case <-started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Handler not started")
+	}
+	return subscription, events, release
+}
+
+func TestSubscriptionDropOldest(t *testing.T) {
+	md := newMarketDataAdapter(&Context{})
+	subscription, events, release := stalledSubscriber(t, md, WithBufferSize(2))
+	for id := int32(1); id <= 5; id++ {
+		md.publish(kTopicBook, "BTC:USD", bookEvent("BTC:USD", id))
+	}
+	close(release)
+	for _, expected := range []int32{0, 4, 5} {
+		if event := receiveBook(t, events); event.Book.Id != expected {
+			t.Fatalf("Expected %d got %d", expected, event.Book.Id)
+		}
+	}
+	if subscription.Dropped() != 3 {
+		t.Fatalf("Expected 3 dropped events, got %d", subscription.Dropped())
+	}
+}
+
+func TestSubscriptionConflate(t *testing.T) {
+	md := newMarketDataAdapter(&Context{})
+	subscription, events, release := stalledSubscriber(t, md, WithPolicy(PolicyConflate))
+	md.publish(kTopicBook, "BTC:USD", bookEvent("BTC:USD", 1))
+	md.publish(kTopicBook, "ETH:USD", bookEvent("ETH:USD", 1))
+	md.publish(kTopicBook, "BTC:USD", bookEvent("BTC:USD", 2))
+	md.publish(kTopicBook, "BTC:USD", bookEvent("BTC:USD", 3))
+	close(release)
+	receiveBook(t, events)
+	if event := receiveBook(t, events); event.Pair != "BTC:USD" || event.Book.Id != 3 {
+		t.Fatalf("Expected latest BTC:USD book, got %+v", event)
+	}
+	if event := receiveBook(t, events); event.Pair != "ETH:USD" {
+		t.Fatalf("Expected ETH:USD book, got %+v", event)
+	}
+	if subscription.Dropped() != 2 {
+		t.Fatalf("Expected 2 conflated events, got %d", subscription.Dropped())
+	}
+}
+
+func TestSubscriptionBlock(t *testing.T) {
+	md := newMarketDataAdapter(&Context{})
+	subscription, events, release := stalledSubscriber(t, md, WithPolicy(PolicyBlock), WithBufferSize(1))
+	md.publish(kTopicBook, "BTC:USD", bookEvent("BTC:USD", 1))
+	published := make(chan struct{})
+	go func() {
+		md.publish(kTopicBook, "BTC:USD", bookEvent("BTC:USD", 2))
+		close(published)
+	}()
+	select {
+	case <-published:
+		t.Fatal("Publisher not blocked by full buffer")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	<-published
+	for _, expected := range []int32{0, 1, 2} {
+		if event := receiveBook(t, events); event.Book.Id != expected {
+			t.Fatalf("Expected %d got %d", expected, event.Book.Id)
+		}
+	}
+	if subscription.Dropped() != 0 {
+		t.Fatal("Blocking subscriber dropped events")
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	md := newMarketDataAdapter(&Context{})
+	subscription, events, release := stalledSubscriber(t, md, WithPolicy(PolicyBlock), WithBufferSize(1))
+	md.publish(kTopicBook, "BTC:USD", bookEvent("BTC:USD", 1))
+	published := make(chan struct{})
+	go func() {
+		md.publish(kTopicBook, "BTC:USD", bookEvent("BTC:USD", 2))
+		close(published)
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	// Releases the blocked publisher and discards the buffered events
+	subscription.Unsubscribe()
+	<-published
+	close(release)
+	select {
+	case <-subscription.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("Subscription goroutine did not exit")
+	}
+	md.publish(kTopicBook, "BTC:USD", bookEvent("BTC:USD", 3))
+	if event := receiveBook(t, events); event.Book.Id != 0 {
+		t.Fatalf("Unexpected event %+v", event)
+	}
+	select {
+	case event := <-events:
+		t.Fatalf("Event delivered after unsubscribe %+v", event)
+	case <-time.After(20 * time.Millisecond):
+	}
+	if len(md.subscribers) != 0 {
+		t.Fatal("Subscriber not removed")
+	}
+	subscription.Unsubscribe()
+}
@@ -8,9 +8,16 @@ import (
 )
 
 func main() {
-	context := cexio.GetApplicationContext()
+	opts, err := cexio.LoadOptions("./config/config.toml")
+	if err != nil {
+		log.Fatal(err)
+	}
+	context, err := cexio.NewContext(opts)
+	if err != nil {
+		log.Fatal(err)
+	}
 	md := cexio.NewMarketDataAdapter(context)
-	err := context.Authenticate()
+	err = context.Authenticate()
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -23,7 +30,7 @@ func main() {
 	//md.Subscribe("ETH", "BTC", 5)
 	go func() {
 		for {
-			cexio.PrintOrderbook()
+			md.PrintOrderbook()
 			time.Sleep(1 * time.Second)
 		}
 	}()
@@ -8,6 +8,9 @@ import flatbuffers
 udp_ip = '127.0.0.1'
 udp_port = 38201
 
+# Prices and quantities are fixed point with 8 decimal places
+DECIMAL_SCALE = 100000000.0
+
 print 'Listening on UDP'
 
 sock = socket(AF_INET, SOCK_DGRAM)
@@ -17,4 +20,5 @@ while True:
     data, addr = sock.recvfrom(1024)
     buffer = bytearray(data)
     orderbook = Orderbook.Orderbook.GetRootAsOrderbook(buffer, 0)
-    print 'recived msg:', orderbook.Id(), orderbook.Pair(), orderbook.Bid(), orderbook.Ask(), orderbook.Bids().Data(0)
+    best_bid = orderbook.Bids().Data(0)
+    print 'recived msg:', orderbook.Id(), orderbook.Pair(), orderbook.Bid() / DECIMAL_SCALE, orderbook.Ask() / DECIMAL_SCALE, best_bid.Price() / DECIMAL_SCALE, best_bid.Qty() / DECIMAL_SCALE
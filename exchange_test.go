@@ -0,0 +1,143 @@
+package cexio
+
+import (
+	"errors"
+	"github.com/sahmad98/cex.io/cexiotest"
+	"testing"
+	"time"
+)
+
+func newFakeExchange(t *testing.T) *cexiotest.Server {
+	server := cexiotest.NewServer()
+	t.Cleanup(server.Close)
+	server.SetCredentials("key", "secret")
+	server.SetBook("BTC:USD", 100, []cexiotest.Level{{"100", "1"}, {"99", "2"}}, []cexiotest.Level{{"101", "1"}})
+	return server
+}
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFakeExchangeApplicationContext(t *testing.T) {
+	server := newFakeExchange(t)
+	server.Script("BTC:USD", cexiotest.Update{Bids: []cexiotest.Level{{"100", "0"}}, Asks: []cexiotest.Level{{"100.5", "3"}}})
+	server.SetBalance("BTC", cexiotest.Balance{Available: "1.5", OnOrder: "0.5"})
+	t.Setenv("CEXIO_WEBSOCKET_ENDPOINT", server.URL)
+	t.Setenv("CEXIO_AUTH_KEY", "key")
+	t.Setenv("CEXIO_AUTH_SECRET", "secret")
+	t.Setenv("CEXIO_LOG_PATH", t.TempDir())
+	t.Setenv("CEXIO_UDP_ENABLED", "false")
+
+	context := GetApplicationContext()
+	defer context.closeConnection()
+	if error := context.Authenticate(); error != nil {
+		t.Fatal(error)
+	}
+	md := NewMarketDataAdapter(context)
+	md.Subscribe("BTC", "USD", 5)
+	waitFor(t, "scripted update", func() bool {
+		orderbook, _ := md.Book("BTC:USD")
+		return orderbook.Id == 101
+	})
+	orderbook, _ := md.Book("BTC:USD")
+	bid, _ := orderbook.Bids.Best()
+	ask, _ := orderbook.Asks.Best()
+	if bid.Price != d("99") || ask != (Level{d("100.5"), d("3")}) {
+		t.Fatalf("Unexpected book %+v", orderbook)
+	}
+
+	balances, error := context.GetBalance()
+	if error != nil || balances["BTC"].Available != d("1.5") || balances["BTC"].OnOrder != d("0.5") {
+		t.Fatalf("Unexpected balances %+v %v", balances, error)
+	}
+	placed, error := context.PlaceOrder("BTC", "USD", OrderBuy, d("0.01"), d("99.5"))
+	if error != nil {
+		t.Fatal(error)
+	}
+	if orders, error := context.OpenOrders("BTC", "USD"); error != nil || len(orders) != 1 || orders[0].Id != placed.Id {
+		t.Fatalf("Unexpected open orders %+v %v", orders, error)
+	}
+	if cancelled, error := context.CancelOrder(placed.Id); error != nil || cancelled.Remains != d("0.01") {
+		t.Fatalf("Unexpected cancel %+v %v", cancelled, error)
+	}
+	if _, error := context.CancelOrder(placed.Id); error == nil {
+		t.Fatal("Cancelled an order twice")
+	}
+
+	server.Ping()
+	waitFor(t, "pong", func() bool { return server.Requests("pong") == 1 })
+}
+
+func TestFakeExchangeRejectsSignature(t *testing.T) {
+	server := newFakeExchange(t)
+	context, error := NewContext(Options{Endpoint: server.URL, Key: "key", Secret: "wrong"})
+	if error != nil {
+		t.Fatal(error)
+	}
+	defer context.closeConnection()
+	response_error := &ResponseError{}
+	if error := context.Authenticate(); !errors.As(error, &response_error) {
+		t.Fatalf("Expected a rejected auth, got %v", error)
+	}
+	if _, error := context.GetBalance(); error == nil {
+		t.Fatal("Private request answered without auth")
+	}
+}
+
+func TestFakeExchangeFaults(t *testing.T) {
+	backoff, timeout := RECONNECT_MIN_BACKOFF, REQUEST_TIMEOUT
+	RECONNECT_MIN_BACKOFF = 10 * time.Millisecond
+	defer func() { RECONNECT_MIN_BACKOFF, REQUEST_TIMEOUT = backoff, timeout }()
+
+	server := newFakeExchange(t)
+	context, error := NewContext(Options{Endpoint: server.URL, Key: "key", Secret: "secret"})
+	if error != nil {
+		t.Fatal(error)
+	}
+	defer context.closeConnection()
+	md := NewMarketDataAdapter(context)
+	resynced := make(chan Orderbook, 4)
+	md.ResyncHandler = func(orderbook Orderbook) { resynced <- orderbook }
+	md.Subscribe("BTC", "USD", 5)
+	waitFor(t, "snapshot", func() bool {
+		orderbook, _ := md.Book("BTC:USD")
+		return orderbook.Id == 100
+	})
+
+	// Malformed frames are skipped
+	server.SendRaw([]byte(`{"e":"md_update","data":{`))
+	server.CorruptNext(1)
+	server.Update("BTC:USD", cexiotest.Update{Bids: []cexiotest.Level{{"98", "1"}}})
+
+	// The corrupted update is a gap as well, the book resyncs
+	server.Gap("BTC:USD", 2)
+	server.Update("BTC:USD", cexiotest.Update{Bids: []cexiotest.Level{{"97", "1"}}})
+	select {
+	case orderbook := <-resynced:
+		if orderbook.Stale || orderbook.Id != 104 {
+			t.Fatalf("Unexpected resync %+v", orderbook)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("No resync after gap")
+	}
+
+	server.Disconnect()
+	waitFor(t, "resubscribe", func() bool { return server.Requests("order-book-subscribe") >= 3 })
+	waitFor(t, "reconnect", func() bool { return context.State() == StateConnected })
+	if error := context.Authenticate(); error != nil {
+		t.Fatal(error)
+	}
+
+	REQUEST_TIMEOUT = 50 * time.Millisecond
+	server.SetLatency(200 * time.Millisecond)
+	if _, error := context.GetBalance(); !errors.Is(error, ErrRequestTimeout) {
+		t.Fatalf("Expected a timeout, got %v", error)
+	}
+}
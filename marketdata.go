@@ -1,168 +1,73 @@
 package cexio
 
 import (
+	gocontext "context"
 	"encoding/json"
 	"fmt"
 	"github.com/buger/goterm"
 	"github.com/golang-collections/go-datastructures/queue"
-	"github.com/google/flatbuffers/go"
-	buffer "github.com/sahmad98/cex.io/types"
-	"github.com/spf13/viper"
-	"log"
 	"net"
 	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
-type Level struct {
-	Price float32
-	Qty   float32
-}
-
-const (
-	kMaxDepth         = 6
-	kMaxPrice float32 = 9999999.9999
-	kBuy              = 0
-	kSell             = 1
-	kNumSide          = 2
-)
-
-type Side int
-
-type Levels struct {
-	Data [kMaxDepth]Level
-}
-
-func (levels *Levels) Len() int { return len(levels.Data) }
-
-func (levels *Levels) Swap(i, j int) {
-	levels.Data[i], levels.Data[j] = levels.Data[j], levels.Data[i]
-}
-
-func (levels *Levels) Less(i, j int) bool {
-	return levels.Data[i].Price < levels.Data[j].Price
-}
-
-type Orderbook struct {
-	Id        int32
-	Pair      string
-	Bids      Levels
-	Asks      Levels
-	Low       float32
-	High      float32
-	LastPrice float32
-	Volume    float32
-	Bid       float32
-	Ask       float32
-}
-
-func (ob *Orderbook) getBuffer() []byte {
-	builder := flatbuffers.NewBuilder(1024)
-
-	// buffer.LevelsStart(builder)
-	buffer.LevelsStartDataVector(builder, kMaxDepth)
-	for i := kMaxDepth - 1; i >= 0; i-- {
-		bid := buffer.CreateLevel(builder, ob.Bids.Data[i].Price, ob.Bids.Data[i].Qty)
-		builder.PrependUOffsetT(bid)
-	}
-	// bids := buffer.LevelsEnd(builder)
-	bids := builder.EndVector(kMaxDepth)
-
-	// buffer.LevelsStart(builder)
-	buffer.LevelsStartDataVector(builder, kMaxDepth)
-	for i := kMaxDepth - 1; i >= 0; i-- {
-		ask := buffer.CreateLevel(builder, ob.Asks.Data[i].Price, ob.Asks.Data[i].Qty)
-		builder.PrependUOffsetT(ask)
-	}
-	// asks := buffer.LevelsEnd(builder)
-	asks := builder.EndVector(kMaxDepth)
-	pair := builder.CreateString(ob.Pair)
-
-	buffer.OrderbookStart(builder)
-	buffer.OrderbookAddId(builder, ob.Id)
-	buffer.OrderbookAddPair(builder, pair)
-	buffer.OrderbookAddBids(builder, bids)
-	buffer.OrderbookAddAsks(builder, asks)
-	buffer.OrderbookAddLow(builder, ob.Low)
-	buffer.OrderbookAddHigh(builder, ob.High)
-	buffer.OrderbookAddLastPrice(builder, ob.LastPrice)
-	buffer.OrderbookAddVolume(builder, ob.Volume)
-	buffer.OrderbookAddBid(builder, ob.Bid)
-	buffer.OrderbookAddAsk(builder, ob.Ask)
-
-	orderbook := buffer.OrderbookEnd(builder)
-	builder.Finish(orderbook)
-	buf := builder.FinishedBytes()
-	return buf
-}
-
-func (orderbook *Orderbook) update(price, qty float32, level int, side Side) {
-	if side == kBuy {
-		orderbook.Bids.Data[level].Price = price
-		orderbook.Bids.Data[level].Qty = qty
-	} else {
-		orderbook.Asks.Data[level].Price = price
-		orderbook.Asks.Data[level].Qty = qty
-	}
-}
-
-func (orderbook *Orderbook) initalize() {
-	for i := 0; i < kMaxDepth; i++ {
-		orderbook.update(-kMaxPrice, 0, i, kBuy)
-		orderbook.update(kMaxPrice, 0, i, kSell)
+// Book returns a snapshot of the orderbook of pair, e.g. "BTC:USD".
+// The snapshot is a copy and safe to use from any goroutine.
+func (md *MarketDataAdapter) Book(pair string) (Orderbook, bool) {
+	md.lock.RLock()
+	defer md.lock.RUnlock()
+	orderbook, ok := md.books[pair]
+	if !ok {
+		return Orderbook{}, false
 	}
+	return orderbook.Copy(), true
 }
 
-func (orderbook *Orderbook) allLevelUpdate(levels [][]float32, side Side) {
-	for index, data := range levels {
-		orderbook.update(data[0], data[1], index, side)
+// Books returns snapshots of all orderbooks taken at the same time,
+// sorted by pair.
+func (md *MarketDataAdapter) Books() []Orderbook {
+	md.lock.RLock()
+	books := make([]Orderbook, 0, len(md.books))
+	for _, orderbook := range md.books {
+		books = append(books, orderbook.Copy())
 	}
+	md.lock.RUnlock()
+	sort.Slice(books, func(i, j int) bool { return books[i].Pair < books[j].Pair })
+	return books
 }
 
-func (orderbook *Orderbook) removeLevel(price float32, side Side) {
-	price_levels := orderbook.Bids.Data
-	max_price := -kMaxPrice
-	if side == kSell {
-		price_levels = orderbook.Asks.Data
-		max_price = kMaxPrice
+// Returns id and staleness of the book of pair.
+func (md *MarketDataAdapter) bookState(pair string) (int32, bool, bool) {
+	md.lock.RLock()
+	defer md.lock.RUnlock()
+	orderbook, ok := md.books[pair]
+	if !ok {
+		return 0, false, false
 	}
-
-	for i, level := range price_levels {
-		if level.Price == price {
-			orderbook.update(max_price, 0, i, side)
-		}
-	}
-}
-
-func (orderbook *Orderbook) updateLevel(price, qty float32, side Side) bool {
-	price_levels := orderbook.Bids.Data
-	if side == kSell {
-		price_levels = orderbook.Asks.Data
-	}
-
-	for i, level := range price_levels {
-		if level.Price == price {
-			orderbook.update(price, qty, i, side)
-			return true
-		}
-	}
-
-	return false
+	return orderbook.Id, orderbook.Stale, true
 }
 
-var ob_map = make(map[string]*Orderbook)
-
-func PrintOrderbook() {
+func (md *MarketDataAdapter) PrintOrderbook() {
 	goterm.Clear()
 	goterm.MoveCursor(1, 1)
-	for _, orderbook := range ob_map {
+	for _, orderbook := range md.Books() {
 		ob := goterm.NewTable(0, 10, 5, ' ', 0)
 		fmt.Fprintf(ob, "%s\t%d\n", orderbook.Pair, orderbook.Id)
 		fmt.Fprintf(ob, "%s\t%s\t%s\t%s\n", "BidSz", "Bid", "Ask", "AskSz")
-		for i := 0; i < kMaxDepth-1; i++ {
-			fmt.Fprintf(ob, "%0.4f\t%0.4f\t", orderbook.Bids.Data[i].Qty, orderbook.Bids.Data[i].Price)
-			fmt.Fprintf(ob, "%0.4f\t%0.4f\n", orderbook.Asks.Data[i].Price, orderbook.Asks.Data[i].Qty)
+		for i := 0; i < kPrintDepth; i++ {
+			if i < orderbook.Bids.Len() {
+				fmt.Fprintf(ob, "%s\t%s\t", orderbook.Bids.Data[i].Qty, orderbook.Bids.Data[i].Price)
+			} else {
+				fmt.Fprintf(ob, "-\t-\t")
+			}
+			if i < orderbook.Asks.Len() {
+				fmt.Fprintf(ob, "%s\t%s\n", orderbook.Asks.Data[i].Price, orderbook.Asks.Data[i].Qty)
+			} else {
+				fmt.Fprintf(ob, "-\t-\n")
+			}
 		}
 		fmt.Fprintf(ob, "\n")
 		goterm.Println(ob)
@@ -176,107 +81,197 @@ func (md *MarketDataAdapter) CreateSnapshot(m *Message) {
 	orderbook := &Orderbook{}
 	orderbook.Pair = m.Data.Pair.(string)
 	orderbook.Id = int32(m.Data.Id)
-	orderbook.initalize()
+	md.lock.Lock()
+	orderbook.initalize(md.subscriptions[orderbook.Pair])
 	orderbook.allLevelUpdate(m.Data.Bids, kBuy)
 	orderbook.allLevelUpdate(m.Data.Asks, kSell)
-	ob_map[m.Data.Pair.(string)] = orderbook
-	l.Infof("Created Orderbook %+v", orderbook)
-	md.OrderbookChannel.Put(*orderbook)
-}
-
-func ParseFloat32(data string) float32 {
-	num, _ := strconv.ParseFloat(data, 32)
-	return float32(num)
+	// Pushed tickers may arrive before the snapshot
+	if ticker, ok := md.lastTickers[orderbook.Pair]; ok {
+		orderbook.Low, orderbook.High, orderbook.LastPrice = ticker.Low, ticker.High, ticker.Last
+		orderbook.Volume, orderbook.Bid, orderbook.Ask = ticker.Volume, ticker.Bid, ticker.Ask
+	}
+	md.books[orderbook.Pair] = orderbook
+	snapshot := orderbook.Copy()
+	md.lock.Unlock()
+	md.Context.log().Infof("Created Orderbook %+v", snapshot)
+	md.publishBook(m, snapshot, true)
 }
 
+// UpdateTicker sets the ticker fields m carries on orderbook. A polled
+// ticker carries every field, a tick pushed by the tickers room only
+// the last price and volume, an ohlcv24 push no bid and ask.
 func (md *MarketDataAdapter) UpdateTicker(m *Message, orderbook *Orderbook) {
 	if orderbook != nil {
-		orderbook.Low = ParseFloat32(m.Data.Low)
-		orderbook.High = ParseFloat32(m.Data.High)
-		orderbook.LastPrice = ParseFloat32(m.Data.Last)
-		orderbook.Volume = ParseFloat32(m.Data.Volume)
-		orderbook.Bid = m.Data.Bid
-		orderbook.Ask = m.Data.Ask
+		md.lock.Lock()
+		defer md.lock.Unlock()
+		ticker := TickerEvent{
+			Low:    orderbook.Low,
+			High:   orderbook.High,
+			Last:   orderbook.LastPrice,
+			Volume: orderbook.Volume,
+			Bid:    orderbook.Bid,
+			Ask:    orderbook.Ask,
+		}
+		mergeTicker(m, &ticker)
+		orderbook.Low = ticker.Low
+		orderbook.High = ticker.High
+		orderbook.LastPrice = ticker.Last
+		orderbook.Volume = ticker.Volume
+		orderbook.Bid = ticker.Bid
+		orderbook.Ask = ticker.Ask
+	}
+}
+
+// Sets the fields carried by a ticker, tick or ohlcv24 message.
+func mergeTicker(m *Message, ticker *TickerEvent) {
+	switch m.Type {
+	case kTickPush:
+		ticker.Last = m.Data.Last
+		ticker.Volume = m.Data.Volume
+	case kOhlcv24Push:
+		ticker.Low = m.Data.Low
+		ticker.High = m.Data.High
+		ticker.Last = m.Data.Last
+		ticker.Volume = m.Data.Volume
+	default:
+		ticker.Low = m.Data.Low
+		ticker.High = m.Data.High
+		ticker.Last = m.Data.Last
+		ticker.Volume = m.Data.Volume
+		ticker.Bid = m.Data.Bid
+		ticker.Ask = m.Data.Ask
 	}
 }
 
 func (md *MarketDataAdapter) UpdateSnapshot(m *Message) {
-	orderbook := ob_map[m.Data.Pair.(string)]
-	updated_bid := false
-	updated_ask := false
+	md.lock.Lock()
+	orderbook := md.books[m.Data.Pair.(string)]
 	orderbook.Id++
-	// TODO Simplify this
 	for _, update := range m.Data.Bids {
-		if update[1] == 0 {
-			orderbook.removeLevel(update[0], kBuy)
-		} else {
-			updated_bid = orderbook.updateLevel(update[0], update[1], kBuy)
-			if !updated_bid {
-				orderbook.update(update[0], update[1], kMaxDepth-1, kBuy)
-			}
-		}
+		orderbook.updateLevel(update[0], update[1], kBuy)
 	}
-
 	for _, update := range m.Data.Asks {
-		if update[1] == 0 {
-			orderbook.removeLevel(update[0], kSell)
-		} else {
-			updated_ask = orderbook.updateLevel(update[0], update[1], kSell)
-			if !updated_ask {
-				orderbook.update(update[0], update[1], kMaxDepth-1, kSell)
-			}
-
-		}
+		orderbook.updateLevel(update[0], update[1], kSell)
 	}
+	snapshot := orderbook.Copy()
+	md.lock.Unlock()
 
-	sort.Sort(sort.Reverse(&orderbook.Bids))
-	sort.Sort(&orderbook.Asks)
-	l.Infof("MD_UPDTE,PERF,%d,%d", time.Now().UnixNano()-m.Data.Timestamp*time.Millisecond.Nanoseconds(), time.Now().UnixNano()-m.RecvTimestamp)
-	md.OrderbookChannel.Put(*orderbook)
+	md.Context.log().Infof("MD_UPDTE,PERF,%d,%d", md.Context.now().UnixNano()-m.Data.Timestamp*time.Millisecond.Nanoseconds(), md.Context.now().UnixNano()-m.RecvTimestamp)
+	md.publishBook(m, snapshot, false)
 }
 
 type HandlerFunc func(message *Message)
 
+// Called with the rebuilt orderbook once a resync has completed
+// and all buffered updates newer than the snapshot are applied.
+type ResyncFunc func(orderbook Orderbook)
+
+// Internal message type put on the update channel after a reconnect.
+const kReconnected = "reconnected"
+
+// Pushes of the tickers and pair-X-Y rooms, see Subscribe.
+const (
+	kTickPush    = "tick"
+	kOhlcv24Push = "ohlcv24"
+)
+
+// Maximum number of md_update messages buffered per pair while
+// waiting for a fresh snapshot.
+const kMaxPendingUpdates = 1024
+
 type MarketDataAdapter struct {
-	PingChannel      *queue.RingBuffer
-	ResponseChannel  *queue.RingBuffer
-	UpdateChannel    *queue.RingBuffer
-	OrderbookChannel *queue.RingBuffer
-	Context          *Context
-	UpdateHandler    HandlerFunc
-	ResponseHandler  HandlerFunc
+	PingChannel     *queue.RingBuffer
+	ResponseChannel *queue.RingBuffer
+	UpdateChannel   *queue.RingBuffer
+	Context         *Context
+	UpdateHandler   HandlerFunc
+	ResponseHandler HandlerFunc
+	ResyncHandler   ResyncFunc
+	TradeGapHandler TradeGapFunc
+
+	// Books and subscriptions keyed by "SYM1:SYM2", guarded by lock.
+	// Only the update goroutine modifies books.
+	books         map[string]*Orderbook
+	subscriptions map[string]int
+	lock          sync.RWMutex
+	// Updates received for a pair while its orderbook is stale,
+	// only used by the update goroutine.
+	pending map[string][]*Message
+
+	// Event subscribers by id, see OnBook, OnTicker and OnTrade.
+	subscribers     map[int]*Subscription
+	subscriberId    int
+	subscribersLock sync.RWMutex
+
+	// Ticker polls by pair, guarded by lock
+	tickers map[string]chan struct{}
+	// Poll interval by pair, guarded by lock, see SetTickerPolling
+	pollIntervals map[string]time.Duration
+	// Ticker of each pair merged from polls and pushes, only used by
+	// the update goroutine.
+	lastTickers map[string]TickerEvent
+	// Candle interval by pair and time of the last candle received per
+	// pair and interval, guarded by lock, see SubscribeCandles
+	candleIntervals map[string]time.Duration
+	serverCandles   map[candleKey]time.Time
+	// Trades seen by pair, only used by the update goroutine.
+	tradeHistories map[string]*tradeHistory
+	// Pairs of the pair-X-Y rooms joined on the connection, and of the
+	// joins whose history push is still to come, in join order,
+	// guarded by lock
+	tradeRooms   []string
+	historyRooms []string
+	// Last second handed to the candle aggregators, only used by the
+	// update goroutine
+	clock     time.Time
+	publisher net.PacketConn
+	multicast *multicastPublisher
+	routines  sync.WaitGroup
 }
 
-func ResponseHandler(m *Message) {
+func (md *MarketDataAdapter) logResponse(m *Message) {
 	if m.Type == "auth" {
 		if m.Data.Ok != "ok" {
-			l.Errorf("Auth Error %s", m.Data.Error)
+			md.Context.log().Errorf("Auth Error %s", m.Data.Error)
 		} else {
-			l.Infof("Login Successful")
+			md.Context.log().Infof("Login Successful")
 		}
 	}
 }
 
 func (md *MarketDataAdapter) pingPongRoutine() {
 	for {
-		ping, _ := md.PingChannel.Get()
+		ping, error := md.PingChannel.Get()
+		if error != nil {
+			return
+		}
 		ping.(*Message).Type = "pong"
-		md.Context.SendChannel <- *ping.(*Message)
-		l.Infof("PONG")
+		if md.Context.send(*ping.(*Message)) != nil {
+			return
+		}
+		md.Context.log().Infof("PONG")
 	}
 }
 
 func getTickerSymbol(message *Message) string {
-	return message.Data.Pair.([]interface{})[0].(string) + ":" + message.Data.Pair.([]interface{})[1].(string)
+	symbols := message.Data.Pair.([]interface{})
+	return NewPair(symbols[0].(string), symbols[1].(string)).String()
 }
 
 func (md *MarketDataAdapter) responseRouterRoutine() {
 	for {
-		message, _ := md.Context.RecvChannel.Get()
-		if message.(*Message).Type == "ping" {
-			l.Infof("PING")
+		message, error := md.Context.RecvChannel.Get()
+		if error != nil {
+			return
+		}
+		if marker, ok := message.(syncMarker); ok {
+			md.UpdateChannel.Put(marker)
+		} else if message.(*Message).Type == "ping" {
+			md.Context.log().Infof("PING")
 			md.PingChannel.Put(message)
-		} else if message.(*Message).Type == "md_update" {
+		} else if message.(*Message).Type == "md_update" || message.(*Message).Type == "order-book-subscribe" {
+			// Snapshots travel on the update channel so that they are
+			// applied in order with the updates that follow them.
 			md.UpdateChannel.Put(message)
 		} else if message.(*Message).Type == "ticker" {
 			message.(*Message).Data.Pair = getTickerSymbol(message.(*Message))
@@ -289,71 +284,246 @@ func (md *MarketDataAdapter) responseRouterRoutine() {
 
 func (md *MarketDataAdapter) responseHandlerRoutine() {
 	for {
-		response, _ := md.ResponseChannel.Get()
-		if response.(*Message).Type == "order-book-subscribe" {
-			l.Infof("MD: %+v", response)
-			md.CreateSnapshot(response.(*Message))
-			l.Infof("%+v", ob_map)
+		response, error := md.ResponseChannel.Get()
+		if error != nil {
+			return
 		}
 		md.ResponseHandler(response.(*Message))
 	}
 }
 
+func (md *MarketDataAdapter) handleSnapshot(m *Message) {
+	if m.Data.Error != "" {
+		md.Context.log().Errorf("Orderbook subscribe error: %s", m.Data.Error)
+		return
+	}
+	pair := m.Data.Pair.(string)
+	_, was_stale, _ := md.bookState(pair)
+	md.Context.log().Infof("MD: %+v", m)
+	md.CreateSnapshot(m)
+	md.replayPending(pair)
+	if orderbook, _ := md.Book(pair); was_stale && !orderbook.Stale {
+		md.Context.log().Infof("Resync complete %s at %d", pair, orderbook.Id)
+		md.ResyncHandler(orderbook)
+	}
+}
+
+// Applies buffered updates newer than the current snapshot. A further
+// gap inside the buffer starts another resync with the remaining updates.
+func (md *MarketDataAdapter) replayPending(pair string) {
+	pending := md.pending[pair]
+	delete(md.pending, pair)
+	for i, update := range pending {
+		id := int32(update.Data.Id)
+		current, _, _ := md.bookState(pair)
+		if id <= current {
+			continue
+		}
+		if id != current+1 {
+			md.pending[pair] = pending[i:]
+			md.Resync(pair)
+			return
+		}
+		md.UpdateSnapshot(update)
+	}
+}
+
+func (md *MarketDataAdapter) bufferUpdate(pair string, m *Message) {
+	pending := md.pending[pair]
+	if len(pending) >= kMaxPendingUpdates {
+		md.Context.log().Warningf("Pending updates overflow for %s, dropping oldest", pair)
+		pending = pending[1:]
+	}
+	md.pending[pair] = append(pending, m)
+}
+
+func (md *MarketDataAdapter) handleUpdate(m *Message) {
+	if m.Type == "order-book-subscribe" {
+		md.handleSnapshot(m)
+		md.ResponseHandler(m)
+		return
+	}
+	if m.Type == kReconnected {
+		md.resubscribe()
+		return
+	}
+
+	pair := m.Data.Pair.(string)
+	id, stale, found := md.bookState(pair)
+	if m.Type == "md_update" {
+		if !found || stale {
+			// Snapshot still in flight, keep the update for replay
+			md.bufferUpdate(pair, m)
+		} else if id+1 == int32(m.Data.Id) {
+			md.UpdateSnapshot(m)
+		} else {
+			md.Context.log().Warningf("Missed update for %s, expected %d got %d", pair, id+1, m.Data.Id)
+			md.bufferUpdate(pair, m)
+			md.Resync(pair)
+		}
+	} else if m.Type == "ticker" || m.Type == kTickPush || m.Type == kOhlcv24Push {
+		md.publishTicker(m)
+		md.lock.RLock()
+		orderbook := md.books[pair]
+		md.lock.RUnlock()
+		if orderbook == nil {
+			return
+		}
+		md.UpdateTicker(m, orderbook)
+		snapshot, _ := md.Book(pair)
+		md.publishBook(m, snapshot, false)
+	}
+}
+
 func (md *MarketDataAdapter) updateHandlerRoutine() {
 	for {
-		response, _ := md.UpdateChannel.Get()
-		orderbook := ob_map[response.(*Message).Data.Pair.(string)]
-		if response.(*Message).Type == "md_update" && orderbook.Id+1 == int32(response.(*Message).Data.Id) {
-			md.UpdateSnapshot(response.(*Message))
-			l.Infof("Current Orderbook: %+v", orderbook)
-		} else if response.(*Message).Type == "ticker" {
-			md.UpdateTicker(response.(*Message), orderbook)
-			md.OrderbookChannel.Put(*orderbook)
-		} else {
-			log.Fatal("Missed update snapshot/Resync")
+		response, error := md.UpdateChannel.Get()
+		if error != nil {
+			return
+		}
+		switch update := response.(type) {
+		case *Message:
+			md.handleUpdate(update)
+			if update.RecvTimestamp != 0 {
+				md.advanceClock(time.Unix(0, update.RecvTimestamp))
+			}
+		case []Candle:
+			md.handleCandles(update)
+		case *tradeBatch:
+			md.handleTrades(update)
+			md.advanceClock(update.received)
+		case tradeLoss:
+			md.handleTradeLoss(update)
+		case clockTick:
+			md.advanceClock(time.Time(update))
+		case syncMarker:
+			close(update)
+		}
+	}
+}
+
+// Returns the sink sending to PublishAddress, nil if disabled or it
+// could not be opened.
+func (md *MarketDataAdapter) openUnicastSink() func(kind PacketKind, pair string, buffer []byte) {
+	address := md.Context.options.PublishAddress
+	if address == "" {
+		return nil
+	}
+	dest, error := net.ResolveUDPAddr("udp", address)
+	if error != nil {
+		md.Context.log().Errorf("Error resolving publish address: %s", error)
+		return nil
+	}
+	conn, error := net.ListenPacket("udp", ":0")
+	if error != nil {
+		md.Context.log().Errorf("Error opening publish connection: %s", error)
+		return nil
+	}
+	md.publisher = conn
+	return func(kind PacketKind, pair string, buffer []byte) {
+		if _, err := conn.WriteTo(buffer, dest); err != nil {
+			md.Context.log().Infof("Error Relaying, %s", err)
 		}
 	}
 }
 
+// Relays books, and candles and trades if enabled, to every sink that
+// could be opened. A failing sink does not stop the others.
 func (md *MarketDataAdapter) runOrderbookPublisher() {
-	is_publish_enabled := viper.GetBool("udp.enabled")
-	if is_publish_enabled {
-		ip := viper.GetString("udp.publish_ip")
-		port := viper.GetInt("udp.publish_port")
-		conn, error := net.ListenPacket("udp", ":0")
+	sinks := []func(kind PacketKind, pair string, buffer []byte){}
+	if sink := md.openUnicastSink(); sink != nil {
+		sinks = append(sinks, sink)
+	}
+	if md.Context.options.Multicast.Group != "" {
+		publisher, error := newMulticastPublisher(md.Context.options.Multicast, md.Context.log())
 		if error != nil {
-			log.Fatal("Error opening publish connection ", error)
+			md.Context.log().Errorf("Error opening multicast publisher: %s", error)
+		} else {
+			md.multicast = publisher
+			sinks = append(sinks, publisher.publish)
 		}
-		dest, error := net.ResolveUDPAddr("udp", ip+":"+strconv.Itoa(port))
-		for {
-			orderbook, _ := md.OrderbookChannel.Get()
-			_, err := conn.WriteTo(orderbook.(*Orderbook).getBuffer(), dest)
-			l.Infof("Relay Orderbook: %+v", orderbook)
-			if err != nil {
-				l.Infof("Error Relaying, %s", err)
-			}
+	}
+	if len(sinks) == 0 {
+		return
+	}
+	relay := func(kind PacketKind, pair string, buffer []byte) {
+		for _, sink := range sinks {
+			sink(kind, pair, buffer)
 		}
 	}
+	md.OnBook("", func(event BookEvent) {
+		relay(PacketBook, event.Book.Pair, event.Book.getBuffer())
+		md.Context.log().Infof("Relay Orderbook: %+v", event.Book)
+	}, WithPolicy(PolicyBlock))
+	if md.Context.options.PublishCandles {
+		md.OnCandle("", func(candle Candle) {
+			relay(PacketCandle, candle.Pair, candle.getBuffer())
+		}, WithPolicy(PolicyBlock))
+	}
+	if md.Context.options.PublishTrades {
+		md.OnTrade("", func(trade TradeEvent) {
+			relay(PacketTrade, trade.Pair, trade.getBuffer())
+		}, WithPolicy(PolicyBlock))
+	}
 }
 
-func NewMarketDataAdapter(context *Context) *MarketDataAdapter {
+func newMarketDataAdapter(context *Context) *MarketDataAdapter {
 	md := MarketDataAdapter{}
 	md.Context = context
 	md.PingChannel = queue.NewRingBuffer(16)
 	md.ResponseChannel = queue.NewRingBuffer(16)
 	md.UpdateChannel = queue.NewRingBuffer(16)
-	md.OrderbookChannel = queue.NewRingBuffer(64)
 	md.UpdateHandler = func(m *Message) {}
-	md.ResponseHandler = ResponseHandler
+	md.ResponseHandler = md.logResponse
+	md.ResyncHandler = func(orderbook Orderbook) {}
+	md.TradeGapHandler = func(pair string, last, next TradeEvent) {}
+	md.books = make(map[string]*Orderbook)
+	md.subscriptions = make(map[string]int)
+	md.pending = make(map[string][]*Message)
+	md.subscribers = make(map[int]*Subscription)
+	md.tickers = make(map[string]chan struct{})
+	md.pollIntervals = make(map[string]time.Duration)
+	for pair, interval := range context.options.TickerPolling {
+		if symbols, error := ParsePair(pair); error == nil {
+			md.pollIntervals[symbols.String()] = interval
+		}
+	}
+	md.lastTickers = make(map[string]TickerEvent)
+	context.onPush(kTickPush, md.handleTickPush)
+	context.onPush(kOhlcv24Push, md.handleOhlcv24Push)
+	md.candleIntervals = make(map[string]time.Duration)
+	md.serverCandles = make(map[candleKey]time.Time)
+	context.onPush(kInitOhlcvPush, md.handleOhlcvPush)
+	context.onPush(kOhlcvPush, md.handleOhlcvPush)
+	context.onPush(kOhlcvNewPush, md.handleOhlcvPush)
+	context.onPush(kOhlcv1mPush, md.handleOhlcv1mPush)
+	md.tradeHistories = make(map[string]*tradeHistory)
+	context.onPush(kHistoryPush, md.handleHistoryPush)
+	context.onPush(kHistoryUpdatePush, md.handleHistoryUpdatePush)
+	// Books are rebuilt in the update goroutine after a reconnect
+	context.OnReconnect(func() {
+		reconnected := &Message{}
+		reconnected.Type = kReconnected
+		md.UpdateChannel.Put(reconnected)
+	})
+	context.OnClose(md.shutdown)
+	if context.risk != nil {
+		context.risk.defaultBooks(&md)
+	}
+	return &md
+}
+
+func NewMarketDataAdapter(context *Context) *MarketDataAdapter {
+	md := newMarketDataAdapter(context)
 
 	// Start Response handler goroutine which will
 	// send responses on different channels
-	go md.pingPongRoutine()
-	go md.responseRouterRoutine()
-	go md.responseHandlerRoutine()
-	go md.updateHandlerRoutine()
-	go md.runOrderbookPublisher()
-	return &md
+	md.spawn(md.pingPongRoutine)
+	md.spawn(md.responseRouterRoutine)
+	md.spawn(md.responseHandlerRoutine)
+	md.spawn(md.updateHandlerRoutine)
+	md.runOrderbookPublisher()
+	return md
 }
 
 type TickerRequest struct {
@@ -361,38 +531,399 @@ type TickerRequest struct {
 	Pair interface{} `json:"data"`
 }
 
-func (adapter *MarketDataAdapter) Subscribe(sym1, sym2 string, depth int) {
+// Joins rooms whose data the server then pushes without further
+// requests.
+type RoomRequest struct {
+	Type  string   `json:"e"`
+	Rooms []string `json:"rooms"`
+}
+
+type tickPush struct {
+	Symbol1 string  `json:"symbol1"`
+	Symbol2 string  `json:"symbol2"`
+	Price   Decimal `json:"price"`
+	Open24  Decimal `json:"open24"`
+	Volume  Decimal `json:"volume"`
+}
+
+// Open, high, low, close and volume of the last 24 hours, the volume
+// is given in the smallest unit of the currency.
+func roomsRequest(sym1, sym2 string) RoomRequest {
+	return RoomRequest{Type: "subscribe", Rooms: []string{"tickers", "pair-" + sym1 + "-" + sym2}}
+}
+
+func subscribeRequest(sym1, sym2 string, depth int) Message {
 	request := Message{}
 	request.Type = "order-book-subscribe"
 	request.Data.Pair = []string{sym1, sym2}
 	request.Data.Subscribe = true
 	request.Data.Depth = depth
-	adapter.Context.SendChannel <- request
-	go func() {
-		for {
-			ticker := TickerRequest{}
-			ticker.Type = "ticker"
-			ticker.Pair = []string{sym1, sym2}
-			ticker_string, _ := json.Marshal(ticker)
-			adapter.Context.SendJsonChannel <- ticker_string
-			time.Sleep(2 * time.Second)
-			l.Infof("TICKER")
-		}
-	}()
-	// log.Printf("Subscribed:", sym1, sym2, depth)
+	return request
 }
 
-func (adapter *MarketDataAdapter) Unsubscribe(sym1, sym2 string) {
+func unsubscribeRequest(sym1, sym2 string) Message {
 	request := Message{}
 	request.Type = "order-book-unsubscribe"
 	request.Data.Pair = []string{sym1, sym2}
-	adapter.Context.SendChannel <- request
+	return request
 }
 
-func (adapter *MarketDataAdapter) Cleanup() {
-	adapter.Context.Cleanup()
+// Subscribe requests the orderbook of sym1:sym2 keeping depth levels
+// per side, a depth of 0 keeps the full book. The returned future
+// completes with the subscribe response, the snapshot itself is still
+// delivered through the adapter. Tickers are pushed by the tickers and
+// pair-X-Y rooms, and also polled if SetTickerPolling was called.
+// Trades after the history of the pair room are only delivered while a
+// single pair is subscribed, see OnTrade.
+// With Options.Pairs set, unknown pairs fail with ErrUnknownPair.
+func (adapter *MarketDataAdapter) Subscribe(sym1, sym2 string, depth int) *Future {
+	if error := adapter.Context.checkPair(sym1, sym2); error != nil {
+		return adapter.Context.failedFuture("order-book-subscribe", error)
+	}
+	symbols := NewPair(sym1, sym2)
+	sym1, sym2 = symbols.Symbol1, symbols.Symbol2
+	pair := symbols.String()
+	adapter.lock.Lock()
+	adapter.subscriptions[pair] = depth
+	interval := adapter.pollIntervals[pair]
+	adapter.lock.Unlock()
+	request := subscribeRequest(sym1, sym2, depth)
+	future := adapter.sendRequest(request)
+	if lost := adapter.joinRooms(sym1, sym2); lost != nil {
+		adapter.UpdateChannel.Put(lost)
+	}
+	if interval > 0 {
+		adapter.pollTicker(sym1, sym2, interval)
+	}
+	return future
+}
+
+// Unsubscribe stops the orderbook and the ticker poll of sym1:sym2.
+// Rooms cannot be left, pushed tickers of the pair are ignored instead
+// and trades of other pairs stay dropped until a reconnect.
+func (adapter *MarketDataAdapter) Unsubscribe(sym1, sym2 string) *Future {
+	sym1, sym2 = strings.ToUpper(sym1), strings.ToUpper(sym2)
+	adapter.lock.Lock()
+	delete(adapter.subscriptions, sym1+":"+sym2)
+	adapter.stopTicker(sym1 + ":" + sym2)
+	adapter.lock.Unlock()
+	return adapter.sendRequest(unsubscribeRequest(sym1, sym2))
+}
+
+// SetTickerPolling polls the ticker of sym1:sym2 every interval as a
+// fallback for pushed tickers while the pair is subscribed. A zero
+// interval stops polling. With Options.Pairs set, unknown pairs fail
+// with ErrUnknownPair.
+func (adapter *MarketDataAdapter) SetTickerPolling(sym1, sym2 string, interval time.Duration) error {
+	if error := adapter.Context.checkPair(sym1, sym2); error != nil {
+		return error
+	}
+	symbols := NewPair(sym1, sym2)
+	sym1, sym2 = symbols.Symbol1, symbols.Symbol2
+	pair := symbols.String()
+	adapter.lock.Lock()
+	if interval > 0 {
+		adapter.pollIntervals[pair] = interval
+	} else {
+		delete(adapter.pollIntervals, pair)
+	}
+	_, subscribed := adapter.subscriptions[pair]
+	if interval <= 0 || !subscribed {
+		adapter.stopTicker(pair)
+	}
+	adapter.lock.Unlock()
+	if interval > 0 && subscribed {
+		adapter.pollTicker(sym1, sym2, interval)
+	}
+	return nil
+}
+
+// The server answers each join of a pair-X-Y room with a history push
+// of the pair, in order. Its history-update pushes carry no pair, so
+// those are only attributed while a single pair room is joined.
+// Returns the pairs whose updates are dropped from this join on.
+func (adapter *MarketDataAdapter) joinRooms(sym1, sym2 string) tradeLoss {
+	pair := sym1 + ":" + sym2
+	adapter.lock.Lock()
+	joined := false
+	for _, room := range adapter.tradeRooms {
+		joined = joined || room == pair
+	}
+	var lost tradeLoss
+	if !joined {
+		adapter.tradeRooms = append(adapter.tradeRooms, pair)
+		if len(adapter.tradeRooms) == 2 {
+			lost = append(lost, adapter.tradeRooms...)
+		} else if len(adapter.tradeRooms) > 2 {
+			lost = tradeLoss{pair}
+		}
+	}
+	adapter.historyRooms = append(adapter.historyRooms, pair)
+	adapter.lock.Unlock()
+	rooms_string, _ := json.Marshal(roomsRequest(sym1, sym2))
+	adapter.Context.sendJson(rooms_string)
+	return lost
+}
+
+// Requests the ticker of sym1:sym2 every interval until stopped,
+// replacing a previous poll of the pair.
+func (adapter *MarketDataAdapter) pollTicker(sym1, sym2 string, interval time.Duration) {
+	pair := sym1 + ":" + sym2
+	stop := make(chan struct{})
+	adapter.lock.Lock()
+	adapter.stopTicker(pair)
+	adapter.tickers[pair] = stop
+	adapter.lock.Unlock()
+
+	ticker := TickerRequest{}
+	ticker.Type = "ticker"
+	ticker.Pair = []string{sym1, sym2}
+	ticker_string, _ := json.Marshal(ticker)
+	adapter.spawn(func() {
+		for {
+			if adapter.Context.sendJson(ticker_string) != nil {
+				return
+			}
+			adapter.Context.log().Infof("TICKER")
+			select {
+			case <-time.After(interval):
+			case <-stop:
+				return
+			}
+		}
+	})
+}
+
+// Must be called with lock held.
+func (adapter *MarketDataAdapter) stopTicker(pair string) {
+	if stop, ok := adapter.tickers[pair]; ok {
+		close(stop)
+		delete(adapter.tickers, pair)
+	}
+}
+
+func (adapter *MarketDataAdapter) spawn(routine func()) {
+	adapter.routines.Add(1)
+	go func() {
+		defer adapter.routines.Done()
+		routine()
+	}()
+}
+
+// Sends request with a fresh oid, the response is matched to the
+// returned future and still routed to the adapter.
+func (adapter *MarketDataAdapter) sendRequest(request Message) *Future {
+	request.Oid = adapter.Context.nextOid(request.Type)
+	future, error := adapter.Context.register(gocontext.Background(), request.Oid, request.Type, true)
+	if error != nil {
+		return adapter.Context.failedFuture(request.Type, error)
+	}
+	if error := adapter.Context.send(request); error != nil {
+		adapter.Context.expire(future, error)
+	}
+	return future
+}
+
+// Closed by the update goroutine once the frames received before it
+// are applied, see Sync.
+type syncMarker chan struct{}
+
+// Sync waits until the frames received so far are applied to the books
+// and their events handed to the subscribers, e.g. once a Replay is
+// done. Handlers run on their own goroutines and may still be handling
+// those events.
+func (md *MarketDataAdapter) Sync(ctx gocontext.Context) error {
+	marker := make(syncMarker)
+	if md.Context.RecvChannel.Put(marker) != nil {
+		return ErrClosed
+	}
+	select {
+	case <-marker:
+		return nil
+	case <-md.Context.done:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Marks every book stale and subscribes again on a fresh connection.
+// The new snapshots complete the resync like after a sequence gap.
+func (adapter *MarketDataAdapter) resubscribe() {
+	adapter.lock.Lock()
+	subscriptions := make(map[string]int, len(adapter.subscriptions))
+	for pair, depth := range adapter.subscriptions {
+		subscriptions[pair] = depth
+		if orderbook := adapter.books[pair]; orderbook != nil {
+			orderbook.Stale = true
+		}
+	}
+	candles := make(map[string]time.Duration, len(adapter.candleIntervals))
+	for pair, interval := range adapter.candleIntervals {
+		candles[pair] = interval
+	}
+	// Rooms are left with the old connection
+	adapter.tradeRooms, adapter.historyRooms = nil, nil
+	adapter.lock.Unlock()
+
+	for pair, depth := range subscriptions {
+		symbols := strings.Split(pair, ":")
+		delete(adapter.pending, pair)
+		adapter.Context.log().Infof("Resubscribe %s", pair)
+		adapter.Context.send(subscribeRequest(symbols[0], symbols[1], depth))
+		if lost := adapter.joinRooms(symbols[0], symbols[1]); lost != nil {
+			adapter.handleTradeLoss(lost)
+		}
+	}
+	for pair, interval := range candles {
+		symbols := strings.Split(pair, ":")
+		adapter.initCandles(symbols[0], symbols[1], candleIntervals[interval])
+	}
+}
+
+// Resync marks the orderbook of pair stale and requests a fresh snapshot.
+// Updates received until the snapshot arrives are buffered and replayed
+// on top of it, other pairs keep streaming in the meantime.
+func (adapter *MarketDataAdapter) Resync(pair string) {
+	symbols := strings.Split(pair, ":")
+	adapter.lock.Lock()
+	depth, ok := adapter.subscriptions[pair]
+	if len(symbols) != 2 || !ok {
+		adapter.lock.Unlock()
+		adapter.Context.log().Warningf("Resync requested for unsubscribed pair %s", pair)
+		return
+	}
+	orderbook := adapter.books[pair]
+	if orderbook != nil {
+		if orderbook.Stale {
+			adapter.lock.Unlock()
+			return
+		}
+		orderbook.Stale = true
+	}
+	adapter.lock.Unlock()
+	adapter.Context.log().Infof("Resync %s", pair)
+	adapter.Context.send(unsubscribeRequest(symbols[0], symbols[1]))
+	adapter.Context.send(subscribeRequest(symbols[0], symbols[1], depth))
+}
+
+// Stops the goroutines of the adapter once its Context is closed.
+func (adapter *MarketDataAdapter) shutdown() {
+	adapter.lock.Lock()
+	for pair := range adapter.tickers {
+		adapter.stopTicker(pair)
+	}
+	adapter.lock.Unlock()
+
+	adapter.subscribersLock.RLock()
+	subscriptions := make([]*Subscription, 0, len(adapter.subscribers))
+	for _, subscription := range adapter.subscribers {
+		subscriptions = append(subscriptions, subscription)
+	}
+	adapter.subscribersLock.RUnlock()
+	for _, subscription := range subscriptions {
+		subscription.Unsubscribe()
+	}
+
+	if adapter.publisher != nil {
+		adapter.publisher.Close()
+	}
+	if adapter.multicast != nil {
+		adapter.multicast.close()
+	}
 	adapter.PingChannel.Dispose()
 	adapter.ResponseChannel.Dispose()
 	adapter.UpdateChannel.Dispose()
-	l.Infof("MarketDataAdapater Cleaup")
+}
+
+// Close stops polling tickers, sends the queued requests followed by
+// an unsubscribe of every pair and closes the Context. It waits for
+// every goroutine of the adapter and its Context to exit, or ctx to be
+// done.
+func (adapter *MarketDataAdapter) Close(ctx gocontext.Context) error {
+	adapter.lock.Lock()
+	for pair := range adapter.tickers {
+		adapter.stopTicker(pair)
+	}
+	pairs := make([]string, 0, len(adapter.subscriptions))
+	for pair := range adapter.subscriptions {
+		pairs = append(pairs, pair)
+	}
+	adapter.lock.Unlock()
+
+	error := adapter.Context.flush(ctx)
+	for _, pair := range pairs {
+		symbols := strings.Split(pair, ":")
+		adapter.Context.send(unsubscribeRequest(symbols[0], symbols[1]))
+	}
+	if close_error := adapter.Context.Close(ctx); error == nil {
+		error = close_error
+	}
+	if wait_error := waitRoutines(ctx, &adapter.routines); error == nil {
+		error = wait_error
+	}
+	return error
+}
+
+func (adapter *MarketDataAdapter) Cleanup() {
+	ctx, cancel := gocontext.WithTimeout(gocontext.Background(), REQUEST_TIMEOUT)
+	defer cancel()
+	if error := adapter.Close(ctx); error != nil {
+		adapter.Context.log().Errorf("MarketDataAdapter cleanup: %s", error)
+	}
+	adapter.Context.log().Infof("MarketDataAdapater Cleaup")
+	if adapter.Context.Logger != nil {
+		adapter.Context.Logger.Close()
+	}
+}
+
+// Puts a pushed ticker of a subscribed pair on the update channel.
+func (adapter *MarketDataAdapter) putTicker(m *Message) {
+	adapter.lock.RLock()
+	_, subscribed := adapter.subscriptions[m.Data.Pair.(string)]
+	adapter.lock.RUnlock()
+	if subscribed {
+		m.RecvTimestamp = adapter.Context.now().UnixNano()
+		adapter.UpdateChannel.Put(m)
+	}
+}
+
+func (adapter *MarketDataAdapter) handleTickPush(push *Response) {
+	data := tickPush{}
+	if error := json.Unmarshal(push.Data, &data); error != nil {
+		adapter.Context.log().Errorf("Unable to parse tick: %s", error)
+		return
+	}
+	m := &Message{}
+	m.Type = kTickPush
+	m.Data.Pair = data.Symbol1 + ":" + data.Symbol2
+	m.Data.Last = data.Price
+	m.Data.Volume = data.Volume
+	adapter.putTicker(m)
+}
+
+// The pair of ohlcv24 pushes is outside of data.
+func (adapter *MarketDataAdapter) handleOhlcv24Push(push *Response) {
+	data := [5]string{}
+	if error := json.Unmarshal(push.Data, &data); error != nil {
+		adapter.Context.log().Errorf("Unable to parse ohlcv24: %s", error)
+		return
+	}
+	m := &Message{}
+	m.Type = kOhlcv24Push
+	m.Data.Pair = push.pair()
+	var error error
+	for i, field := range []*Decimal{nil, &m.Data.High, &m.Data.Low, &m.Data.Last} {
+		if field != nil && error == nil {
+			*field, error = ParseDecimal(data[i])
+		}
+	}
+	volume, volume_error := strconv.ParseInt(data[4], 10, 64)
+	if error != nil || volume_error != nil {
+		adapter.Context.log().Errorf("Unable to parse ohlcv24 %v", data)
+		return
+	}
+	// Smallest units of the currency are units of the last decimal place
+	m.Data.Volume = Decimal(volume)
+	adapter.putTicker(m)
 }
@@ -1,10 +1,148 @@
 package cexio
 
 import (
+	"encoding/json"
+	"errors"
 	"github.com/golang-collections/go-datastructures/queue"
+	"github.com/sahmad98/cex.io/cexiotest"
+	buffer "github.com/sahmad98/cex.io/types"
+	"math"
+	"sync"
 	"testing"
+	"time"
 )
 
+// Parses a decimal literal for tests
+func d(data string) Decimal {
+	value, error := ParseDecimal(data)
+	if error != nil {
+		panic(error)
+	}
+	return value
+}
+
+func snapshotMessage(pair string, id int, bid, ask Decimal) *Message {
+	m := &Message{}
+	m.Type = "order-book-subscribe"
+	m.Data.Pair = pair
+	m.Data.Id = id
+	m.Data.Bids = [][]Decimal{{bid, d("1")}}
+	m.Data.Asks = [][]Decimal{{ask, d("1")}}
+	return m
+}
+
+func updateMessage(pair string, id int, bid Decimal) *Message {
+	m := &Message{}
+	m.Type = "md_update"
+	m.Data.Pair = pair
+	m.Data.Id = id
+	m.Data.Bids = [][]Decimal{{bid, d("2")}}
+	return m
+}
+
+func TestResyncOnSequenceGap(t *testing.T) {
+	context := &Context{SendChannel: make(chan Message, 16)}
+	md := newMarketDataAdapter(context)
+	resynced := []Orderbook{}
+	md.ResyncHandler = func(orderbook Orderbook) {
+		resynced = append(resynced, orderbook)
+	}
+	md.subscriptions["BTC:USD"] = 5
+	md.subscriptions["ETH:USD"] = 5
+
+	md.handleUpdate(snapshotMessage("BTC:USD", 10, d("100"), d("101")))
+	md.handleUpdate(snapshotMessage("ETH:USD", 20, d("10"), d("11")))
+	md.handleUpdate(updateMessage("BTC:USD", 11, d("99")))
+	// Gap: 12 is missing
+	md.handleUpdate(updateMessage("BTC:USD", 13, d("98")))
+	if orderbook, _ := md.Book("BTC:USD"); !orderbook.Stale {
+		t.Fatal("Expected BTC:USD to be stale after gap")
+	}
+	if len(context.SendChannel) != 2 {
+		t.Fatalf("Expected unsubscribe and subscribe, got %d requests", len(context.SendChannel))
+	}
+	if request := <-context.SendChannel; request.Type != "order-book-unsubscribe" {
+		t.Fatalf("Unexpected request %s", request.Type)
+	}
+	if request := <-context.SendChannel; request.Type != "order-book-subscribe" || request.Data.Depth != 5 {
+		t.Fatalf("Unexpected request %+v", request)
+	}
+
+	// Other pairs keep streaming
+	md.handleUpdate(updateMessage("ETH:USD", 21, d("9")))
+	if orderbook, _ := md.Book("ETH:USD"); orderbook.Id != 21 || orderbook.Stale {
+		t.Fatalf("ETH:USD not updated during resync: %+v", orderbook)
+	}
+
+	md.handleUpdate(updateMessage("BTC:USD", 14, d("97")))
+	md.handleUpdate(snapshotMessage("BTC:USD", 12, d("100"), d("101")))
+	orderbook, _ := md.Book("BTC:USD")
+	if orderbook.Stale || orderbook.Id != 14 {
+		t.Fatalf("Expected rebuilt orderbook at 14, got %+v", orderbook)
+	}
+	if len(resynced) != 1 || resynced[0].Id != 14 {
+		t.Fatalf("Expected one resync event, got %+v", resynced)
+	}
+	if len(md.pending["BTC:USD"]) != 0 {
+		t.Fatal("Pending updates not drained")
+	}
+}
+
+// Readers take snapshots while the update goroutine mutates the books,
+// run with -race.
+func TestConcurrentBookReaders(t *testing.T) {
+	context := &Context{SendChannel: make(chan Message, 16)}
+	md := newMarketDataAdapter(context)
+	md.subscriptions["BTC:USD"] = 5
+	md.subscriptions["ETH:USD"] = 5
+	md.handleUpdate(snapshotMessage("BTC:USD", 1, d("100"), d("101")))
+	md.handleUpdate(snapshotMessage("ETH:USD", 1, d("10"), d("11")))
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				for _, orderbook := range md.Books() {
+					if orderbook.Bids.Len() > 5 || orderbook.Asks.Len() > 5 {
+						t.Errorf("Depth exceeded in %+v", orderbook)
+					}
+				}
+				if orderbook, ok := md.Book("BTC:USD"); ok {
+					orderbook.Bids.Set(d("1"), d("1"))
+				}
+			}
+		}()
+	}
+
+	for id := 2; id < 1000; id++ {
+		md.handleUpdate(updateMessage("BTC:USD", id, Decimal(id)*kDecimalScale))
+		md.handleUpdate(updateMessage("ETH:USD", id, Decimal(id)*kDecimalScale))
+	}
+	close(done)
+	wg.Wait()
+
+	books := md.Books()
+	if len(books) != 2 || books[0].Pair != "BTC:USD" || books[1].Pair != "ETH:USD" {
+		t.Fatalf("Unexpected books %+v", books)
+	}
+	for _, orderbook := range books {
+		if orderbook.Id != 999 {
+			t.Fatalf("Expected book at 999, got %+v", orderbook)
+		}
+		if best, _ := orderbook.Bids.Best(); best.Price != d("999") {
+			t.Fatalf("Snapshot modified the book: %+v", orderbook.Bids)
+		}
+	}
+}
+
 func BenchmarkRingBufferGetPut(b *testing.B) {
 	x := queue.NewRingBuffer(16)
 	for i := 0; i < b.N; i++ {
@@ -17,54 +155,317 @@ func BenchmarkUpdateTicker(b *testing.B) {
 	md := MarketDataAdapter{}
 	orderbook := Orderbook{}
 	m := Message{}
-	m.Data.Low = "1235.223"
-	m.Data.High = "1254.223"
-	m.Data.Bid = float32(125.25)
-	m.Data.Ask = float32(132.25)
-	m.Data.Last = "125.25"
-	m.Data.Volume = "125.25"
+	m.Data.Low = d("1235.223")
+	m.Data.High = d("1254.223")
+	m.Data.Bid = d("125.25")
+	m.Data.Ask = d("132.25")
+	m.Data.Last = d("125.25")
+	m.Data.Volume = d("125.25")
 
 	for i := 0; i < b.N; i++ {
 		md.UpdateTicker(&m, &orderbook)
 	}
 }
 
-func BenchmarkParseFloat(b *testing.B) {
+func BenchmarkParseDecimal(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		ParseDecimal("125.25")
+	}
+}
+
+func BenchmarkUnmarshalUpdate(b *testing.B) {
+	payload := []byte(`{"e":"md_update","data":{"id":1,"pair":"BTC:USD","time":1528000000000,` +
+		`"bids":[[6500.1,"0.01000000"],[6499.5,0]],"asks":[[6510.25,1.5]]}}`)
 	for i := 0; i < b.N; i++ {
-		ParseFloat32("125.25")
+		m := Message{}
+		json.Unmarshal(payload, &m)
+	}
+}
+
+func TestParseDecimal(t *testing.T) {
+	valid := map[string]Decimal{
+		"6512.25":     651225000000,
+		"-0.001":      -100000,
+		"0.00000001":  1,
+		"1e-5":        1000,
+		"2.5E3":       250000000000,
+		"10.12300000": 1012300000,
+		".5":          50000000,
+		"+3":          300000000,
+		"0e27":        0,
+		"1e-8":        1,
+	}
+	for input, expected := range valid {
+		value, error := ParseDecimal(input)
+		if error != nil || value != expected {
+			t.Errorf("ParseDecimal(%q) = %d, %v", input, value, error)
+		}
+	}
+	for _, input := range []string{"", "-", ".", "abc", "1.2.3", "1e", "0.000000001", "99999999999999999999",
+		"1e-300000000", "1e300000000", "1e28", "1e-28", "1e99999999999999999999"} {
+		if _, error := ParseDecimal(input); !errors.Is(error, ErrInvalidDecimal) {
+			t.Errorf("ParseDecimal(%q) did not fail: %v", input, error)
+		}
+	}
+	// Huge exponents are rejected before any zeros are padded
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		ParseDecimal("1e-2000000000")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Parsing huge exponents took %s", elapsed)
+	}
+	if d("6512.25").String() != "6512.25" || d("-0.001").String() != "-0.001" || d("3").String() != "3" {
+		t.Error("Unexpected decimal formatting")
+	}
+	if d("0.1").Mul(d("0.2")) != d("0.02") || d("-1.5").Mul(d("2")) != d("-3") {
+		t.Error("Unexpected decimal product")
+	}
+	if d("1").Div(d("3")) != d("0.33333333") || d("-300").Div(d("600")) != d("-0.5") || d("1").Div(0) != math.MaxInt64 {
+		t.Error("Unexpected decimal quotient")
+	}
+}
+
+func TestMessageDecimals(t *testing.T) {
+	m := Message{}
+	payload := `{"e":"ticker","data":{"low":"290","high":"296.4","last":"293.6","volume":"1.23456789","bid":293.1,"ask":293.5,` +
+		`"bids":[[0.1,"0.30000000"]]}}`
+	if error := json.Unmarshal([]byte(payload), &m); error != nil {
+		t.Fatal(error)
+	}
+	if m.Data.High != d("296.4") || m.Data.Volume != d("1.23456789") || m.Data.Bid != d("293.1") || m.Data.Bids[0][1] != d("0.3") {
+		t.Fatalf("Unexpected decimals %+v", m.Data)
+	}
+	// float32 can not represent 0.1 + 0.2 == 0.3
+	if m.Data.Bids[0][0]+d("0.2") != d("0.3") {
+		t.Fatal("Decimal addition not exact")
+	}
+	if error := json.Unmarshal([]byte(`{"e":"ticker","data":{"low":"29O"}}`), &m); error == nil {
+		t.Fatal("Expected parse error for malformed decimal")
 	}
 }
 
 func BenchmarkOrderbookLookup(b *testing.B) {
-	ob1 := Orderbook{}
-	ob1.Pair = "BTCUSD"
-	ob2 := Orderbook{}
-	ob2.Pair = "ETHUSD"
-	ob3 := Orderbook{}
-	ob3.Pair = "BTCETH"
-	ob_map["BTCUSD"] = &ob1
-	ob_map["ETHUSD"] = &ob2
-	ob_map["BTCETH"] = &ob3
-	pairs := []string{"BTCUSD", "ETHUSD", "BTCETH"}
+	md := newMarketDataAdapter(&Context{})
+	pairs := []string{"BTC:USD", "ETH:USD", "BTC:ETH"}
+	for _, pair := range pairs {
+		md.books[pair] = newBenchmarkOrderbook(20)
+	}
+	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_ = ob_map[pairs[i%3]]
+		_, _ = md.Book(pairs[i%3])
 	}
 }
 
+func newBenchmarkOrderbook(levels int) *Orderbook {
+	ob := &Orderbook{}
+	ob.initalize(0)
+	tick := kDecimalScale / 4
+	for i := 0; i < levels; i++ {
+		ob.updateLevel(DecimalFromInt(6500)-Decimal(i)*tick, kDecimalScale, kBuy)
+		ob.updateLevel(DecimalFromInt(6600)+Decimal(i)*tick, kDecimalScale, kSell)
+	}
+	return ob
+}
+
+// Removes the 5th bid and puts it back in place, the shifted last
+// level is still in the backing array.
 func BenchmarkOrderbookRemoveLevel(b *testing.B) {
-	ob := Orderbook{}
-	ob.initalize()
+	ob := newBenchmarkOrderbook(6)
+	removed := ob.Bids.Data[4]
+	if !ob.Bids.Remove(removed.Price) {
+		b.Fatal("Level not found")
+	}
+	ob.Bids.Data = ob.Bids.Data[:6]
+	ob.Bids.Data[4] = removed
+	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		ob.Bids.Data[4].Price = 6556.25
-		ob.removeLevel(6556.25, kBuy)
+		ob.removeLevel(removed.Price, kBuy)
+		ob.Bids.Data = ob.Bids.Data[:6]
+		ob.Bids.Data[4] = removed
+	}
+	b.StopTimer()
+	if ob.Bids.Len() != 6 || ob.Bids.Data[4] != removed || ob.Bids.Data[5].Price != d("6498.75") {
+		b.Fatalf("Book not restored %+v", ob.Bids.Data)
 	}
 }
 
 func BenchmarkOrderbookUpdateLevel(b *testing.B) {
-	ob := Orderbook{}
-	ob.initalize()
+	ob := newBenchmarkOrderbook(6)
+	price, qty := d("6499"), d("0.0225")
+	for i := 0; i < b.N; i++ {
+		ob.updateLevel(price, qty, kBuy)
+	}
+}
+
+func BenchmarkOrderbookInsertRemoveLevel(b *testing.B) {
+	ob := newBenchmarkOrderbook(6)
+	price, qty := d("6499.1"), d("0.0225")
+	for i := 0; i < b.N; i++ {
+		ob.updateLevel(price, qty, kBuy)
+		ob.updateLevel(price, 0, kBuy)
+	}
+}
+
+func BenchmarkOrderbookUpdateLevelDeep(b *testing.B) {
+	ob := newBenchmarkOrderbook(5000)
+	qty := d("0.0225")
 	for i := 0; i < b.N; i++ {
-		ob.Asks.Data[4].Price = 6556.25
-		ob.updateLevel(6556.25, 0.0225, kBuy)
+		ob.updateLevel(DecimalFromInt(6600)+Decimal(i%5000)*kDecimalScale/4, qty, kSell)
+	}
+}
+
+func BenchmarkOrderbookInsertRemoveLevelDeep(b *testing.B) {
+	ob := newBenchmarkOrderbook(5000)
+	qty := d("0.0225")
+	for i := 0; i < b.N; i++ {
+		price := d("6499.1") - Decimal(i%5000)*kDecimalScale/4
+		ob.updateLevel(price, qty, kBuy)
+		ob.updateLevel(price, 0, kBuy)
+	}
+}
+
+func TestLevelsOrderAndDepth(t *testing.T) {
+	ob := Orderbook{}
+	ob.initalize(3)
+	for _, price := range []string{"10", "12", "11", "9", "13"} {
+		ob.updateLevel(d(price), d("1"), kBuy)
+		ob.updateLevel(d(price), d("1"), kSell)
+	}
+	bids := []string{"13", "12", "11"}
+	asks := []string{"9", "10", "11"}
+	for i := range bids {
+		if ob.Bids.Data[i].Price != d(bids[i]) || ob.Asks.Data[i].Price != d(asks[i]) {
+			t.Fatalf("Unexpected levels bids %+v asks %+v", ob.Bids.Data, ob.Asks.Data)
+		}
+	}
+
+	ob.updateLevel(d("12"), 0, kBuy)
+	ob.updateLevel(d("11"), d("5"), kBuy)
+	if ob.Bids.Len() != 2 || ob.Bids.Data[1] != (Level{d("11"), d("5")}) {
+		t.Fatalf("Unexpected bids %+v", ob.Bids.Data)
+	}
+	if best, _ := ob.Asks.Best(); best.Price != d("9") {
+		t.Fatalf("Unexpected best ask %+v", best)
+	}
+
+	unlimited := newBenchmarkOrderbook(100)
+	if unlimited.Bids.Len() != 100 || unlimited.Asks.Len() != 100 {
+		t.Fatal("Unlimited book lost levels")
+	}
+}
+
+func TestOrderbookBuffer(t *testing.T) {
+	ob := newBenchmarkOrderbook(10)
+	ob.Pair = "BTC:USD"
+	decoded := buffer.GetRootAsOrderbook(ob.getBuffer(), 0)
+	bids := decoded.Bids(nil)
+	level := buffer.Level{}
+	if string(decoded.Pair()) != "BTC:USD" || bids.DataLength() != 10 || !bids.Data(&level, 1) || Decimal(level.Price()) != d("6499.75") {
+		t.Fatalf("Unexpected buffer contents")
+	}
+}
+
+func TestPushedTickers(t *testing.T) {
+	server := newFakeExchange(t)
+	server.Handle("subscribe", func(request cexiotest.Request, conn *cexiotest.Conn) {
+		conn.Send(map[string]interface{}{"e": "tick", "data": map[string]string{"symbol1": "ETH", "symbol2": "USD", "price": "10", "volume": "5"}})
+		conn.Send(map[string]interface{}{"e": "tick", "data": map[string]string{"symbol1": "BTC", "symbol2": "USD", "price": "100.5", "open24": "99", "volume": "2216.55447466"}})
+		conn.Send(map[string]interface{}{"e": "ohlcv24", "pair": "BTC:USD", "data": []string{"99", "102.5", "98.25", "100.75", "239567198169"}})
+	})
+	context := newTestContext(t, server)
+	md := NewMarketDataAdapter(context)
+	tickers := make(chan TickerEvent, 16)
+	md.OnTicker("", func(event TickerEvent) { tickers <- event })
+	md.Subscribe("BTC", "USD", 5)
+
+	next := func() TickerEvent {
+		select {
+		case event := <-tickers:
+			return event
+		case <-time.After(2 * time.Second):
+			t.Fatal("No ticker event received")
+		}
+		return TickerEvent{}
+	}
+	if event := next(); event.Pair != "BTC:USD" || event.Last != d("100.5") || event.Volume != d("2216.55447466") {
+		t.Fatalf("Unexpected tick %+v", event)
+	}
+	if event := next(); event.High != d("102.5") || event.Low != d("98.25") || event.Last != d("100.75") || event.Volume != d("2395.67198169") {
+		t.Fatalf("Unexpected ohlcv24 %+v", event)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if orderbook, _ := md.Book("BTC:USD"); orderbook.High == d("102.5") && orderbook.LastPrice == d("100.75") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Ticker not applied to orderbook")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if server.Requests("subscribe") != 1 || server.Requests("ticker") != 0 {
+		t.Fatalf("Unexpected requests, %d subscribe and %d ticker", server.Requests("subscribe"), server.Requests("ticker"))
+	}
+}
+
+func TestTickerPollingFallback(t *testing.T) {
+	server := newHandledServer(t, func(request cexiotest.Request, conn *cexiotest.Conn) {
+		if request.Type == "ticker" {
+			conn.Send(map[string]interface{}{"e": "ticker", "ok": "ok", "data": map[string]interface{}{
+				"pair": []string{"BTC", "USD"}, "last": "100", "bid": "99.5", "ask": "100.5"}})
+		}
+	})
+	polls := func() int { return server.Requests("ticker") }
+	context, error := NewContext(Options{
+		Endpoint:      server.URL,
+		TickerPolling: map[string]time.Duration{"btc:usd": 20 * time.Millisecond},
+	})
+	if error != nil {
+		t.Fatal(error)
+	}
+	defer context.closeConnection()
+	md := NewMarketDataAdapter(context)
+	tickers := make(chan TickerEvent, 64)
+	md.OnTicker("BTC:USD", func(event TickerEvent) { tickers <- event })
+
+	md.Subscribe("BTC", "USD", 5)
+	select {
+	case event := <-tickers:
+		if event.Bid != d("99.5") || event.Last != d("100") {
+			t.Fatalf("Unexpected ticker %+v", event)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("No polled ticker received")
+	}
+
+	md.Unsubscribe("BTC", "USD")
+	time.Sleep(50 * time.Millisecond)
+	stopped := polls()
+	time.Sleep(100 * time.Millisecond)
+	if polls() != stopped {
+		t.Fatal("Polling continued after unsubscribe")
+	}
+
+	// Changing the interval of an unsubscribed pair does not poll
+	md.SetTickerPolling("BTC", "USD", 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	if polls() != stopped {
+		t.Fatal("Polling of unsubscribed pair")
+	}
+
+	// Symbols are matched in upper case
+	md.SetTickerPolling("btc", "usd", 0)
+	md.Subscribe("BTC", "USD", 5)
+	time.Sleep(50 * time.Millisecond)
+	if polls() != stopped {
+		t.Fatal("Polling after it was stopped")
+	}
+	md.SetTickerPolling("btc", "usd", 10*time.Millisecond)
+	select {
+	case <-tickers:
+	case <-time.After(2 * time.Second):
+		t.Fatal("No polled ticker received")
 	}
 }
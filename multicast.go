@@ -0,0 +1,331 @@
+package cexio
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/logger"
+	"golang.org/x/net/ipv4"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Packets of the multicast channel start with a header of
+//
+//	magic "CXMD", version, flags, kind, pair length,
+//	session, sequence, send time in ns (uint64 big endian each),
+//	pair
+//
+// followed by the flatbuffer of the orderbook, candle or trade.
+// Sequences count the packets of a pair from 1 within a session, which
+// changes whenever the publisher restarts.
+const (
+	kPacketMagic      = "CXMD"
+	kPacketVersion    = 1
+	kPacketHeaderSize = 32
+	kMaxPacketSize    = 65507
+)
+
+// Set on packets replayed by the retransmission service.
+const kPacketRetransmitted = 1
+
+const (
+	kDefaultMulticastTTL     = 1
+	kDefaultRetransmitWindow = 4096
+	// Max time a retransmission request may take
+	kRetransmitTimeout = 5 * time.Second
+)
+
+var ErrInvalidPacket = errors.New("cexio: invalid multicast packet")
+
+// PacketKind tells what the payload of a packet is.
+type PacketKind uint8
+
+const (
+	PacketBook PacketKind = iota + 1
+	PacketCandle
+	PacketTrade
+)
+
+// PacketHeader precedes the payload of every multicast packet.
+type PacketHeader struct {
+	Kind     PacketKind
+	Pair     string
+	Session  uint64
+	Sequence uint64
+	Time     time.Time
+	// Replayed by the retransmission service
+	Retransmitted bool
+}
+
+func encodePacket(header PacketHeader, payload []byte) []byte {
+	packet := make([]byte, kPacketHeaderSize+len(header.Pair)+len(payload))
+	copy(packet, kPacketMagic)
+	packet[4] = kPacketVersion
+	if header.Retransmitted {
+		packet[5] = kPacketRetransmitted
+	}
+	packet[6] = byte(header.Kind)
+	packet[7] = byte(len(header.Pair))
+	binary.BigEndian.PutUint64(packet[8:], header.Session)
+	binary.BigEndian.PutUint64(packet[16:], header.Sequence)
+	binary.BigEndian.PutUint64(packet[24:], uint64(header.Time.UnixNano()))
+	copy(packet[kPacketHeaderSize:], header.Pair)
+	copy(packet[kPacketHeaderSize+len(header.Pair):], payload)
+	return packet
+}
+
+// DecodePacket splits a multicast packet into its header and payload,
+// the payload shares the memory of packet.
+func DecodePacket(packet []byte) (PacketHeader, []byte, error) {
+	if len(packet) < kPacketHeaderSize || string(packet[:4]) != kPacketMagic || packet[4] != kPacketVersion {
+		return PacketHeader{}, nil, ErrInvalidPacket
+	}
+	pair_end := kPacketHeaderSize + int(packet[7])
+	if len(packet) < pair_end {
+		return PacketHeader{}, nil, ErrInvalidPacket
+	}
+	header := PacketHeader{
+		Kind:          PacketKind(packet[6]),
+		Pair:          string(packet[kPacketHeaderSize:pair_end]),
+		Session:       binary.BigEndian.Uint64(packet[8:]),
+		Sequence:      binary.BigEndian.Uint64(packet[16:]),
+		Time:          time.Unix(0, int64(binary.BigEndian.Uint64(packet[24:]))),
+		Retransmitted: packet[5]&kPacketRetransmitted != 0,
+	}
+	return header, packet[pair_end:], nil
+}
+
+// MulticastOptions configure the multicast channel of a market data
+// adapter, see Options.Multicast.
+type MulticastOptions struct {
+	// Group address like "239.255.0.1:10560". A unicast address is
+	// published to as well, e.g. on hosts without multicast routes.
+	Group string
+	// Hops packets may travel, defaults to 1 for the local network
+	TTL int
+	// Name of the network interface sending packets, the default route
+	// if empty
+	Interface string
+	// TCP address of the retransmission service like ":10561", disabled
+	// if empty
+	RetransmitAddress string
+	// Packets per pair kept for retransmission
+	RetransmitWindow int
+}
+
+func (opts *MulticastOptions) validate() error {
+	if opts.Group == "" {
+		return nil
+	}
+	if _, error := net.ResolveUDPAddr("udp4", opts.Group); error != nil {
+		return error
+	}
+	if opts.TTL < 0 || opts.TTL > 255 {
+		return fmt.Errorf("ttl %d out of range", opts.TTL)
+	}
+	if opts.TTL == 0 {
+		opts.TTL = kDefaultMulticastTTL
+	}
+	if opts.Interface != "" {
+		if _, error := net.InterfaceByName(opts.Interface); error != nil {
+			return error
+		}
+	}
+	if opts.RetransmitAddress != "" {
+		if _, error := net.ResolveTCPAddr("tcp", opts.RetransmitAddress); error != nil {
+			return error
+		}
+	}
+	if opts.RetransmitWindow < 0 {
+		return errors.New("negative retransmit window")
+	}
+	if opts.RetransmitWindow == 0 {
+		opts.RetransmitWindow = kDefaultRetransmitWindow
+	}
+	return nil
+}
+
+// Last packets of a pair, packet sequence is stored at sequence modulo
+// the size of the window.
+type packetWindow struct {
+	packets [][]byte
+	last    uint64
+}
+
+func (window *packetWindow) get(sequence uint64) []byte {
+	if sequence == 0 || sequence > window.last || window.last-sequence >= uint64(len(window.packets)) {
+		return nil
+	}
+	return window.packets[sequence%uint64(len(window.packets))]
+}
+
+// Request of the retransmission service, a line of JSON answered with
+// the packets of pair from from to to still held, each preceded by its
+// length as uint32 big endian. The connection is closed after the last.
+type RetransmitRequest struct {
+	Session uint64 `json:"session"`
+	Pair    string `json:"pair"`
+	From    uint64 `json:"from"`
+	To      uint64 `json:"to"`
+}
+
+// Publishes packets to the multicast group and serves retransmissions
+// of the last RetransmitWindow packets of each pair.
+type multicastPublisher struct {
+	options  MulticastOptions
+	log      *logger.Logger
+	conn     *net.UDPConn
+	group    *net.UDPAddr
+	session  uint64
+	listener net.Listener
+	// Guards windows and keeps writes in sequence
+	lock    sync.Mutex
+	windows map[string]*packetWindow
+	serving sync.WaitGroup
+}
+
+func newMulticastPublisher(opts MulticastOptions, log *logger.Logger) (*multicastPublisher, error) {
+	group, error := net.ResolveUDPAddr("udp4", opts.Group)
+	if error != nil {
+		return nil, error
+	}
+	conn, error := net.ListenUDP("udp4", &net.UDPAddr{})
+	if error != nil {
+		return nil, error
+	}
+	packet_conn := ipv4.NewPacketConn(conn)
+	if error := packet_conn.SetMulticastTTL(opts.TTL); error != nil {
+		conn.Close()
+		return nil, error
+	}
+	// Subscribers on the same host receive the packets as well
+	packet_conn.SetMulticastLoopback(true)
+	if opts.Interface != "" {
+		iface, error := net.InterfaceByName(opts.Interface)
+		if error == nil {
+			error = packet_conn.SetMulticastInterface(iface)
+		}
+		if error != nil {
+			conn.Close()
+			return nil, error
+		}
+	}
+	publisher := &multicastPublisher{
+		options: opts,
+		log:     log,
+		conn:    conn,
+		group:   group,
+		session: uint64(time.Now().UnixNano()),
+		windows: make(map[string]*packetWindow),
+	}
+	if opts.RetransmitAddress != "" {
+		if publisher.listener, error = net.Listen("tcp", opts.RetransmitAddress); error != nil {
+			conn.Close()
+			return nil, error
+		}
+		publisher.serving.Add(1)
+		go publisher.serveRetransmits()
+	}
+	return publisher, nil
+}
+
+// Returns the next packet of pair and keeps it for retransmission.
+func (publisher *multicastPublisher) next(kind PacketKind, pair string, payload []byte) []byte {
+	publisher.lock.Lock()
+	defer publisher.lock.Unlock()
+	return publisher.nextLocked(kind, pair, payload)
+}
+
+// Requires lock to be held.
+func (publisher *multicastPublisher) nextLocked(kind PacketKind, pair string, payload []byte) []byte {
+	window, ok := publisher.windows[pair]
+	if !ok {
+		window = &packetWindow{packets: make([][]byte, publisher.options.RetransmitWindow)}
+		publisher.windows[pair] = window
+	}
+	window.last++
+	packet := encodePacket(PacketHeader{Kind: kind, Pair: pair, Session: publisher.session, Sequence: window.last, Time: time.Now()}, payload)
+	window.packets[window.last%uint64(len(window.packets))] = packet
+	return packet
+}
+
+// Sends the next packet of pair. Books, candles and trades of a pair
+// are published from different goroutines, the lock keeps the packets
+// on the wire in sequence.
+func (publisher *multicastPublisher) publish(kind PacketKind, pair string, payload []byte) {
+	if kPacketHeaderSize+len(pair)+len(payload) > kMaxPacketSize || len(pair) > 255 {
+		publisher.log.Errorf("Multicast packet of %s too large: %d bytes", pair, len(payload))
+		return
+	}
+	publisher.lock.Lock()
+	defer publisher.lock.Unlock()
+	packet := publisher.nextLocked(kind, pair, payload)
+	if _, error := publisher.conn.WriteToUDP(packet, publisher.group); error != nil {
+		publisher.log.Infof("Error Relaying, %s", error)
+	}
+}
+
+// Returns copies of the packets of request still held, flagged as
+// retransmitted.
+func (publisher *multicastPublisher) retransmits(request RetransmitRequest) [][]byte {
+	if request.Session != publisher.session || request.From == 0 || request.To < request.From {
+		return nil
+	}
+	publisher.lock.Lock()
+	defer publisher.lock.Unlock()
+	window, ok := publisher.windows[request.Pair]
+	if !ok {
+		return nil
+	}
+	packets := [][]byte{}
+	for sequence := request.From; sequence <= request.To && sequence <= window.last; sequence++ {
+		if packet := window.get(sequence); packet != nil {
+			packet = append([]byte(nil), packet...)
+			packet[5] |= kPacketRetransmitted
+			packets = append(packets, packet)
+		}
+	}
+	return packets
+}
+
+func (publisher *multicastPublisher) serveRetransmits() {
+	defer publisher.serving.Done()
+	for {
+		conn, error := publisher.listener.Accept()
+		if error != nil {
+			return
+		}
+		publisher.serving.Add(1)
+		go func() {
+			defer publisher.serving.Done()
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(kRetransmitTimeout))
+			request := RetransmitRequest{}
+			line, error := bufio.NewReader(io.LimitReader(conn, 1024)).ReadBytes('\n')
+			if error != nil || json.Unmarshal(line, &request) != nil {
+				return
+			}
+			writer := bufio.NewWriter(conn)
+			length := make([]byte, 4)
+			for _, packet := range publisher.retransmits(request) {
+				binary.BigEndian.PutUint32(length, uint32(len(packet)))
+				writer.Write(length)
+				writer.Write(packet)
+			}
+			writer.Flush()
+		}()
+	}
+}
+
+func (publisher *multicastPublisher) close() {
+	publisher.conn.Close()
+	if publisher.listener != nil {
+		publisher.listener.Close()
+	}
+	publisher.serving.Wait()
+}
@@ -0,0 +1,192 @@
+package cexio
+
+import (
+	"bytes"
+	buffer "github.com/sahmad98/cex.io/types"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+type receivedPacket struct {
+	header  PacketHeader
+	payload string
+}
+
+// Returns a subscriber on a local port and the packets it delivers.
+func newTestSubscriber(t *testing.T, retransmit_address string) (*MulticastSubscriber, chan receivedPacket, *[][2]uint64) {
+	packets := make(chan receivedPacket, 64)
+	var lock sync.Mutex
+	gaps := [][2]uint64{}
+	subscriber, error := NewMulticastSubscriber(SubscriberOptions{
+		Group:             "127.0.0.1:0",
+		RetransmitAddress: retransmit_address,
+		Handler: func(header PacketHeader, payload []byte) {
+			packets <- receivedPacket{header, string(payload)}
+		},
+		GapHandler: func(pair string, from, to uint64) {
+			lock.Lock()
+			gaps = append(gaps, [2]uint64{from, to})
+			lock.Unlock()
+		},
+	})
+	if error != nil {
+		t.Fatal(error)
+	}
+	t.Cleanup(func() { subscriber.Close() })
+	return subscriber, packets, &gaps
+}
+
+func newTestPublisher(t *testing.T, group string, window int) *multicastPublisher {
+	opts := MulticastOptions{Group: group, RetransmitAddress: "127.0.0.1:0", RetransmitWindow: window}
+	if error := opts.validate(); error != nil {
+		t.Fatal(error)
+	}
+	publisher, error := newMulticastPublisher(opts, (&Context{}).log())
+	if error != nil {
+		t.Fatal(error)
+	}
+	t.Cleanup(publisher.close)
+	return publisher
+}
+
+func receivePacket(t *testing.T, packets chan receivedPacket) receivedPacket {
+	select {
+	case packet := <-packets:
+		return packet
+	case <-time.After(2 * time.Second):
+		t.Fatal("No packet received")
+	}
+	return receivedPacket{}
+}
+
+func TestPacketHeader(t *testing.T) {
+	header := PacketHeader{Kind: PacketTrade, Pair: "BTC:USD", Session: 7, Sequence: 42, Time: time.Unix(1, 500), Retransmitted: true}
+	decoded, payload, error := DecodePacket(encodePacket(header, []byte("payload")))
+	if error != nil || string(payload) != "payload" {
+		t.Fatalf("Unexpected payload %q %v", payload, error)
+	}
+	if !decoded.Time.Equal(header.Time) {
+		t.Fatalf("Unexpected time %s", decoded.Time)
+	}
+	decoded.Time = header.Time
+	if decoded != header {
+		t.Fatalf("Unexpected header %+v", decoded)
+	}
+	for _, packet := range [][]byte{nil, []byte("CXMD"), bytes.Repeat([]byte{0}, kPacketHeaderSize)} {
+		if _, _, error := DecodePacket(packet); error != ErrInvalidPacket {
+			t.Fatalf("Expected invalid packet for %q", packet)
+		}
+	}
+}
+
+func TestMulticastGapRecovery(t *testing.T) {
+	publisher := newTestPublisher(t, "127.0.0.1:9", 3)
+	subscriber, packets, gaps := newTestSubscriber(t, publisher.listener.Addr().String())
+	publisher.group = subscriber.Addr().(*net.UDPAddr)
+
+	publisher.publish(PacketBook, "BTC:USD", []byte("1"))
+	// 2 and 3 are lost on the way
+	publisher.next(PacketBook, "BTC:USD", []byte("2"))
+	publisher.next(PacketBook, "BTC:USD", []byte("3"))
+	publisher.publish(PacketBook, "BTC:USD", []byte("4"))
+	for _, expected := range []string{"1", "2", "3", "4"} {
+		packet := receivePacket(t, packets)
+		if packet.payload != expected || packet.header.Retransmitted != (expected == "2" || expected == "3") {
+			t.Fatalf("Expected packet %s, got %+v", expected, packet)
+		}
+	}
+
+	// Only the last 3 packets are kept, 5 and 6 are gone by the time 9
+	// arrives
+	for _, payload := range []string{"5", "6", "7", "8"} {
+		publisher.next(PacketBook, "BTC:USD", []byte(payload))
+	}
+	publisher.publish(PacketBook, "BTC:USD", []byte("9"))
+	for _, expected := range []string{"7", "8", "9"} {
+		if packet := receivePacket(t, packets); packet.payload != expected {
+			t.Fatalf("Expected packet %s, got %+v", expected, packet)
+		}
+	}
+
+	// Late copies are dropped
+	publisher.conn.WriteToUDP(encodePacket(PacketHeader{Kind: PacketBook, Pair: "BTC:USD", Session: publisher.session, Sequence: 8}, []byte("8")), publisher.group)
+
+	// Other pairs have their own sequence
+	publisher.publish(PacketTrade, "ETH:USD", []byte("eth"))
+	if packet := receivePacket(t, packets); packet.header.Pair != "ETH:USD" || packet.header.Sequence != 1 || packet.header.Kind != PacketTrade {
+		t.Fatalf("Unexpected packet %+v", packet)
+	}
+
+	stats := subscriber.Stats()
+	if stats.Packets != 8 || stats.Recovered != 4 || stats.Lost != 2 || stats.Duplicates != 1 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+	if len(*gaps) != 1 || (*gaps)[0] != [2]uint64{5, 6} {
+		t.Fatalf("Unexpected gaps %v", *gaps)
+	}
+}
+
+func TestMulticastPublishBooks(t *testing.T) {
+	subscriber, packets, _ := newTestSubscriber(t, "")
+	opts := MulticastOptions{Group: subscriber.Addr().String()}
+	if error := opts.validate(); error != nil {
+		t.Fatal(error)
+	}
+	md := newMarketDataAdapter(&Context{SendChannel: make(chan Message, 16), options: Options{Multicast: opts}})
+	md.runOrderbookPublisher()
+	defer md.shutdown()
+	md.subscriptions["BTC:USD"] = 5
+
+	md.handleUpdate(snapshotMessage("BTC:USD", 10, d("100"), d("101")))
+	md.handleUpdate(updateMessage("BTC:USD", 11, d("99")))
+	for sequence := uint64(1); sequence <= 2; sequence++ {
+		packet := receivePacket(t, packets)
+		if packet.header.Kind != PacketBook || packet.header.Pair != "BTC:USD" || packet.header.Sequence != sequence {
+			t.Fatalf("Unexpected header %+v", packet.header)
+		}
+		orderbook := buffer.GetRootAsOrderbook([]byte(packet.payload), 0)
+		if orderbook.Id() != int32(9+sequence) || string(orderbook.Pair()) != "BTC:USD" {
+			t.Fatalf("Unexpected orderbook %d %s", orderbook.Id(), orderbook.Pair())
+		}
+	}
+}
+
+// A multicast publisher that fails to open leaves unicast publishing
+// running.
+func TestMulticastFailureKeepsUnicast(t *testing.T) {
+	taken, error := net.Listen("tcp", "127.0.0.1:0")
+	if error != nil {
+		t.Fatal(error)
+	}
+	defer taken.Close()
+	receiver, error := net.ListenPacket("udp", "127.0.0.1:0")
+	if error != nil {
+		t.Fatal(error)
+	}
+	defer receiver.Close()
+	opts := MulticastOptions{Group: "127.0.0.1:9", RetransmitAddress: taken.Addr().String()}
+	if error := opts.validate(); error != nil {
+		t.Fatal(error)
+	}
+	md := newMarketDataAdapter(&Context{SendChannel: make(chan Message, 16),
+		options: Options{PublishAddress: receiver.LocalAddr().String(), Multicast: opts}})
+	md.runOrderbookPublisher()
+	defer md.shutdown()
+	if md.multicast != nil {
+		t.Fatal("Multicast publisher opened on a taken port")
+	}
+	md.subscriptions["BTC:USD"] = 5
+	md.handleUpdate(snapshotMessage("BTC:USD", 10, d("100"), d("101")))
+
+	receiver.SetReadDeadline(time.Now().Add(2 * time.Second))
+	packet := make([]byte, kMaxPacketSize)
+	size, _, error := receiver.ReadFrom(packet)
+	if error != nil {
+		t.Fatal(error)
+	}
+	if orderbook := buffer.GetRootAsOrderbook(packet[:size], 0); orderbook.Id() != 10 {
+		t.Fatalf("Unexpected orderbook %d", orderbook.Id())
+	}
+}
@@ -0,0 +1,275 @@
+package cexio
+
+import (
+	gocontext "context"
+	"errors"
+	"fmt"
+	"github.com/google/logger"
+	"github.com/gorilla/websocket"
+	"github.com/spf13/viper"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	kDefaultEndpoint     = "wss://ws.cex.io/ws"
+	kDefaultRestEndpoint = "https://cex.io/api"
+	kDefaultQueueSize    = 16
+)
+
+var ErrInvalidOptions = errors.New("cexio: invalid options")
+
+// Options configure a Context, see NewContext. Zero values are
+// replaced by defaults.
+type Options struct {
+	// Websocket endpoint, defaults to wss://ws.cex.io/ws
+	Endpoint string
+	// API credentials, only needed to Authenticate and for private
+	// REST requests, which also need the user id
+	Key    string
+	Secret string
+	UserId string
+	// REST endpoint of a RestClient, defaults to https://cex.io/api
+	RestEndpoint string
+	// Defaults to http.DefaultClient
+	HTTPClient *http.Client
+	// Defaults to logging errors to stderr only, infos and warnings are
+	// discarded
+	Logger *logger.Logger
+	// Capacity of the send queues and of the receive buffer
+	SendQueueSize int
+	RecvQueueSize int
+	// Max requests written per second, 0 is unlimited. Up to SendBurst
+	// requests may be written at once after a quiet period.
+	SendRate  float64
+	SendBurst int
+	// Ticker poll interval by pair like "BTC:USD", as a fallback for
+	// pushed tickers, see MarketDataAdapter.SetTickerPolling
+	TickerPolling map[string]time.Duration
+	// Drops a ticker poll while the same poll is still queued
+	CoalesceTickers bool
+	// Defaults to websocket.DefaultDialer
+	Dialer *websocket.Dialer
+	// Opens the transport of the context instead of dialing Endpoint
+	// with Dialer, e.g. Replay.Dial
+	Dial func(ctx gocontext.Context) (Transport, error)
+	// Retries a failed first connection in the background like a lost
+	// one, instead of failing NewContext
+	RetryFirstDial bool
+	// Defaults to the system clock
+	Clock Clock
+	// Called on every connection state change, including the first
+	// connect in NewContext.
+	StateHandler StateFunc
+	// UDP address like "127.0.0.1:10550" market data adapters publish
+	// orderbooks to, empty disables publishing.
+	PublishAddress string
+	// Also publish candles to PublishAddress and the multicast group,
+	// see OnCandle
+	PublishCandles bool
+	// Also publish trades to PublishAddress and the multicast group,
+	// see OnTrade
+	PublishTrades bool
+	// Publishes orderbooks with sequenced headers to a multicast group,
+	// see MulticastSubscriber. Disabled if Group is empty.
+	Multicast MulticastOptions
+	// Limits checked before placing orders, see RiskGate
+	Risk RiskLimits
+	// Pairs subscriptions and orders are checked against, nil accepts
+	// any pair, see LoadPairRegistry
+	Pairs *PairRegistry
+	// Records the raw frames received, see Recorder
+	Record RecordOptions
+}
+
+func (opts Options) withDefaults() (Options, error) {
+	if opts.Endpoint == "" {
+		opts.Endpoint = kDefaultEndpoint
+	}
+	endpoint, error := url.Parse(opts.Endpoint)
+	if error != nil || (endpoint.Scheme != "ws" && endpoint.Scheme != "wss") {
+		return opts, fmt.Errorf("%w: endpoint %q is not a websocket url", ErrInvalidOptions, opts.Endpoint)
+	}
+	if opts.RestEndpoint == "" {
+		opts.RestEndpoint = kDefaultRestEndpoint
+	}
+	rest_endpoint, error := url.Parse(opts.RestEndpoint)
+	if error != nil || (rest_endpoint.Scheme != "http" && rest_endpoint.Scheme != "https") {
+		return opts, fmt.Errorf("%w: rest endpoint %q is not a http url", ErrInvalidOptions, opts.RestEndpoint)
+	}
+	if opts.SendQueueSize < 0 || opts.RecvQueueSize < 0 {
+		return opts, fmt.Errorf("%w: negative queue size", ErrInvalidOptions)
+	}
+	if opts.SendRate < 0 || opts.SendBurst < 0 {
+		return opts, fmt.Errorf("%w: negative send rate", ErrInvalidOptions)
+	}
+	for pair, interval := range opts.TickerPolling {
+		if interval <= 0 {
+			return opts, fmt.Errorf("%w: ticker poll interval of %s is not positive", ErrInvalidOptions, pair)
+		}
+	}
+	if opts.SendQueueSize == 0 {
+		opts.SendQueueSize = kDefaultQueueSize
+	}
+	if opts.RecvQueueSize == 0 {
+		opts.RecvQueueSize = kDefaultQueueSize
+	}
+	if opts.Dialer == nil {
+		opts.Dialer = websocket.DefaultDialer
+	}
+	if opts.Clock == nil {
+		opts.Clock = systemClock{}
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = http.DefaultClient
+	}
+	if opts.PublishAddress != "" {
+		if _, error := net.ResolveUDPAddr("udp", opts.PublishAddress); error != nil {
+			return opts, fmt.Errorf("%w: publish address: %s", ErrInvalidOptions, error)
+		}
+	}
+	if error := opts.Risk.validate(); error != nil {
+		return opts, fmt.Errorf("%w: %s", ErrInvalidOptions, error)
+	}
+	if error := opts.Record.validate(); error != nil {
+		return opts, fmt.Errorf("%w: record: %s", ErrInvalidOptions, error)
+	}
+	if error := opts.Multicast.validate(); error != nil {
+		return opts, fmt.Errorf("%w: multicast: %s", ErrInvalidOptions, error)
+	}
+	return opts, nil
+}
+
+// LoadOptions reads options from a TOML file like config/config.toml,
+// overridden by CEXIO_ environment variables such as CEXIO_AUTH_KEY for
+// auth.key. An empty path only reads the environment. The log file
+// given by log.path and log.filename is created if either is set.
+func LoadOptions(path string) (Options, error) {
+	config := viper.New()
+	config.SetEnvPrefix("cexio")
+	config.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	config.AutomaticEnv()
+	config.SetDefault("websocket.endpoint", kDefaultEndpoint)
+	config.SetDefault("rest.endpoint", kDefaultRestEndpoint)
+	config.SetDefault("log.path", ".")
+	config.SetDefault("log.filename", "")
+	config.SetDefault("udp.enabled", false)
+	config.SetDefault("udp.publish_ip", "127.0.0.1")
+	config.SetDefault("udp.publish_port", 10550)
+	config.SetDefault("udp.candles", false)
+	config.SetDefault("udp.trades", false)
+	config.SetDefault("multicast.enabled", false)
+	if path != "" {
+		config.SetConfigFile(path)
+		config.SetConfigType("toml")
+		if error := config.ReadInConfig(); error != nil {
+			return Options{}, error
+		}
+	}
+
+	opts := Options{
+		Endpoint: config.GetString("websocket.endpoint"),
+		Key:      config.GetString("auth.key"),
+		Secret:   config.GetString("auth.secret"),
+		UserId:   config.GetString("auth.user_id"),
+
+		RestEndpoint: config.GetString("rest.endpoint"),
+
+		Record: RecordOptions{
+			Dir:            config.GetString("record.dir"),
+			Pairs:          config.GetStringSlice("record.pairs"),
+			MaxFileSize:    config.GetInt64("record.max_file_size"),
+			RotateInterval: config.GetDuration("record.rotate_interval"),
+		},
+
+		SendRate:        config.GetFloat64("websocket.send_rate"),
+		SendBurst:       config.GetInt("websocket.send_burst"),
+		CoalesceTickers: config.GetBool("websocket.coalesce_tickers"),
+		RetryFirstDial:  config.GetBool("websocket.retry_first_dial"),
+	}
+	if config.GetBool("udp.enabled") {
+		opts.PublishAddress = net.JoinHostPort(config.GetString("udp.publish_ip"), strconv.Itoa(config.GetInt("udp.publish_port")))
+	}
+	if config.GetBool("udp.enabled") || config.GetBool("multicast.enabled") {
+		opts.PublishCandles = config.GetBool("udp.candles")
+		opts.PublishTrades = config.GetBool("udp.trades")
+	}
+	if config.GetBool("multicast.enabled") {
+		opts.Multicast = MulticastOptions{
+			Group:             config.GetString("multicast.group"),
+			TTL:               config.GetInt("multicast.ttl"),
+			Interface:         config.GetString("multicast.interface"),
+			RetransmitAddress: config.GetString("multicast.retransmit_address"),
+			RetransmitWindow:  config.GetInt("multicast.retransmit_window"),
+		}
+	}
+	for pair, interval := range config.GetStringMap("ticker_poll") {
+		duration, error := time.ParseDuration(fmt.Sprint(interval))
+		if error != nil {
+			return Options{}, fmt.Errorf("ticker_poll.%s: %w", pair, error)
+		}
+		if opts.TickerPolling == nil {
+			opts.TickerPolling = make(map[string]time.Duration)
+		}
+		opts.TickerPolling[strings.ToUpper(pair)] = duration
+	}
+	risk, error := loadRiskLimits(config)
+	if error != nil {
+		return Options{}, error
+	}
+	opts.Risk = risk
+	if filename := config.GetString("log.filename"); filename != "" {
+		file_handle, error := os.Create(filepath.Join(config.GetString("log.path"), filename))
+		if error != nil {
+			return Options{}, fmt.Errorf("creating log file: %w", error)
+		}
+		opts.Logger = logger.Init("", false, false, file_handle)
+	}
+	return opts, nil
+}
+
+// Reads the [risk] section, per pair limits are tables like
+// [risk.max_notional] with keys such as "BTC:USD".
+func loadRiskLimits(config *viper.Viper) (RiskLimits, error) {
+	limits := RiskLimits{
+		MaxOrders:    config.GetInt("risk.max_orders"),
+		RateInterval: config.GetDuration("risk.rate_interval"),
+		KillSwitch:   config.GetBool("risk.kill_switch"),
+	}
+	if collar := config.GetString("risk.price_collar"); collar != "" {
+		value, error := ParseDecimal(collar)
+		if error != nil {
+			return limits, fmt.Errorf("risk.price_collar: %w", error)
+		}
+		limits.PriceCollar = value
+	}
+	var error error
+	if limits.MaxNotional, error = loadPairLimits(config, "risk.max_notional"); error != nil {
+		return limits, error
+	}
+	limits.MaxPosition, error = loadPairLimits(config, "risk.max_position")
+	return limits, error
+}
+
+func loadPairLimits(config *viper.Viper, key string) (map[string]Decimal, error) {
+	table := config.GetStringMap(key)
+	if len(table) == 0 {
+		return nil, nil
+	}
+	limits := make(map[string]Decimal, len(table))
+	for pair, value := range table {
+		limit, error := ParseDecimal(fmt.Sprint(value))
+		if error != nil {
+			return nil, fmt.Errorf("%s.%s: %w", key, pair, error)
+		}
+		// Viper lower cases keys
+		limits[strings.ToUpper(pair)] = limit
+	}
+	return limits, nil
+}
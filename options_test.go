@@ -0,0 +1,154 @@
+package cexio
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadOptions(t *testing.T) {
+	directory := t.TempDir()
+	path := filepath.Join(directory, "config.toml")
+	config := `
+[websocket]
+endpoint = "wss://example.com/ws"
+send_rate = 5
+coalesce_tickers = true
+
+[auth]
+key    = "file_key"
+secret = "file_secret"
+
+[log]
+path = "` + directory + `"
+filename = "test.log"
+
+[udp]
+enabled = true
+publish_ip = "127.0.0.1"
+publish_port = 10551
+
+[multicast]
+enabled = true
+group = "239.255.0.1:10560"
+retransmit_address = ":10561"
+`
+	if error := ioutil.WriteFile(path, []byte(config), 0644); error != nil {
+		t.Fatal(error)
+	}
+	os.Setenv("CEXIO_AUTH_KEY", "env_key")
+	defer os.Unsetenv("CEXIO_AUTH_KEY")
+
+	opts, error := LoadOptions(path)
+	if error != nil {
+		t.Fatal(error)
+	}
+	if opts.Endpoint != "wss://example.com/ws" {
+		t.Fatalf("Unexpected endpoint %q", opts.Endpoint)
+	}
+	if opts.Key != "env_key" || opts.Secret != "file_secret" {
+		t.Fatalf("Unexpected credentials %q %q", opts.Key, opts.Secret)
+	}
+	if opts.SendRate != 5 || !opts.CoalesceTickers {
+		t.Fatalf("Unexpected send options %+v", opts)
+	}
+	if opts.PublishAddress != "127.0.0.1:10551" {
+		t.Fatalf("Unexpected publish address %q", opts.PublishAddress)
+	}
+	if opts.Multicast.Group != "239.255.0.1:10560" || opts.Multicast.RetransmitAddress != ":10561" {
+		t.Fatalf("Unexpected multicast options %+v", opts.Multicast)
+	}
+	if _, error := NewContext(Options{Multicast: MulticastOptions{Group: "239.255.0.1:10560", TTL: 256}}); !errors.Is(error, ErrInvalidOptions) {
+		t.Fatalf("Expected invalid ttl, got %v", error)
+	}
+	if opts.Logger == nil {
+		t.Fatal("Log file not opened")
+	}
+	opts.Logger.Close()
+	if _, error := os.Stat(filepath.Join(directory, "test.log")); error != nil {
+		t.Fatal(error)
+	}
+
+	if _, error := LoadOptions(filepath.Join(directory, "missing.toml")); error == nil {
+		t.Fatal("Expected error for missing config file")
+	}
+}
+
+func TestLoadOptionsFromEnvironment(t *testing.T) {
+	os.Setenv("CEXIO_WEBSOCKET_ENDPOINT", "ws://localhost:1234")
+	defer os.Unsetenv("CEXIO_WEBSOCKET_ENDPOINT")
+	opts, error := LoadOptions("")
+	if error != nil {
+		t.Fatal(error)
+	}
+	if opts.Endpoint != "ws://localhost:1234" || opts.PublishAddress != "" || opts.Logger != nil {
+		t.Fatalf("Unexpected options %+v", opts)
+	}
+}
+
+func TestLoadRiskLimits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	config := `
+[risk]
+kill_switch = true
+max_orders = 10
+rate_interval = "2s"
+price_collar = 0.05
+
+[risk.max_notional]
+"BTC:USD" = 10000
+
+[risk.max_position]
+"BTC:USD" = 5
+"ETH:USD" = 0.5
+`
+	if error := ioutil.WriteFile(path, []byte(config), 0644); error != nil {
+		t.Fatal(error)
+	}
+	opts, error := LoadOptions(path)
+	if error != nil {
+		t.Fatal(error)
+	}
+	risk := opts.Risk
+	if !risk.KillSwitch || risk.MaxOrders != 10 || risk.RateInterval != 2*time.Second || risk.PriceCollar != d("0.05") {
+		t.Fatalf("Unexpected limits %+v", risk)
+	}
+	if risk.MaxNotional["BTC:USD"] != d("10000") || risk.MaxPosition["ETH:USD"] != d("0.5") || len(risk.MaxPosition) != 2 {
+		t.Fatalf("Unexpected pair limits %+v", risk)
+	}
+}
+
+func TestInvalidOptions(t *testing.T) {
+	for _, opts := range []Options{
+		{Endpoint: "http://ws.cex.io/ws"},
+		{Endpoint: "::"},
+		{RestEndpoint: "ws://cex.io/api"},
+		{SendQueueSize: -1},
+		{PublishAddress: "localhost"},
+		{Risk: RiskLimits{MaxNotional: map[string]Decimal{"BTC:USD": 0}}},
+		{Risk: RiskLimits{PriceCollar: d("-0.1")}},
+	} {
+		if _, error := NewContext(opts); !errors.Is(error, ErrInvalidOptions) {
+			t.Fatalf("Expected ErrInvalidOptions for %+v, got %v", opts, error)
+		}
+	}
+
+	opts, error := Options{}.withDefaults()
+	if error != nil {
+		t.Fatal(error)
+	}
+	if opts.Endpoint != kDefaultEndpoint || opts.RestEndpoint != kDefaultRestEndpoint || opts.SendQueueSize != kDefaultQueueSize ||
+		opts.Dialer == nil || opts.HTTPClient == nil {
+		t.Fatalf("Defaults not applied %+v", opts)
+	}
+}
+
+func TestGenerateSignature(t *testing.T) {
+	// HMAC-SHA256 of "1448034533key" with "secret"
+	if signature := GenerateSignature("key", "secret", 1448034533); signature != "910754a47ca37020c14e7d06d06c12c7843d90203c1818c798f704a5941ba35e" {
+		t.Fatalf("Unexpected signature %q", signature)
+	}
+}
@@ -0,0 +1,194 @@
+package cexio
+
+import (
+	"github.com/google/flatbuffers/go"
+	buffer "github.com/sahmad98/cex.io/types"
+	"sort"
+)
+
+type Level struct {
+	Price Decimal
+	Qty   Decimal
+}
+
+const (
+	kPrintDepth = 5
+	kBuy        = 0
+	kSell       = 1
+	kNumSide    = 2
+)
+
+type Side int
+
+// Price levels of one side of the book, kept sorted best price first.
+// Depth limits the number of levels kept, 0 keeps every level.
+type Levels struct {
+	Data       []Level
+	Depth      int
+	Descending bool
+}
+
+func (levels *Levels) Len() int { return len(levels.Data) }
+
+// Returns the index of price, or the index it would be inserted at.
+func (levels *Levels) search(price Decimal) (int, bool) {
+	data := levels.Data
+	i := 0
+	if levels.Descending {
+		i = sort.Search(len(data), func(i int) bool { return data[i].Price <= price })
+	} else {
+		i = sort.Search(len(data), func(i int) bool { return data[i].Price >= price })
+	}
+	return i, i < len(data) && data[i].Price == price
+}
+
+// Get returns the level at price.
+func (levels *Levels) Get(price Decimal) (Level, bool) {
+	i, found := levels.search(price)
+	if !found {
+		return Level{}, false
+	}
+	return levels.Data[i], true
+}
+
+// Best returns the best priced level.
+func (levels *Levels) Best() (Level, bool) {
+	if len(levels.Data) == 0 {
+		return Level{}, false
+	}
+	return levels.Data[0], true
+}
+
+// Set updates the level at price or inserts a new one. A new level
+// beyond Depth is ignored, a level pushed beyond Depth is dropped.
+func (levels *Levels) Set(price, qty Decimal) {
+	i, found := levels.search(price)
+	if found {
+		levels.Data[i].Qty = qty
+		return
+	}
+	if levels.Depth > 0 && i >= levels.Depth {
+		return
+	}
+	levels.Data = append(levels.Data, Level{})
+	copy(levels.Data[i+1:], levels.Data[i:])
+	levels.Data[i] = Level{price, qty}
+	if levels.Depth > 0 && len(levels.Data) > levels.Depth {
+		levels.Data = levels.Data[:levels.Depth]
+	}
+}
+
+// Remove deletes the level at price, returns false if there is none.
+func (levels *Levels) Remove(price Decimal) bool {
+	i, found := levels.search(price)
+	if !found {
+		return false
+	}
+	levels.Data = append(levels.Data[:i], levels.Data[i+1:]...)
+	return true
+}
+
+func (levels *Levels) clone() Levels {
+	clone := *levels
+	clone.Data = append([]Level(nil), levels.Data...)
+	return clone
+}
+
+type Orderbook struct {
+	Id        int32
+	Pair      string
+	Bids      Levels
+	Asks      Levels
+	Low       Decimal
+	High      Decimal
+	LastPrice Decimal
+	Volume    Decimal
+	Bid       Decimal
+	Ask       Decimal
+	Stale     bool
+}
+
+// Copy returns a deep copy of the orderbook which does not share
+// levels with the original.
+func (orderbook *Orderbook) Copy() Orderbook {
+	snapshot := *orderbook
+	snapshot.Bids = orderbook.Bids.clone()
+	snapshot.Asks = orderbook.Asks.clone()
+	return snapshot
+}
+
+func (ob *Orderbook) getBuffer() []byte {
+	builder := flatbuffers.NewBuilder(1024)
+
+	// buffer.LevelsStart(builder)
+	buffer.LevelsStartDataVector(builder, len(ob.Bids.Data))
+	for i := len(ob.Bids.Data) - 1; i >= 0; i-- {
+		buffer.CreateLevel(builder, int64(ob.Bids.Data[i].Price), int64(ob.Bids.Data[i].Qty))
+	}
+	bids_vector := builder.EndVector(len(ob.Bids.Data))
+	buffer.LevelsStart(builder)
+	buffer.LevelsAddData(builder, bids_vector)
+	bids := buffer.LevelsEnd(builder)
+
+	buffer.LevelsStartDataVector(builder, len(ob.Asks.Data))
+	for i := len(ob.Asks.Data) - 1; i >= 0; i-- {
+		buffer.CreateLevel(builder, int64(ob.Asks.Data[i].Price), int64(ob.Asks.Data[i].Qty))
+	}
+	asks_vector := builder.EndVector(len(ob.Asks.Data))
+	buffer.LevelsStart(builder)
+	buffer.LevelsAddData(builder, asks_vector)
+	asks := buffer.LevelsEnd(builder)
+	pair := builder.CreateString(ob.Pair)
+
+	buffer.OrderbookStart(builder)
+	buffer.OrderbookAddId(builder, ob.Id)
+	buffer.OrderbookAddPair(builder, pair)
+	buffer.OrderbookAddBids(builder, bids)
+	buffer.OrderbookAddAsks(builder, asks)
+	buffer.OrderbookAddLow(builder, int64(ob.Low))
+	buffer.OrderbookAddHigh(builder, int64(ob.High))
+	buffer.OrderbookAddLastPrice(builder, int64(ob.LastPrice))
+	buffer.OrderbookAddVolume(builder, int64(ob.Volume))
+	buffer.OrderbookAddBid(builder, int64(ob.Bid))
+	buffer.OrderbookAddAsk(builder, int64(ob.Ask))
+
+	orderbook := buffer.OrderbookEnd(builder)
+	builder.Finish(orderbook)
+	buf := builder.FinishedBytes()
+	return buf
+}
+
+func (orderbook *Orderbook) levels(side Side) *Levels {
+	if side == kBuy {
+		return &orderbook.Bids
+	}
+	return &orderbook.Asks
+}
+
+// Clears the book and keeps at most depth levels per side.
+func (orderbook *Orderbook) initalize(depth int) {
+	orderbook.Bids = Levels{Depth: depth, Descending: true}
+	orderbook.Asks = Levels{Depth: depth}
+}
+
+func (orderbook *Orderbook) allLevelUpdate(levels [][]Decimal, side Side) {
+	for _, data := range levels {
+		if len(data) < 2 {
+			continue
+		}
+		orderbook.updateLevel(data[0], data[1], side)
+	}
+}
+
+func (orderbook *Orderbook) removeLevel(price Decimal, side Side) {
+	orderbook.levels(side).Remove(price)
+}
+
+// Sets the quantity at price, a zero quantity removes the level.
+func (orderbook *Orderbook) updateLevel(price, qty Decimal, side Side) {
+	if qty == 0 {
+		orderbook.removeLevel(price, side)
+	} else {
+		orderbook.levels(side).Set(price, qty)
+	}
+}
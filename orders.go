@@ -0,0 +1,631 @@
+package cexio
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type OrderState string
+
+const (
+	OrderPendingNew      OrderState = "pending-new"
+	OrderOpen            OrderState = "open"
+	OrderPartiallyFilled OrderState = "partially-filled"
+	OrderFilled          OrderState = "filled"
+	OrderCancelled       OrderState = "cancelled"
+	OrderRejected        OrderState = "rejected"
+)
+
+const (
+	kSourceAck       = "ack"
+	kSourceOrder     = "order"
+	kSourceTx        = "tx"
+	kSourceReconcile = "reconcile"
+)
+
+var ErrInconsistentTransition = errors.New("cexio: inconsistent order transition")
+
+// Max number of orders whose transactions are held until they become
+// known, see OrderTracker.early.
+const kMaxEarlyOrders = 1024
+
+// States each state may move to, terminal states have none.
+var orderTransitions = map[OrderState][]OrderState{
+	OrderPendingNew:      {OrderOpen, OrderPartiallyFilled, OrderFilled, OrderCancelled, OrderRejected},
+	OrderOpen:            {OrderPartiallyFilled, OrderFilled, OrderCancelled},
+	OrderPartiallyFilled: {OrderFilled, OrderCancelled},
+}
+
+// Terminal reports whether the order can no longer change.
+func (state OrderState) Terminal() bool {
+	return state == OrderFilled || state == OrderCancelled || state == OrderRejected
+}
+
+func (state OrderState) allows(next OrderState) bool {
+	if state == "" || state == next {
+		return true
+	}
+	for _, allowed := range orderTransitions[state] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// Order as known by the OrderTracker. Id is empty while pending-new.
+type TrackedOrder struct {
+	Id      string
+	Symbol1 string
+	Symbol2 string
+	Type    OrderType
+	Price   Decimal
+	Amount  Decimal
+	Remains Decimal
+	State   OrderState
+	Created time.Time
+	Updated time.Time
+}
+
+// Change of a tracked order. Source tells what caused it: ack, order,
+// tx or reconcile. Transaction is only set for tx pushes, which fill
+// the order by their amount in Symbol1 unless an order push or ack
+// reported the fill already.
+type OrderEvent struct {
+	Order       TrackedOrder
+	Previous    OrderState
+	Source      string
+	Transaction *Transaction
+	// ErrInconsistentTransition if the change contradicts the known
+	// state, e.g. a fill after a cancel. Order keeps the known state.
+	Error error
+	Time  time.Time
+}
+
+type trackedOrder struct {
+	key   string
+	order TrackedOrder
+	// Known to the server by its id, from an ack or Reconcile
+	placed bool
+	// Amount filled according to the tx pushes seen, by transaction id
+	filled       Decimal
+	transactions map[string]bool
+	history      []OrderEvent
+}
+
+// OrderTracker follows the lifecycle of orders placed through it, and
+// of any other order the server pushes, from request acks and order
+// and tx pushes. Pushes are only sent on authenticated contexts.
+type OrderTracker struct {
+	Context *Context
+
+	lock     sync.Mutex
+	orders   map[string]*trackedOrder
+	sequence []*trackedOrder
+	// Placed orders without ack, which may or may not have reached the
+	// server, resolved by Reconcile
+	unacknowledged []*trackedOrder
+	// Transactions of orders not known yet, e.g. pushed before the ack
+	early        map[string][]*Transaction
+	pendingId    int
+	handlers     []func(OrderEvent)
+	inconsistent uint64
+}
+
+type orderPush struct {
+	Id     string    `json:"id"`
+	Type   OrderType `json:"type"`
+	Amount Decimal   `json:"amount"`
+	Price  Decimal   `json:"price"`
+	// Remains in the smallest unit of the currency, only usable when
+	// zero, FormattedRemains is set by most pushes instead
+	Remains          Decimal  `json:"remains"`
+	FormattedRemains *Decimal `json:"fremains"`
+	Cancel           bool     `json:"cancel"`
+	Pair             struct {
+		Symbol1 string `json:"symbol1"`
+		Symbol2 string `json:"symbol2"`
+	} `json:"pair"`
+}
+
+// NewOrderTracker starts tracking orders on context. Orders still open
+// are reconciled with the server after every reconnect.
+func NewOrderTracker(context *Context) *OrderTracker {
+	tracker := &OrderTracker{Context: context, orders: make(map[string]*trackedOrder), early: make(map[string][]*Transaction)}
+	context.onPush("order", tracker.handleOrderPush)
+	context.onPush("tx", tracker.handleTransactionPush)
+	context.OnReconnect(func() {
+		// Hooks run on the supervisor, which must not wait for responses
+		context.spawn(func(context *Context) {
+			if error := tracker.Reconcile(); error != nil {
+				context.log().Errorf("Unable to reconcile orders: %s", error)
+			}
+		})
+	})
+	return tracker
+}
+
+// OnOrder registers handler for every change of a tracked order.
+// Handlers of pushes run on the reader goroutine and must not block.
+func (tracker *OrderTracker) OnOrder(handler func(OrderEvent)) {
+	tracker.lock.Lock()
+	tracker.handlers = append(tracker.handlers, handler)
+	tracker.lock.Unlock()
+}
+
+// Orders returns every tracked order in the order it became known.
+func (tracker *OrderTracker) Orders() []TrackedOrder {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+	orders := make([]TrackedOrder, 0, len(tracker.sequence))
+	for _, record := range tracker.sequence {
+		orders = append(orders, record.order)
+	}
+	return orders
+}
+
+func (tracker *OrderTracker) Order(order_id string) (TrackedOrder, bool) {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+	record, ok := tracker.orders[order_id]
+	if !ok {
+		return TrackedOrder{}, false
+	}
+	return record.order, true
+}
+
+// History returns the events of order_id, oldest first.
+func (tracker *OrderTracker) History(order_id string) []OrderEvent {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+	record, ok := tracker.orders[order_id]
+	if !ok {
+		return nil
+	}
+	return append([]OrderEvent{}, record.history...)
+}
+
+// Inconsistent returns the number of inconsistent transitions seen.
+func (tracker *OrderTracker) Inconsistent() uint64 {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+	return tracker.inconsistent
+}
+
+// PlaceOrder places an order like Context.PlaceOrder and tracks it,
+// from pending-new until the ack opens, fills or rejects it. An order
+// without ack, e.g. after a timeout, stays pending-new until Reconcile
+// finds it among the open or archived orders of its pair, or rejects
+// it if the server has none.
+func (tracker *OrderTracker) PlaceOrder(sym1, sym2 string, order_type OrderType, amount, price Decimal) (*PlacedOrder, error) {
+	// Tracked as sent, so that Reconcile can match it. Invalid orders
+	// fail the same way below.
+	if normalized_amount, normalized_price, error := tracker.Context.normalizeOrder(sym1, sym2, amount, price); error == nil {
+		amount, price = normalized_amount, normalized_price
+	}
+	tracker.lock.Lock()
+	tracker.pendingId++
+	record := tracker.add("@pending-"+strconv.Itoa(tracker.pendingId), TrackedOrder{})
+	events := tracker.apply(record, TrackedOrder{
+		Symbol1: sym1,
+		Symbol2: sym2,
+		Type:    order_type,
+		Price:   price,
+		Amount:  amount,
+		Remains: amount,
+		State:   OrderPendingNew,
+	}, kSourceAck, nil)
+	tracker.lock.Unlock()
+	tracker.emit(events...)
+
+	placed, error := tracker.Context.PlaceOrder(sym1, sym2, order_type, amount, price)
+	tracker.acknowledge(record, placed, error)
+	return placed, error
+}
+
+// CancelOrder cancels order_id like Context.CancelOrder, the order is
+// cancelled once the server has confirmed it.
+func (tracker *OrderTracker) CancelOrder(order_id string) (*CancelledOrder, error) {
+	cancelled, error := tracker.Context.CancelOrder(order_id)
+	if error != nil {
+		return nil, error
+	}
+	tracker.lock.Lock()
+	events := []OrderEvent{}
+	if record, ok := tracker.orders[order_id]; ok {
+		next := record.order
+		next.Remains = cancelled.Remains
+		next.State = OrderCancelled
+		events = append(events, tracker.apply(record, next, kSourceAck, nil)...)
+	}
+	tracker.lock.Unlock()
+	tracker.emit(events...)
+	return cancelled, nil
+}
+
+// Reconcile queries the open orders of every pair with live tracked
+// orders, and the details of those no longer open, to catch up with
+// changes missed while disconnected. Orders placed without ack are
+// matched by type, price and amount to the open and archived orders
+// of their pair, and rejected if the server has none.
+func (tracker *OrderTracker) Reconcile() error {
+	tracker.lock.Lock()
+	pairs := make(map[[2]string][]string)
+	for _, record := range tracker.sequence {
+		if record.order.Id != "" && !record.order.State.Terminal() {
+			pair := [2]string{record.order.Symbol1, record.order.Symbol2}
+			pairs[pair] = append(pairs[pair], record.order.Id)
+		}
+	}
+	for _, record := range tracker.unacknowledged {
+		pair := [2]string{record.order.Symbol1, record.order.Symbol2}
+		pairs[pair] = pairs[pair]
+	}
+	tracker.lock.Unlock()
+
+	for pair, live := range pairs {
+		open, error := tracker.Context.OpenOrders(pair[0], pair[1])
+		if error != nil {
+			return error
+		}
+		found := make(map[string]bool, len(open))
+		for _, order := range open {
+			found[order.Id] = true
+			tracker.resolve(pair, order.Id, order.Type, order.Price, order.Amount)
+			tracker.update(TrackedOrder{
+				Id:      order.Id,
+				Symbol1: pair[0],
+				Symbol2: pair[1],
+				Type:    order.Type,
+				Price:   order.Price,
+				Amount:  order.Amount,
+				Remains: order.Pending,
+				State:   orderState(order.Amount, order.Pending),
+			}, kSourceReconcile)
+		}
+		for _, order_id := range live {
+			if found[order_id] {
+				continue
+			}
+			order, error := tracker.Context.GetOrder(order_id)
+			if error != nil {
+				return error
+			}
+			tracker.update(TrackedOrder{
+				Id:      order.Id,
+				Symbol1: order.Symbol1,
+				Symbol2: order.Symbol2,
+				Type:    order.Type,
+				Price:   order.Price,
+				Amount:  order.Amount,
+				Remains: order.Remains,
+				State:   statusState(order.Status, order.Amount, order.Remains),
+			}, kSourceReconcile)
+		}
+		if error := tracker.resolveArchived(pair); error != nil {
+			return error
+		}
+	}
+	return nil
+}
+
+// Matches the orders of pair without ack still left to the archived
+// orders placed since, and rejects those the server does not have.
+func (tracker *OrderTracker) resolveArchived(pair [2]string) error {
+	tracker.lock.Lock()
+	from := time.Time{}
+	for _, record := range tracker.unacknowledged {
+		if record.order.Symbol1 == pair[0] && record.order.Symbol2 == pair[1] && (from.IsZero() || record.order.Created.Before(from)) {
+			from = record.order.Created
+		}
+	}
+	tracker.lock.Unlock()
+	if from.IsZero() {
+		return nil
+	}
+	// Allows for the clock of the server being behind
+	archived, error := tracker.Context.ArchivedOrders(pair[0], pair[1], 0, from.Add(-time.Minute), time.Time{})
+	if error != nil {
+		return error
+	}
+	for _, order := range archived {
+		if !tracker.resolve(pair, order.Id, order.Type, order.Price, order.Amount) {
+			continue
+		}
+		tracker.update(TrackedOrder{
+			Id:      order.Id,
+			Symbol1: pair[0],
+			Symbol2: pair[1],
+			Type:    order.Type,
+			Price:   order.Price,
+			Amount:  order.Amount,
+			Remains: order.Remains,
+			State:   statusState(order.Status, order.Amount, order.Remains),
+		}, kSourceReconcile)
+	}
+
+	tracker.lock.Lock()
+	events := []OrderEvent{}
+	unacknowledged := tracker.unacknowledged[:0]
+	for _, record := range tracker.unacknowledged {
+		if record.order.Symbol1 != pair[0] || record.order.Symbol2 != pair[1] {
+			unacknowledged = append(unacknowledged, record)
+			continue
+		}
+		next := record.order
+		next.State = OrderRejected
+		events = append(events, tracker.apply(record, next, kSourceReconcile, nil)...)
+	}
+	tracker.unacknowledged = unacknowledged
+	tracker.lock.Unlock()
+	tracker.emit(events...)
+	return nil
+}
+
+// Gives the id of an order of pair found on the server to the order
+// without ack it matches, if order_id is not known to be placed
+// otherwise. Returns whether one matched.
+func (tracker *OrderTracker) resolve(pair [2]string, order_id string, order_type OrderType, price, amount Decimal) bool {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+	if record, ok := tracker.orders[order_id]; ok && record.placed {
+		return false
+	}
+	for i, record := range tracker.unacknowledged {
+		order := record.order
+		if order.Symbol1 == pair[0] && order.Symbol2 == pair[1] && order.Type == order_type && order.Price == price && order.Amount == amount {
+			tracker.unacknowledged = append(tracker.unacknowledged[:i], tracker.unacknowledged[i+1:]...)
+			tracker.identify(record, order_id)
+			return true
+		}
+	}
+	return false
+}
+
+func orderState(amount, remains Decimal) OrderState {
+	if remains <= 0 {
+		return OrderFilled
+	}
+	if remains < amount {
+		return OrderPartiallyFilled
+	}
+	return OrderOpen
+}
+
+// State of an order of get-order or archived-orders by its status.
+func statusState(status string, amount, remains Decimal) OrderState {
+	switch status {
+	case "d":
+		return OrderFilled
+	case "c", "cd":
+		return OrderCancelled
+	}
+	return orderState(amount, remains)
+}
+
+// Applies the ack of a placed order. A push of the order may already
+// have arrived, then the pending record is merged into it. Orders
+// failing before they were sent are rejected, those failing after,
+// e.g. on a timeout, are left to Reconcile.
+func (tracker *OrderTracker) acknowledge(record *trackedOrder, placed *PlacedOrder, error error) {
+	tracker.lock.Lock()
+	events := []OrderEvent{}
+	next := record.order
+	if _, ok := error.(*ResponseError); ok || errors.Is(error, ErrRiskRejected) || errors.Is(error, ErrInvalidOrder) || errors.Is(error, ErrUnknownPair) {
+		next.State = OrderRejected
+		events = append(events, tracker.apply(record, next, kSourceAck, nil)...)
+	} else if error != nil {
+		tracker.unacknowledged = append(tracker.unacknowledged, record)
+	} else {
+		next.Id = placed.Id
+		next.Remains = placed.Pending
+		if placed.Complete {
+			next.Remains = 0
+		}
+		next.State = orderState(next.Amount, next.Remains)
+		_, pushed := tracker.orders[placed.Id]
+		record = tracker.identify(record, placed.Id)
+		if !pushed || next.Remains < record.order.Remains {
+			events = append(events, tracker.apply(record, next, kSourceAck, nil)...)
+		}
+		events = append(events, tracker.fillEarly(record)...)
+	}
+	tracker.lock.Unlock()
+	tracker.emit(events...)
+}
+
+// Keys record by order_id, merging it into the record of pushes of
+// the order that arrived first. Returns the record kept. Requires lock
+// to be held.
+func (tracker *OrderTracker) identify(record *trackedOrder, order_id string) *trackedOrder {
+	delete(tracker.orders, record.key)
+	if existing, ok := tracker.orders[order_id]; ok {
+		tracker.remove(record)
+		existing.history = append(record.history, existing.history...)
+		existing.placed = true
+		return existing
+	}
+	record.key = order_id
+	record.placed = true
+	tracker.orders[order_id] = record
+	return record
+}
+
+// Applies order, tracking it if unknown.
+func (tracker *OrderTracker) update(order TrackedOrder, source string) {
+	tracker.lock.Lock()
+	events := tracker.updateLocked(order, source)
+	tracker.lock.Unlock()
+	tracker.emit(events...)
+}
+
+// Requires lock to be held.
+func (tracker *OrderTracker) updateLocked(order TrackedOrder, source string) []OrderEvent {
+	record, ok := tracker.orders[order.Id]
+	if !ok {
+		record = tracker.add(order.Id, TrackedOrder{})
+	}
+	next := record.order
+	next.Id, next.Symbol1, next.Symbol2, next.Type = order.Id, order.Symbol1, order.Symbol2, order.Type
+	if order.Price != 0 {
+		next.Price = order.Price
+	}
+	if order.Amount != 0 {
+		next.Amount = order.Amount
+	}
+	next.Remains = order.Remains
+	next.State = order.State
+	events := tracker.apply(record, next, source, nil)
+	return append(events, tracker.fillEarly(record)...)
+}
+
+// Requires lock to be held.
+func (tracker *OrderTracker) add(key string, order TrackedOrder) *trackedOrder {
+	record := &trackedOrder{key: key, order: order}
+	tracker.orders[key] = record
+	tracker.sequence = append(tracker.sequence, record)
+	return record
+}
+
+// Requires lock to be held.
+func (tracker *OrderTracker) remove(record *trackedOrder) {
+	for i, other := range tracker.sequence {
+		if other == record {
+			tracker.sequence = append(tracker.sequence[:i], tracker.sequence[i+1:]...)
+			return
+		}
+	}
+}
+
+// Moves record to next and returns the resulting event, none if
+// nothing changed. Requires lock to be held.
+func (tracker *OrderTracker) apply(record *trackedOrder, next TrackedOrder, source string, transaction *Transaction) []OrderEvent {
+	now := time.Now()
+	previous := record.order
+	event := OrderEvent{Previous: previous.State, Source: source, Transaction: transaction, Time: now}
+	if !previous.State.allows(next.State) {
+		tracker.inconsistent++
+		event.Error = ErrInconsistentTransition
+	} else {
+		next.Created, next.Updated = previous.Created, previous.Updated
+		if next == previous && transaction == nil {
+			return nil
+		}
+		if next != previous {
+			if next.Created.IsZero() {
+				next.Created = now
+			}
+			next.Updated = now
+			record.order = next
+		}
+	}
+	event.Order = record.order
+	record.history = append(record.history, event)
+	return []OrderEvent{event}
+}
+
+func (tracker *OrderTracker) emit(events ...OrderEvent) {
+	if len(events) == 0 {
+		return
+	}
+	tracker.lock.Lock()
+	handlers := tracker.handlers
+	tracker.lock.Unlock()
+	for _, event := range events {
+		for _, handler := range handlers {
+			handler(event)
+		}
+	}
+}
+
+func (tracker *OrderTracker) handleOrderPush(push *Response) {
+	data := orderPush{}
+	if error := json.Unmarshal(push.Data, &data); error != nil {
+		tracker.Context.log().Errorf("Unable to parse order: %s", error)
+		return
+	}
+	// The known order is changed and applied at once, so that an ack
+	// applied meanwhile is not overwritten
+	tracker.lock.Lock()
+	order := TrackedOrder{Amount: data.Amount, Remains: data.Amount}
+	if record, ok := tracker.orders[data.Id]; ok {
+		order = record.order
+	}
+	order.Id = data.Id
+	if data.Pair.Symbol1 != "" {
+		order.Symbol1, order.Symbol2 = data.Pair.Symbol1, data.Pair.Symbol2
+	}
+	if data.Type != "" {
+		order.Type = data.Type
+	}
+	if data.Amount != 0 {
+		order.Price, order.Amount = data.Price, data.Amount
+	}
+	if data.FormattedRemains != nil {
+		order.Remains = *data.FormattedRemains
+	} else if data.Remains == 0 {
+		order.Remains = 0
+	}
+	order.State = orderState(order.Amount, order.Remains)
+	if data.Cancel {
+		order.State = OrderCancelled
+	}
+	events := tracker.updateLocked(order, kSourceOrder)
+	tracker.lock.Unlock()
+	tracker.emit(events...)
+}
+
+// Records transactions of tracked orders in their history, those of
+// orders not known yet are held until they are.
+func (tracker *OrderTracker) handleTransactionPush(push *Response) {
+	transaction := &Transaction{}
+	if error := json.Unmarshal(push.Data, transaction); error != nil || transaction.Order == "" {
+		return
+	}
+	order_id := transaction.Order.String()
+	tracker.lock.Lock()
+	events := []OrderEvent{}
+	if record, ok := tracker.orders[order_id]; ok {
+		events = tracker.fill(record, transaction)
+	} else if _, ok := tracker.early[order_id]; ok || len(tracker.early) < kMaxEarlyOrders {
+		tracker.early[order_id] = append(tracker.early[order_id], transaction)
+	}
+	tracker.lock.Unlock()
+	tracker.emit(events...)
+}
+
+// Applies the transactions held for record. Requires lock to be held.
+func (tracker *OrderTracker) fillEarly(record *trackedOrder) []OrderEvent {
+	transactions := tracker.early[record.order.Id]
+	delete(tracker.early, record.order.Id)
+	events := []OrderEvent{}
+	for _, transaction := range transactions {
+		events = append(events, tracker.fill(record, transaction)...)
+	}
+	return events
+}
+
+// Transactions in Symbol1 fill the order, remains only ever decrease so
+// that fills reported by order pushes as well are not counted twice.
+// Requires lock to be held.
+func (tracker *OrderTracker) fill(record *trackedOrder, transaction *Transaction) []OrderEvent {
+	next := record.order
+	if transaction.Symbol == next.Symbol1 && !record.transactions[transaction.Id] && !next.State.Terminal() {
+		if record.transactions == nil {
+			record.transactions = make(map[string]bool)
+		}
+		record.transactions[transaction.Id] = true
+		record.filled += transaction.Amount.Abs()
+		if remains := next.Amount - record.filled; remains < next.Remains {
+			if remains < 0 {
+				remains = 0
+			}
+			next.Remains = remains
+			next.State = orderState(next.Amount, next.Remains)
+		}
+	}
+	return tracker.apply(record, next, kSourceTx, transaction)
+}
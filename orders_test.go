@@ -0,0 +1,247 @@
+package cexio
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/sahmad98/cex.io/cexiotest"
+	"testing"
+	"time"
+)
+
+func TestOrderLifecycle(t *testing.T) {
+	server := newHandledServer(t, func(request cexiotest.Request, conn *cexiotest.Conn) {
+		pair := map[string]string{"symbol1": "BTC", "symbol2": "USD"}
+		switch request.Type {
+		case "place-order":
+			conn.Reply(map[string]interface{}{"id": "42", "complete": false, "type": "buy",
+				"price": "250", "amount": "1.00000000", "pending": "1.00000000", "time": 1435927928885})
+			conn.Send(map[string]interface{}{"e": "order", "data": map[string]interface{}{"id": "42", "type": "buy",
+				"price": "250", "amount": "1.00000000", "remains": "60000000", "fremains": "0.60000000", "pair": pair}})
+			conn.Send(map[string]interface{}{"e": "tx", "data": map[string]interface{}{"id": "7", "order": "42",
+				"type": "buy", "symbol": "BTC", "amount": "0.40000000", "balance": "0.40000000", "time": "2016-05-04T13:37:00.000Z"}})
+		case "cancel-order":
+			conn.Reply(map[string]interface{}{"order_id": "42", "fremains": "0.60000000"})
+			conn.Send(map[string]interface{}{"e": "order", "data": map[string]interface{}{"id": "42",
+				"remains": "60000000", "fremains": "0.60000000", "cancel": true, "pair": pair}})
+			// Fill reported after the cancel
+			conn.Send(map[string]interface{}{"e": "order", "data": map[string]interface{}{"id": "42", "type": "buy",
+				"price": "250", "amount": "1.00000000", "remains": "0", "pair": pair}})
+		}
+	})
+	context := newTestContext(t, server)
+	tracker := NewOrderTracker(context)
+	events := make(chan OrderEvent, 16)
+	tracker.OnOrder(func(event OrderEvent) { events <- event })
+
+	next := func() OrderEvent {
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(2 * time.Second):
+			t.Fatal("Order event missing")
+		}
+		return OrderEvent{}
+	}
+
+	if _, error := tracker.PlaceOrder("BTC", "USD", OrderBuy, d("1"), d("250")); error != nil {
+		t.Fatal(error)
+	}
+	if event := next(); event.Order.State != OrderPendingNew || event.Order.Id != "" {
+		t.Fatalf("Unexpected event %+v", event)
+	}
+	// The ack and the pushes race, pushes may come first
+	for state := OrderOpen; state != OrderPartiallyFilled; {
+		event := next()
+		state = event.Order.State
+		if event.Error != nil || event.Order.Id != "42" {
+			t.Fatalf("Unexpected event %+v", event)
+		}
+	}
+	if event := next(); event.Source != "tx" || event.Transaction == nil || event.Order.Remains != d("0.6") {
+		t.Fatalf("Unexpected event %+v", event)
+	}
+
+	if _, error := tracker.CancelOrder("42"); error != nil {
+		t.Fatal(error)
+	}
+	if event := next(); event.Order.State != OrderCancelled || event.Previous != OrderPartiallyFilled {
+		t.Fatalf("Unexpected event %+v", event)
+	}
+	if event := next(); event.Error != ErrInconsistentTransition || event.Order.State != OrderCancelled {
+		t.Fatalf("Expected inconsistent fill, got %+v", event)
+	}
+
+	orders := tracker.Orders()
+	if len(orders) != 1 || orders[0].State != OrderCancelled || orders[0].Remains != d("0.6") || tracker.Inconsistent() != 1 {
+		t.Fatalf("Unexpected orders %+v", orders)
+	}
+	if history := tracker.History("42"); len(history) < 5 || history[0].Order.State != OrderPendingNew {
+		t.Fatalf("Unexpected history %+v", history)
+	}
+}
+
+func TestOrderRejected(t *testing.T) {
+	server := newHandledServer(t, func(request cexiotest.Request, conn *cexiotest.Conn) {
+		conn.Fail("Insufficient funds")
+	})
+	tracker := NewOrderTracker(newTestContext(t, server))
+
+	if _, error := tracker.PlaceOrder("BTC", "USD", OrderBuy, d("1"), d("250")); error == nil {
+		t.Fatal("Expected error")
+	}
+	orders := tracker.Orders()
+	if len(orders) != 1 || orders[0].State != OrderRejected {
+		t.Fatalf("Unexpected orders %+v", orders)
+	}
+}
+
+func TestOrderReconcile(t *testing.T) {
+	server := newHandledServer(t, func(request cexiotest.Request, conn *cexiotest.Conn) {
+		switch request.Type {
+		case "place-order":
+			order := orderRequest{}
+			json.Unmarshal(request.Data, &order)
+			id := "1"
+			if order.Type == OrderSell {
+				id = "2"
+			}
+			conn.Reply(map[string]interface{}{"id": id, "type": order.Type, "price": order.Price,
+				"amount": "1.00000000", "pending": "1.00000000"})
+		case "open-orders":
+			conn.Reply([]map[string]interface{}{
+				{"id": "1", "time": "1435927928885", "type": "buy", "price": "240", "amount": "1.00000000", "pending": "0.50000000"},
+				{"id": "3", "time": "1435927928885", "type": "buy", "price": "230", "amount": "2.00000000", "pending": "2.00000000"},
+			})
+		case "get-order":
+			conn.Reply(map[string]interface{}{"orderId": "2", "type": "sell", "symbol1": "BTC", "symbol2": "USD",
+				"amount": "1.00000000", "remains": "0.00000000", "price": "260", "time": 1450214742160, "status": "d"})
+		default:
+			conn.Fail("unexpected request")
+		}
+	})
+	tracker := NewOrderTracker(newTestContext(t, server))
+
+	tracker.PlaceOrder("BTC", "USD", OrderBuy, d("1"), d("240"))
+	tracker.PlaceOrder("BTC", "USD", OrderSell, d("1"), d("260"))
+	if error := tracker.Reconcile(); error != nil {
+		t.Fatal(error)
+	}
+	if server.Requests("open-orders") == 0 {
+		t.Fatal("Open orders not queried")
+	}
+	states := map[string]OrderState{}
+	for _, order := range tracker.Orders() {
+		states[order.Id] = order.State
+	}
+	if states["1"] != OrderPartiallyFilled || states["2"] != OrderFilled || states["3"] != OrderOpen {
+		t.Fatalf("Unexpected states %v", states)
+	}
+	if history := tracker.History("2"); history[len(history)-1].Source != "reconcile" {
+		t.Fatalf("Unexpected history %+v", history)
+	}
+}
+
+// Orders without ack are found among the open and archived orders, or
+// rejected if the server has none.
+func TestOrderReconcileUnacknowledged(t *testing.T) {
+	timeout := REQUEST_TIMEOUT
+	REQUEST_TIMEOUT = 50 * time.Millisecond
+	defer func() { REQUEST_TIMEOUT = timeout }()
+	server := newHandledServer(t, func(request cexiotest.Request, conn *cexiotest.Conn) {
+		switch request.Type {
+		case "open-orders":
+			conn.Reply([]map[string]interface{}{
+				{"id": "5", "time": "1435927928885", "type": "buy", "price": "240", "amount": "1.00000000", "pending": "1.00000000"},
+			})
+		case "archived-orders":
+			conn.Reply([]map[string]interface{}{
+				{"orderId": "6", "type": "sell", "symbol1": "BTC", "symbol2": "USD", "amount": "1.00000000",
+					"remains": "0.00000000", "price": "260", "time": "2016-05-04T13:37:00.000Z", "status": "d"},
+				// Placed elsewhere
+				{"orderId": "7", "type": "buy", "symbol1": "BTC", "symbol2": "USD", "amount": "3.00000000",
+					"remains": "0.00000000", "price": "100", "time": "2016-05-04T13:37:00.000Z", "status": "d"},
+			})
+		}
+		// Orders are never acknowledged
+	})
+	tracker := NewOrderTracker(newTestContext(t, server))
+
+	for _, order := range []struct {
+		order_type    OrderType
+		amount, price string
+	}{{OrderBuy, "1", "240"}, {OrderSell, "1", "260"}, {OrderBuy, "2", "100"}} {
+		if _, error := tracker.PlaceOrder("BTC", "USD", order.order_type, d(order.amount), d(order.price)); !errors.Is(error, ErrRequestTimeout) {
+			t.Fatalf("Expected a timeout, got %v", error)
+		}
+	}
+	if orders := tracker.Orders(); len(orders) != 3 || orders[0].State != OrderPendingNew || orders[0].Id != "" {
+		t.Fatalf("Unexpected orders %+v", orders)
+	}
+	if error := tracker.Reconcile(); error != nil {
+		t.Fatal(error)
+	}
+	orders := tracker.Orders()
+	if len(orders) != 3 {
+		t.Fatalf("Unexpected orders %+v", orders)
+	}
+	for i, expected := range []TrackedOrder{{Id: "5", State: OrderOpen}, {Id: "6", State: OrderFilled}, {State: OrderRejected}} {
+		if orders[i].Id != expected.Id || orders[i].State != expected.State {
+			t.Fatalf("Unexpected order %d %+v", i, orders[i])
+		}
+	}
+	if history := tracker.History("6"); len(history) != 2 || history[0].Order.State != OrderPendingNew || history[1].Source != "reconcile" {
+		t.Fatalf("Unexpected history %+v", history)
+	}
+	// Nothing is left to resolve
+	if error := tracker.Reconcile(); error != nil || len(tracker.Orders()) != 3 {
+		t.Fatalf("Unexpected second reconcile %v %+v", error, tracker.Orders())
+	}
+}
+
+func TestOrderFilledByTransactions(t *testing.T) {
+	server := newHandledServer(t, func(request cexiotest.Request, conn *cexiotest.Conn) {
+		if request.Type != "place-order" {
+			return
+		}
+		conn.Reply(map[string]interface{}{
+			"id": "42", "complete": false, "type": "buy", "price": "250", "amount": "1.00000000", "pending": "1.00000000"})
+		tx := func(id, symbol, amount string) {
+			conn.Send(map[string]interface{}{"e": "tx", "data": map[string]interface{}{"id": id, "order": "42",
+				"type": "buy", "symbol": symbol, "amount": amount, "time": "2016-05-04T13:37:00.000Z"}})
+		}
+		tx("7", "BTC", "0.40000000")
+		// The price paid, and the first fill again
+		tx("8", "USD", "-100.00")
+		tx("7", "BTC", "0.40000000")
+		tx("9", "BTC", "0.60000000")
+	})
+	tracker := NewOrderTracker(newTestContext(t, server))
+	events := make(chan OrderEvent, 16)
+	tracker.OnOrder(func(event OrderEvent) { events <- event })
+
+	if _, error := tracker.PlaceOrder("BTC", "USD", OrderBuy, d("1"), d("250")); error != nil {
+		t.Fatal(error)
+	}
+	expected := []struct {
+		source  string
+		state   OrderState
+		remains Decimal
+	}{
+		{"ack", OrderPendingNew, d("1")},
+		{"ack", OrderOpen, d("1")},
+		{"tx", OrderPartiallyFilled, d("0.6")},
+		{"tx", OrderPartiallyFilled, d("0.6")},
+		{"tx", OrderPartiallyFilled, d("0.6")},
+		{"tx", OrderFilled, 0},
+	}
+	for _, want := range expected {
+		select {
+		case event := <-events:
+			if event.Source != want.source || event.Order.State != want.state || event.Order.Remains != want.remains || event.Error != nil {
+				t.Fatalf("Expected %+v, got %+v", want, event)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("No event for %+v", want)
+		}
+	}
+}
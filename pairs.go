@@ -0,0 +1,268 @@
+package cexio
+
+import (
+	gocontext "context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var ErrUnknownPair = errors.New("cexio: unknown pair")
+var ErrInvalidOrder = errors.New("cexio: order outside of pair limits")
+
+// Pair of currencies, written like BTC:USD.
+type Pair struct {
+	Symbol1 string
+	Symbol2 string
+}
+
+// NewPair returns the pair of sym1 and sym2 in the upper case the
+// server uses.
+func NewPair(sym1, sym2 string) Pair {
+	return Pair{strings.ToUpper(sym1), strings.ToUpper(sym2)}
+}
+
+// ParsePair parses a pair written like BTC:USD.
+func ParsePair(pair string) (Pair, error) {
+	symbols := strings.Split(pair, ":")
+	if len(symbols) != 2 || symbols[0] == "" || symbols[1] == "" {
+		return Pair{}, fmt.Errorf("%w: %q", ErrUnknownPair, pair)
+	}
+	return NewPair(symbols[0], symbols[1]), nil
+}
+
+func (pair Pair) String() string {
+	return pair.Symbol1 + ":" + pair.Symbol2
+}
+
+// Limits and increments of a pair. Zero limits are not checked.
+type PairInfo struct {
+	Pair
+	// Amount limits in Symbol1
+	MinLotSize Decimal
+	MaxLotSize Decimal
+	// Min price times amount in Symbol2
+	MinLotSizeS2 Decimal
+	MinPrice     Decimal
+	MaxPrice     Decimal
+	// Decimals of prices and the resulting price increment
+	PricePrecision int
+	TickSize       Decimal
+	// Amount increment from the precision of Symbol1, the smallest
+	// Decimal if the currency is unknown
+	LotStep Decimal
+}
+
+// Returns the increment of numbers of precision decimals.
+func precisionIncrement(precision int) Decimal {
+	increment := Decimal(1)
+	for i := precision; i < kDecimalPlaces; i++ {
+		increment *= 10
+	}
+	return increment
+}
+
+func newPairInfo(limit CurrencyLimit, currencies map[string]CurrencyProfile) PairInfo {
+	info := PairInfo{
+		Pair:           NewPair(limit.Symbol1, limit.Symbol2),
+		MinLotSize:     limit.MinLotSize,
+		MaxLotSize:     limit.MaxLotSize,
+		MinLotSizeS2:   limit.MinLotSizeS2,
+		MinPrice:       limit.MinPrice,
+		MaxPrice:       limit.MaxPrice,
+		PricePrecision: kDecimalPlaces,
+		LotStep:        1,
+	}
+	if limit.PricePrecision != nil && *limit.PricePrecision >= 0 && *limit.PricePrecision < kDecimalPlaces {
+		info.PricePrecision = *limit.PricePrecision
+	}
+	info.TickSize = precisionIncrement(info.PricePrecision)
+	if currency, ok := currencies[info.Symbol1]; ok && currency.Precision >= 0 && currency.Precision < kDecimalPlaces {
+		info.LotStep = precisionIncrement(currency.Precision)
+	}
+	return info
+}
+
+func roundTo(value, increment Decimal, nearest bool) Decimal {
+	if increment <= 1 {
+		return value
+	}
+	if nearest {
+		if value < 0 {
+			return -roundTo(-value, increment, nearest)
+		}
+		value += increment / 2
+	}
+	return value / increment * increment
+}
+
+// RoundPrice rounds price to the nearest multiple of TickSize.
+func (info PairInfo) RoundPrice(price Decimal) Decimal {
+	return roundTo(price, info.TickSize, true)
+}
+
+// RoundAmount rounds amount down to a multiple of LotStep, so that it
+// never exceeds the amount asked for.
+func (info PairInfo) RoundAmount(amount Decimal) Decimal {
+	return roundTo(amount, info.LotStep, false)
+}
+
+// Validate returns an error matching ErrInvalidOrder if amount or price
+// break the limits of the pair or are not multiples of its increments.
+func (info PairInfo) Validate(amount, price Decimal) error {
+	fail := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%w: %s %s", ErrInvalidOrder, info.Pair, fmt.Sprintf(format, args...))
+	}
+	switch {
+	case amount <= 0:
+		return fail("amount %s is not positive", amount)
+	case price <= 0:
+		return fail("price %s is not positive", price)
+	case info.RoundPrice(price) != price:
+		return fail("price %s is not a multiple of %s", price, info.TickSize)
+	case info.RoundAmount(amount) != amount:
+		return fail("amount %s is not a multiple of %s", amount, info.LotStep)
+	case info.MinLotSize > 0 && amount < info.MinLotSize:
+		return fail("amount %s below %s", amount, info.MinLotSize)
+	case info.MaxLotSize > 0 && amount > info.MaxLotSize:
+		return fail("amount %s above %s", amount, info.MaxLotSize)
+	case info.MinLotSizeS2 > 0 && amount.Mul(price) < info.MinLotSizeS2:
+		return fail("total %s below %s", amount.Mul(price), info.MinLotSizeS2)
+	case info.MinPrice > 0 && price < info.MinPrice:
+		return fail("price %s below %s", price, info.MinPrice)
+	case info.MaxPrice > 0 && price > info.MaxPrice:
+		return fail("price %s above %s", price, info.MaxPrice)
+	}
+	return nil
+}
+
+// PairRegistry holds the pairs traded on the exchange, see
+// LoadPairRegistry. Set it as Options.Pairs to check subscriptions and
+// orders against it. It is safe for concurrent use.
+type PairRegistry struct {
+	lock       sync.RWMutex
+	limits     []CurrencyLimit
+	currencies map[string]CurrencyProfile
+	pairs      map[Pair]PairInfo
+}
+
+func NewPairRegistry(limits []CurrencyLimit) *PairRegistry {
+	registry := &PairRegistry{}
+	registry.Update(limits)
+	return registry
+}
+
+// LoadPairRegistry fills a registry with the currency limits and the
+// currency precisions of the REST API.
+func LoadPairRegistry(ctx gocontext.Context, client *RestClient) (*PairRegistry, error) {
+	limits, error := client.CurrencyLimits(ctx)
+	if error != nil {
+		return nil, error
+	}
+	currencies, error := client.CurrencyProfiles(ctx)
+	if error != nil {
+		return nil, error
+	}
+	registry := NewPairRegistry(limits)
+	registry.UpdateCurrencies(currencies)
+	return registry, nil
+}
+
+// Update replaces the pairs of the registry.
+func (registry *PairRegistry) Update(limits []CurrencyLimit) {
+	registry.lock.Lock()
+	registry.limits = limits
+	registry.rebuild()
+	registry.lock.Unlock()
+}
+
+// UpdateCurrencies replaces the currency precisions the amount
+// increments of the pairs are taken from.
+func (registry *PairRegistry) UpdateCurrencies(currencies []CurrencyProfile) {
+	registry.lock.Lock()
+	registry.currencies = make(map[string]CurrencyProfile, len(currencies))
+	for _, currency := range currencies {
+		registry.currencies[strings.ToUpper(currency.Code)] = currency
+	}
+	registry.rebuild()
+	registry.lock.Unlock()
+}
+
+// Requires lock to be held.
+func (registry *PairRegistry) rebuild() {
+	registry.pairs = make(map[Pair]PairInfo, len(registry.limits))
+	for _, limit := range registry.limits {
+		info := newPairInfo(limit, registry.currencies)
+		registry.pairs[info.Pair] = info
+	}
+}
+
+// Lookup returns the limits of sym1:sym2 or an error matching
+// ErrUnknownPair.
+func (registry *PairRegistry) Lookup(sym1, sym2 string) (PairInfo, error) {
+	pair := NewPair(sym1, sym2)
+	registry.lock.RLock()
+	info, ok := registry.pairs[pair]
+	registry.lock.RUnlock()
+	if !ok {
+		return info, fmt.Errorf("%w: %s", ErrUnknownPair, pair)
+	}
+	return info, nil
+}
+
+// Pairs returns every pair of the registry, sorted.
+func (registry *PairRegistry) Pairs() []Pair {
+	registry.lock.RLock()
+	pairs := make([]Pair, 0, len(registry.pairs))
+	for pair := range registry.pairs {
+		pairs = append(pairs, pair)
+	}
+	registry.lock.RUnlock()
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].String() < pairs[j].String() })
+	return pairs
+}
+
+// Rounds amount and price of an order to the increments of its pair
+// and checks the limits, if the context has a registry.
+func (context *Context) normalizeOrder(sym1, sym2 string, amount, price Decimal) (Decimal, Decimal, error) {
+	if context.options.Pairs == nil {
+		return amount, price, nil
+	}
+	info, error := context.options.Pairs.Lookup(sym1, sym2)
+	if error != nil {
+		return amount, price, error
+	}
+	amount, price = info.RoundAmount(amount), info.RoundPrice(price)
+	return amount, price, info.Validate(amount, price)
+}
+
+// Rounds the prices of a position to the ticks of its pair and checks
+// the limits, if the context has a registry. Long positions are sized
+// like orders in sym1, short ones in sym2 are checked as their amount
+// in sym1 at price.
+func (context *Context) normalizePosition(sym1, sym2 string, position_type PositionType, amount, price, stop_loss Decimal) (Decimal, Decimal, Decimal, error) {
+	if context.options.Pairs == nil {
+		return amount, price, stop_loss, nil
+	}
+	info, error := context.options.Pairs.Lookup(sym1, sym2)
+	if error != nil {
+		return amount, price, stop_loss, error
+	}
+	price, stop_loss = info.RoundPrice(price), info.RoundPrice(stop_loss)
+	if position_type == PositionShort {
+		return amount, price, stop_loss, info.Validate(info.RoundAmount(amount.Div(price)), price)
+	}
+	amount = info.RoundAmount(amount)
+	return amount, price, stop_loss, info.Validate(amount, price)
+}
+
+// Checks that sym1:sym2 is known, if the context has a registry.
+func (context *Context) checkPair(sym1, sym2 string) error {
+	if context.options.Pairs == nil {
+		return nil
+	}
+	_, error := context.options.Pairs.Lookup(sym1, sym2)
+	return error
+}
@@ -0,0 +1,162 @@
+package cexio
+
+import (
+	gocontext "context"
+	"encoding/json"
+	"errors"
+	"github.com/sahmad98/cex.io/cexiotest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testRegistry() *PairRegistry {
+	precision := 1
+	registry := NewPairRegistry([]CurrencyLimit{
+		{Symbol1: "BTC", Symbol2: "USD", MinLotSize: d("0.01"), MinLotSizeS2: d("2.5"), MaxLotSize: d("30"),
+			MinPrice: d("100"), MaxPrice: d("35000"), PricePrecision: &precision},
+		{Symbol1: "ETH", Symbol2: "BTC", MinLotSize: d("0.1")},
+	})
+	registry.UpdateCurrencies([]CurrencyProfile{{Code: "btc", Precision: 3}, {Code: "USD", Precision: 2}})
+	return registry
+}
+
+func TestParsePair(t *testing.T) {
+	pair, error := ParsePair("btc:Usd")
+	if error != nil || pair != (Pair{"BTC", "USD"}) || pair.String() != "BTC:USD" {
+		t.Fatalf("Unexpected pair %v, %v", pair, error)
+	}
+	for _, invalid := range []string{"BTCUSD", "BTC:", ":USD", "BTC:USD:EUR"} {
+		if _, error := ParsePair(invalid); !errors.Is(error, ErrUnknownPair) {
+			t.Fatalf("Expected ErrUnknownPair for %q, got %v", invalid, error)
+		}
+	}
+}
+
+func TestPairRegistry(t *testing.T) {
+	registry := testRegistry()
+	if pairs := registry.Pairs(); len(pairs) != 2 || pairs[0].String() != "BTC:USD" {
+		t.Fatalf("Unexpected pairs %v", pairs)
+	}
+	if _, error := registry.Lookup("LTC", "USD"); !errors.Is(error, ErrUnknownPair) {
+		t.Fatalf("Expected ErrUnknownPair, got %v", error)
+	}
+	btc, error := registry.Lookup("btc", "usd")
+	if error != nil {
+		t.Fatal(error)
+	}
+	if btc.TickSize != d("0.1") || btc.RoundPrice(d("1234.56")) != d("1234.6") || btc.RoundPrice(d("1234.54")) != d("1234.5") {
+		t.Fatalf("Unexpected price rounding, tick %s", btc.TickSize)
+	}
+	if btc.LotStep != d("0.001") || btc.RoundAmount(d("0.12345678")) != d("0.123") {
+		t.Fatalf("Unexpected amount rounding, step %s", btc.LotStep)
+	}
+	eth, _ := registry.Lookup("ETH", "BTC")
+	if eth.TickSize != 1 || eth.RoundPrice(d("0.03141592")) != d("0.03141592") {
+		t.Fatalf("Unexpected unlimited precision, tick %s", eth.TickSize)
+	}
+	if eth.LotStep != 1 || eth.RoundAmount(d("0.12345678")) != d("0.12345678") {
+		t.Fatalf("Unexpected step of unknown currency %s", eth.LotStep)
+	}
+
+	for _, order := range [][2]string{
+		{"0.001", "1000"},  // below min lot
+		{"31", "1000"},     // above max lot
+		{"0.01", "200"},    // total below min
+		{"1", "50"},        // below min price
+		{"1", "40000"},     // above max price
+		{"1", "1000.05"},   // not a tick
+		{"0", "1000"},      // no amount
+		{"0.0125", "1000"}, // not a lot step
+	} {
+		if error := btc.Validate(d(order[0]), d(order[1])); !errors.Is(error, ErrInvalidOrder) {
+			t.Fatalf("Expected ErrInvalidOrder for %v, got %v", order, error)
+		}
+	}
+	if error := btc.Validate(d("0.01"), d("1000")); error != nil {
+		t.Fatal(error)
+	}
+}
+
+func TestOrdersCheckedAgainstPairs(t *testing.T) {
+	var sent int32
+	server := newHandledServer(t, func(request cexiotest.Request, conn *cexiotest.Conn) {
+		atomic.AddInt32(&sent, 1)
+		order := orderRequest{}
+		json.Unmarshal(request.Data, &order)
+		if order.Price != "1000.1" || order.Amount != d("0.5") {
+			conn.Fail("unexpected order")
+			return
+		}
+		conn.Reply(map[string]interface{}{"id": "1", "type": "buy", "price": order.Price, "amount": "0.5", "pending": "0.5"})
+	})
+	context, error := NewContext(Options{Endpoint: server.URL, Pairs: testRegistry()})
+	if error != nil {
+		t.Fatal(error)
+	}
+	defer context.closeConnection()
+
+	if _, error := context.PlaceOrder("LTC", "USD", OrderBuy, d("1"), d("100")); !errors.Is(error, ErrUnknownPair) {
+		t.Fatalf("Expected ErrUnknownPair, got %v", error)
+	}
+	if _, error := context.PlaceOrder("BTC", "USD", OrderBuy, d("0.001"), d("1000")); !errors.Is(error, ErrInvalidOrder) {
+		t.Fatalf("Expected ErrInvalidOrder, got %v", error)
+	}
+	if _, error := context.PlaceOrder("BTC", "USD", OrderBuy, d("0.5"), d("1000.06")); error != nil {
+		t.Fatal(error)
+	}
+	if atomic.LoadInt32(&sent) != 1 {
+		t.Fatalf("Expected only the valid order to be sent, got %d", sent)
+	}
+
+	md := newMarketDataAdapter(context)
+	future := md.Subscribe("LTC", "USD", 5)
+	if _, error := future.Wait(gocontext.Background()); !errors.Is(error, ErrUnknownPair) {
+		t.Fatalf("Expected ErrUnknownPair, got %v", error)
+	}
+	if error := md.SetTickerPolling("LTC", "USD", time.Second); !errors.Is(error, ErrUnknownPair) {
+		t.Fatalf("Expected ErrUnknownPair, got %v", error)
+	}
+	if error := md.SubscribeCandles("LTC", "USD", time.Minute); !errors.Is(error, ErrUnknownPair) {
+		t.Fatalf("Expected ErrUnknownPair, got %v", error)
+	}
+	if error := md.SetTickerPolling("btc", "usd", time.Second); error != nil {
+		t.Fatal(error)
+	}
+}
+
+func TestPositionsCheckedAgainstPairs(t *testing.T) {
+	requests := make(chan cexiotest.Request, 8)
+	server := newFixtureServer(t, func(request cexiotest.Request) { requests <- request })
+	context, error := NewContext(Options{Endpoint: server.URL, Pairs: testRegistry()})
+	if error != nil {
+		t.Fatal(error)
+	}
+	defer context.closeConnection()
+
+	if _, error := context.OpenPosition("LTC", "USD", PositionLong, d("1"), 2, d("100"), d("90")); !errors.Is(error, ErrUnknownPair) {
+		t.Fatalf("Expected ErrUnknownPair, got %v", error)
+	}
+	if _, error := context.OpenPosition("BTC", "USD", PositionLong, d("0.0059"), 2, d("1000"), d("900")); !errors.Is(error, ErrInvalidOrder) {
+		t.Fatalf("Expected ErrInvalidOrder, got %v", error)
+	}
+	// Short positions are sized in USD, 5 USD are 0.005 BTC
+	if _, error := context.OpenPosition("BTC", "USD", PositionShort, d("5"), 2, d("1000"), d("1100")); !errors.Is(error, ErrInvalidOrder) {
+		t.Fatalf("Expected ErrInvalidOrder, got %v", error)
+	}
+	if _, error := context.OpenPosition("BTC", "USD", PositionLong, d("1.23456"), 2, d("650.3232"), d("600.3232")); error != nil {
+		t.Fatal(error)
+	}
+	request := openPositionRequest{}
+	if error := json.Unmarshal((<-requests).Data, &request); error != nil {
+		t.Fatal(error)
+	}
+	if request.Amount != d("1.234") || request.Price != "650.3" || request.StopLossPrice != "600.3" {
+		t.Fatalf("Unexpected request %+v", request)
+	}
+	select {
+	case request := <-requests:
+		t.Fatalf("Unexpected request %+v", request)
+	default:
+	}
+}
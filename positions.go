@@ -0,0 +1,205 @@
+package cexio
+
+import (
+	"encoding/json"
+)
+
+type PositionType string
+
+const (
+	PositionLong  PositionType = "long"
+	PositionShort PositionType = "short"
+)
+
+// Margin position returned by open-position, get-position and
+// open-positions requests. Amount is in PositionSymbol, the margin is
+// held in MarginSymbol. CEX.IO does not send the P&L of open
+// positions, see ProfitLoss.
+type Position struct {
+	Id             string       `json:"id"`
+	Time           int64        `json:"otime"`
+	Type           PositionType `json:"ptype"`
+	Pair           []string     `json:"pair"`
+	Symbol         string       `json:"symbol"`
+	PositionSymbol string       `json:"psymbol"`
+	MarginSymbol   string       `json:"msymbol"`
+	LoanSymbol     string       `json:"lsymbol"`
+	Amount         Decimal      `json:"amount"`
+	Leverage       Decimal      `json:"leverage"`
+	OpenPrice      Decimal      `json:"oprice"`
+	StopLossPrice  Decimal      `json:"stopLossPrice"`
+	// Price at which the position is closed for lack of margin
+	LiquidationPrice Decimal `json:"flPrice"`
+	StopLossAmount   Decimal `json:"slamount"`
+	StopLossRemains  Decimal `json:"slremains"`
+	LoanRemains      Decimal `json:"lremains"`
+	OpenFee          Decimal `json:"ofee"`
+	PositionFee      Decimal `json:"pfee"`
+	CloseFee         Decimal `json:"cfee"`
+	TotalFee         Decimal `json:"tfeeAmount"`
+	Kind             string  `json:"okind"`
+	Status           string  `json:"status"`
+}
+
+// ProfitLoss returns the P&L in the second symbol of the pair of
+// closing position at the best price of orderbook, the bid for long
+// positions and the ask for short ones, fees excluded. It returns false
+// if orderbook is stale or has no such price.
+func (position Position) ProfitLoss(orderbook Orderbook) (Decimal, bool) {
+	if orderbook.Stale || position.OpenPrice <= 0 {
+		return 0, false
+	}
+	if position.Type == PositionShort {
+		best, ok := orderbook.Asks.Best()
+		if !ok {
+			return 0, false
+		}
+		// Short positions are sized in the second symbol
+		return (position.OpenPrice - best.Price).Mul(position.Amount.Div(position.OpenPrice)), true
+	}
+	best, ok := orderbook.Bids.Best()
+	if !ok {
+		return 0, false
+	}
+	return (best.Price - position.OpenPrice).Mul(position.Amount), true
+}
+
+// ProfitLoss returns the P&L of position at the current book of its
+// pair, see Position.ProfitLoss.
+func (md *MarketDataAdapter) ProfitLoss(position Position) (Decimal, bool) {
+	if len(position.Pair) != 2 {
+		return 0, false
+	}
+	orderbook, ok := md.Book(position.Pair[0] + ":" + position.Pair[1])
+	if !ok {
+		return 0, false
+	}
+	return position.ProfitLoss(orderbook)
+}
+
+// Result of close-position request, also pushed when the server closes
+// a position, e.g. at its stop-loss price.
+type ClosedPosition struct {
+	Id           json.Number  `json:"id"`
+	Time         int64        `json:"ctime"`
+	Type         PositionType `json:"ptype"`
+	MarginSymbol string       `json:"msymbol"`
+	Pair         struct {
+		Symbol1 string `json:"symbol1"`
+		Symbol2 string `json:"symbol2"`
+	} `json:"pair"`
+	Price  Decimal `json:"price"`
+	Profit Decimal `json:"profit"`
+}
+
+type openPositionRequest struct {
+	Pair          []string     `json:"pair"`
+	Symbol        string       `json:"symbol"`
+	Amount        Decimal      `json:"amount"`
+	Leverage      int          `json:"leverage,string"`
+	Type          PositionType `json:"ptype"`
+	AnySlippage   bool         `json:"anySlippage,string"`
+	Price         string       `json:"eoprice"`
+	StopLossPrice string       `json:"stopLossPrice"`
+}
+
+type positionRequest struct {
+	Pair []string `json:"pair,omitempty"`
+	Id   string   `json:"id"`
+}
+
+// OpenPosition opens a margin position of amount at leverage, expected
+// to open at price. The request fails if the price has moved meanwhile.
+// A long position is opened in sym1, a short one in sym2. The position
+// is not opened if the risk gate rejects amount times leverage, see
+// Risk. With Options.Pairs set, prices are rounded and checked like
+// those of PlaceOrder.
+func (context *Context) OpenPosition(sym1, sym2 string, position_type PositionType, amount Decimal, leverage int, price, stop_loss Decimal) (*Position, error) {
+	amount, price, stop_loss, error := context.normalizePosition(sym1, sym2, position_type, amount, price, stop_loss)
+	if error != nil {
+		return nil, error
+	}
+	order := positionRiskOrder(sym1, sym2, position_type, amount, DecimalFromInt(int64(leverage)), price)
+	if error := context.risk.Check(order); error != nil {
+		return nil, error
+	}
+	request := openPositionRequest{
+		Pair:          []string{sym1, sym2},
+		Symbol:        sym1,
+		Amount:        amount,
+		Leverage:      leverage,
+		Type:          position_type,
+		Price:         price.String(),
+		StopLossPrice: stop_loss.String(),
+	}
+	if position_type == PositionShort {
+		request.Symbol = sym2
+	}
+	position := &Position{}
+	if error := context.request("open-position", request, position); error != nil {
+		return nil, error
+	}
+	return position, nil
+}
+
+// Returns a position as a buy for long positions and a sell for short
+// ones, of amount times leverage in sym1.
+func positionRiskOrder(sym1, sym2 string, position_type PositionType, amount, leverage, price Decimal) RiskOrder {
+	exposure := amount.Mul(leverage)
+	order := RiskOrder{sym1, sym2, OrderBuy, exposure, price}
+	if position_type == PositionShort {
+		// Short positions are sized in sym2
+		order.Type = OrderSell
+		order.Amount = exposure.Div(price)
+	}
+	return order
+}
+
+func (context *Context) GetPosition(position_id string) (*Position, error) {
+	position := &Position{}
+	if error := context.request("get-position", positionRequest{Id: position_id}, position); error != nil {
+		return nil, error
+	}
+	return position, nil
+}
+
+func (context *Context) OpenPositions(sym1, sym2 string) ([]Position, error) {
+	positions := []Position{}
+	if error := context.request("open-positions", pairRequest{[]string{sym1, sym2}}, &positions); error != nil {
+		return nil, error
+	}
+	return positions, nil
+}
+
+func (context *Context) ClosePosition(sym1, sym2, position_id string) (*ClosedPosition, error) {
+	closed := &ClosedPosition{}
+	request := positionRequest{Pair: []string{sym1, sym2}, Id: position_id}
+	if error := context.request("close-position", request, closed); error != nil {
+		return nil, error
+	}
+	return closed, nil
+}
+
+// OnPositionClosed registers handler for positions closed by the
+// server. Handlers run on the reader goroutine and must not block.
+func (context *Context) OnPositionClosed(handler func(ClosedPosition)) {
+	context.positionLock.Lock()
+	context.positionHandlers = append(context.positionHandlers, handler)
+	context.positionLock.Unlock()
+}
+
+// Handles close-position messages without oid, which are not answers
+// to ClosePosition.
+func (context *Context) handlePositionClosedPush(push *Response) {
+	closed := ClosedPosition{}
+	if error := json.Unmarshal(push.Data, &closed); error != nil {
+		context.log().Errorf("Unable to parse closed position: %s", error)
+		return
+	}
+	context.positionLock.Lock()
+	handlers := context.positionHandlers
+	context.positionLock.Unlock()
+	for _, handler := range handlers {
+		handler(closed)
+	}
+}
@@ -0,0 +1,140 @@
+package cexio
+
+import (
+	"encoding/json"
+	"github.com/sahmad98/cex.io/cexiotest"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Reads a recorded message from testdata.
+func readFixture(t *testing.T, name string) map[string]interface{} {
+	data, error := ioutil.ReadFile(filepath.Join("testdata", name+".json"))
+	if error != nil {
+		t.Fatal(error)
+	}
+	message := map[string]interface{}{}
+	if error := json.Unmarshal(data, &message); error != nil {
+		t.Fatal(error)
+	}
+	return message
+}
+
+// Answers each request with the recorded response of its type.
+func newFixtureServer(t *testing.T, check func(request cexiotest.Request)) *cexiotest.Server {
+	return newHandledServer(t, func(request cexiotest.Request, conn *cexiotest.Conn) {
+		check(request)
+		response := readFixture(t, request.Type)
+		response["oid"] = request.Oid
+		conn.Send(response)
+	})
+}
+
+func TestPositions(t *testing.T) {
+	requests := make(chan cexiotest.Request, 8)
+	server := newFixtureServer(t, func(request cexiotest.Request) { requests <- request })
+	context := newTestContext(t, server)
+
+	position, error := context.OpenPosition("BTC", "USD", PositionLong, d("1"), 2, d("650.3232"), d("600.3232"))
+	if error != nil {
+		t.Fatal(error)
+	}
+	request := openPositionRequest{}
+	if error := json.Unmarshal((<-requests).Data, &request); error != nil {
+		t.Fatal(error)
+	}
+	if request.Leverage != 2 || request.Symbol != "BTC" || request.Price != "650.3232" || request.StopLossPrice != "600.3232" || request.AnySlippage {
+		t.Fatalf("Unexpected request %+v", request)
+	}
+	if position.Id != "125531" || position.Leverage != d("2") || position.StopLossPrice != d("600.3232") || position.LiquidationPrice != d("325.1616") {
+		t.Fatalf("open-position: %+v", position)
+	}
+
+	position, error = context.GetPosition("104102")
+	if error != nil || position.Type != PositionLong || position.TotalFee != d("3.02") || position.StopLossRemains != d("614.52") {
+		t.Fatalf("get-position: %+v %v", position, error)
+	}
+	if (<-requests).Type != "get-position" {
+		t.Fatal("Unexpected request")
+	}
+
+	positions, error := context.OpenPositions("BTC", "USD")
+	if error != nil || len(positions) != 2 || positions[1].Type != PositionShort || positions[1].MarginSymbol != "BTC" {
+		t.Fatalf("open-positions: %+v %v", positions, error)
+	}
+	<-requests
+
+	closed, error := context.ClosePosition("BTC", "USD", "104034")
+	if error != nil || closed.Id != "104034" || closed.Profit != d("-12.48") || closed.Pair.Symbol2 != "USD" {
+		t.Fatalf("close-position: %+v %v", closed, error)
+	}
+	position_request := positionRequest{}
+	json.Unmarshal((<-requests).Data, &position_request)
+	if position_request.Id != "104034" || len(position_request.Pair) != 2 {
+		t.Fatalf("Unexpected request %+v", position_request)
+	}
+}
+
+func TestOnPositionClosed(t *testing.T) {
+	server := newHandledServer(t, func(request cexiotest.Request, conn *cexiotest.Conn) {
+		// Stop-loss hit while a close of another position is pending
+		conn.Send(readFixture(t, "position-closed"))
+		response := readFixture(t, request.Type)
+		response["oid"] = request.Oid
+		conn.Send(response)
+	})
+	context := newTestContext(t, server)
+	closed := make(chan ClosedPosition, 1)
+	context.OnPositionClosed(func(position ClosedPosition) { closed <- position })
+
+	response, error := context.ClosePosition("BTC", "USD", "104034")
+	if error != nil || response.Id != "104034" {
+		t.Fatalf("close-position: %+v %v", response, error)
+	}
+	select {
+	case position := <-closed:
+		if position.Id != "104102" || position.Price != d("334.552") || position.Profit != d("-137.67") {
+			t.Fatalf("Unexpected closed position %+v", position)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Position closed push not handled")
+	}
+}
+
+func TestPositionProfitLoss(t *testing.T) {
+	md := newMarketDataAdapter(&Context{SendChannel: make(chan Message, 16)})
+	md.subscriptions["BTC:USD"] = 5
+	data, error := json.Marshal(readFixture(t, "order-book-subscribe"))
+	if error != nil {
+		t.Fatal(error)
+	}
+	m := &Message{}
+	if error := json.Unmarshal(data, m); error != nil {
+		t.Fatal(error)
+	}
+	md.handleUpdate(m)
+
+	message := readFixture(t, "open-positions")
+	data, _ = json.Marshal(message["data"])
+	positions := []Position{}
+	if error := json.Unmarshal(data, &positions); error != nil {
+		t.Fatal(error)
+	}
+	// The long position of 0.5 BTC sells at 620, the short one of 300
+	// USD buys 0.49172266 BTC back at 620.1
+	for i, expected := range []Decimal{d("5.05"), d("-4.9172266")} {
+		if profit, ok := md.ProfitLoss(positions[i]); !ok || profit != expected {
+			t.Fatalf("Unexpected P&L of %s: %s %v", positions[i].Id, profit, ok)
+		}
+	}
+
+	if _, ok := positions[0].ProfitLoss(Orderbook{Pair: "BTC:USD"}); ok {
+		t.Fatal("P&L without a bid")
+	}
+	positions[0].Pair = []string{"ETH", "USD"}
+	if _, ok := md.ProfitLoss(positions[0]); ok {
+		t.Fatal("P&L without a book")
+	}
+}
@@ -0,0 +1,590 @@
+package cexio
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Recordings are directories of one session each, holding files like
+// frames-000001.rec.gz with an index frames-000001.idx written once the
+// file is complete. A file starts with kRecordMagic followed by frames
+// of a big endian uint32 length, an int64 receive time in unix
+// nanoseconds, the raw frame and a CRC-32 of time and frame. Files are
+// written as a series of gzip members, one per block, so that a reader
+// can start at any block listed in the index.
+const (
+	kRecordMagic           = "CXR1"
+	kRecordSuffix          = ".rec.gz"
+	kIndexSuffix           = ".idx"
+	kDefaultRecordFileSize = 64 << 20
+	kDefaultRotateInterval = time.Hour
+	kRecordBlockInterval   = time.Second
+	kRecordQueueSize       = 4096
+	kMaxRecordedFrame      = 16 << 20
+)
+
+var ErrCorruptRecording = errors.New("cexio: corrupt recording")
+
+// Options of the recorder of a Context, see Recorder.
+type RecordOptions struct {
+	// Directory sessions are created in, empty disables recording
+	Dir string
+	// Pairs like "BTC:USD" whose frames are recorded, all if empty.
+	// Frames of no pair, like pings and account pushes, are always
+	// recorded.
+	Pairs []string
+	// Size of the frames after which a new file is started, and max
+	// age of a file. Default to 64MB and an hour.
+	MaxFileSize    int64
+	RotateInterval time.Duration
+}
+
+func (opts RecordOptions) validate() error {
+	if opts.MaxFileSize < 0 || opts.RotateInterval < 0 {
+		return errors.New("negative record file limit")
+	}
+	for _, pair := range opts.Pairs {
+		if _, error := ParsePair(pair); error != nil {
+			return error
+		}
+	}
+	return nil
+}
+
+// Index of a complete recording file.
+type RecordIndex struct {
+	File string `json:"file"`
+	// Receive times of the first and last frame in unix nanoseconds
+	First  int64 `json:"first"`
+	Last   int64 `json:"last"`
+	Frames int64 `json:"frames"`
+	// Size and CRC-32 of the compressed file
+	Size   int64         `json:"size"`
+	CRC32  uint32        `json:"crc32"`
+	Blocks []RecordBlock `json:"blocks"`
+}
+
+// Gzip member of a recording file starting at Offset with frame number
+// Frame, received at Time.
+type RecordBlock struct {
+	Time   int64 `json:"time"`
+	Offset int64 `json:"offset"`
+	Frame  int64 `json:"frame"`
+}
+
+// Frame read back from a recording.
+type RecordedFrame struct {
+	Time time.Time
+	Data []byte
+}
+
+type RecorderStats struct {
+	Frames uint64
+	// Frames of pairs not recorded
+	Skipped uint64
+	// Frames lost because the writer fell behind
+	Dropped uint64
+	Files   uint64
+	Bytes   uint64
+}
+
+// Counts the bytes written to a file, which are the offsets of the
+// blocks.
+type countingWriter struct {
+	writer io.Writer
+	count  int64
+}
+
+func (counter *countingWriter) Write(data []byte) (int, error) {
+	written, error := counter.writer.Write(data)
+	counter.count += int64(written)
+	return written, error
+}
+
+// Recorder writes raw websocket frames with their receive time to
+// rotating compressed files in a session directory. Frames are written
+// by a goroutine of the recorder, so a slow disk does not hold up the
+// reader.
+type Recorder struct {
+	options RecordOptions
+	session string
+	pairs   map[string]bool
+	frames  chan RecordedFrame
+	stopped chan struct{}
+	lock    sync.RWMutex
+	closed  bool
+	stats   RecorderStats
+	err     error
+
+	// Only used by the writer goroutine
+	sequence int
+	file     *os.File
+	checksum hash.Hash32
+	counter  *countingWriter
+	block    *gzip.Writer
+	index    RecordIndex
+	opened   time.Time
+	written  int64
+}
+
+// NewRecorder creates a session directory in opts.Dir named after the
+// current time and starts recording.
+func NewRecorder(opts RecordOptions) (*Recorder, error) {
+	if error := opts.validate(); error != nil {
+		return nil, error
+	}
+	if opts.MaxFileSize == 0 {
+		opts.MaxFileSize = kDefaultRecordFileSize
+	}
+	if opts.RotateInterval == 0 {
+		opts.RotateInterval = kDefaultRotateInterval
+	}
+	if error := os.MkdirAll(opts.Dir, 0755); error != nil {
+		return nil, error
+	}
+	name := "session-" + time.Now().UTC().Format("20060102-150405")
+	session := filepath.Join(opts.Dir, name)
+	for i := 2; ; i++ {
+		error := os.Mkdir(session, 0755)
+		if error == nil {
+			break
+		}
+		if !os.IsExist(error) {
+			return nil, error
+		}
+		session = filepath.Join(opts.Dir, fmt.Sprintf("%s-%d", name, i))
+	}
+	recorder := &Recorder{
+		options: opts,
+		session: session,
+		frames:  make(chan RecordedFrame, kRecordQueueSize),
+		stopped: make(chan struct{}),
+	}
+	if len(opts.Pairs) > 0 {
+		recorder.pairs = make(map[string]bool)
+		for _, pair := range opts.Pairs {
+			parsed, _ := ParsePair(pair)
+			recorder.pairs[parsed.String()] = true
+		}
+	}
+	go recorder.run()
+	return recorder, nil
+}
+
+// Session returns the directory the frames are written to.
+func (recorder *Recorder) Session() string {
+	return recorder.session
+}
+
+// Write queues frame received at recv_time, frames are dropped while
+// the writer is behind by kRecordQueueSize frames or once the recorder
+// is closed.
+func (recorder *Recorder) Write(frame []byte, recv_time time.Time) {
+	recorder.lock.RLock()
+	defer recorder.lock.RUnlock()
+	if recorder.closed {
+		return
+	}
+	select {
+	case recorder.frames <- RecordedFrame{recv_time, frame}:
+	default:
+		atomic.AddUint64(&recorder.stats.Dropped, 1)
+	}
+}
+
+func (recorder *Recorder) Stats() RecorderStats {
+	return RecorderStats{
+		Frames:  atomic.LoadUint64(&recorder.stats.Frames),
+		Skipped: atomic.LoadUint64(&recorder.stats.Skipped),
+		Dropped: atomic.LoadUint64(&recorder.stats.Dropped),
+		Files:   atomic.LoadUint64(&recorder.stats.Files),
+		Bytes:   atomic.LoadUint64(&recorder.stats.Bytes),
+	}
+}
+
+// Close writes the queued frames and the index of the last file.
+// Returns the first error of the writer, which stops recording.
+func (recorder *Recorder) Close() error {
+	recorder.lock.Lock()
+	if !recorder.closed {
+		recorder.closed = true
+		close(recorder.frames)
+	}
+	recorder.lock.Unlock()
+	<-recorder.stopped
+	return recorder.err
+}
+
+func (recorder *Recorder) run() {
+	defer close(recorder.stopped)
+	for frame := range recorder.frames {
+		if recorder.err != nil {
+			continue
+		}
+		if !recorder.accepts(frame.Data) {
+			atomic.AddUint64(&recorder.stats.Skipped, 1)
+			continue
+		}
+		recorder.err = recorder.write(frame)
+	}
+	if recorder.file != nil && recorder.err == nil {
+		recorder.err = recorder.finishFile()
+	}
+}
+
+func (recorder *Recorder) accepts(frame []byte) bool {
+	if recorder.pairs == nil {
+		return true
+	}
+	pair := framePair(frame)
+	return pair == "" || recorder.pairs[pair]
+}
+
+// Pair a frame is about, taken from pair or symbol1 and symbol2 of the
+// message or its data. Empty for frames of no pair.
+func framePair(frame []byte) string {
+	message := struct {
+		Pair json.RawMessage `json:"pair"`
+		Data json.RawMessage `json:"data"`
+	}{}
+	if json.Unmarshal(frame, &message) != nil {
+		return ""
+	}
+	if pair := pairOf(message.Pair); pair != "" {
+		return pair
+	}
+	data := struct {
+		Pair    json.RawMessage `json:"pair"`
+		Symbol1 string          `json:"symbol1"`
+		Symbol2 string          `json:"symbol2"`
+	}{}
+	if json.Unmarshal(message.Data, &data) != nil {
+		return ""
+	}
+	if pair := pairOf(data.Pair); pair != "" {
+		return pair
+	}
+	if data.Symbol1 != "" && data.Symbol2 != "" {
+		return NewPair(data.Symbol1, data.Symbol2).String()
+	}
+	return ""
+}
+
+// Pairs are written like "BTC:USD" or ["BTC", "USD"].
+func pairOf(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	text := ""
+	if json.Unmarshal(raw, &text) == nil {
+		if pair, error := ParsePair(text); error == nil {
+			return pair.String()
+		}
+		return ""
+	}
+	symbols := []string{}
+	if json.Unmarshal(raw, &symbols) == nil && len(symbols) == 2 {
+		return NewPair(symbols[0], symbols[1]).String()
+	}
+	return ""
+}
+
+func (recorder *Recorder) write(frame RecordedFrame) error {
+	if recorder.file != nil && (recorder.written >= recorder.options.MaxFileSize || frame.Time.Sub(recorder.opened) >= recorder.options.RotateInterval) {
+		if error := recorder.finishFile(); error != nil {
+			return error
+		}
+	}
+	if recorder.file == nil {
+		if error := recorder.openFile(frame.Time); error != nil {
+			return error
+		}
+	}
+	blocks := recorder.index.Blocks
+	if recorder.block != nil && time.Duration(frame.Time.UnixNano()-blocks[len(blocks)-1].Time) >= kRecordBlockInterval {
+		if error := recorder.block.Close(); error != nil {
+			return error
+		}
+		recorder.block = nil
+	}
+	if recorder.block == nil {
+		recorder.index.Blocks = append(recorder.index.Blocks,
+			RecordBlock{Time: frame.Time.UnixNano(), Offset: recorder.counter.count, Frame: recorder.index.Frames})
+		recorder.block = gzip.NewWriter(recorder.counter)
+		if recorder.index.Frames == 0 {
+			if _, error := recorder.block.Write([]byte(kRecordMagic)); error != nil {
+				return error
+			}
+		}
+	}
+	record := encodeFrame(frame)
+	if _, error := recorder.block.Write(record); error != nil {
+		return error
+	}
+	if recorder.index.Frames == 0 {
+		recorder.index.First = frame.Time.UnixNano()
+	}
+	recorder.index.Last = frame.Time.UnixNano()
+	recorder.index.Frames++
+	recorder.written += int64(len(record))
+	atomic.AddUint64(&recorder.stats.Frames, 1)
+	atomic.AddUint64(&recorder.stats.Bytes, uint64(len(record)))
+	return nil
+}
+
+func encodeFrame(frame RecordedFrame) []byte {
+	record := make([]byte, 12+len(frame.Data)+4)
+	binary.BigEndian.PutUint32(record, uint32(len(frame.Data)))
+	binary.BigEndian.PutUint64(record[4:], uint64(frame.Time.UnixNano()))
+	copy(record[12:], frame.Data)
+	binary.BigEndian.PutUint32(record[12+len(frame.Data):], crc32.ChecksumIEEE(record[4:12+len(frame.Data)]))
+	return record
+}
+
+func (recorder *Recorder) openFile(now time.Time) error {
+	recorder.sequence++
+	name := fmt.Sprintf("frames-%06d%s", recorder.sequence, kRecordSuffix)
+	file, error := os.Create(filepath.Join(recorder.session, name))
+	if error != nil {
+		return error
+	}
+	recorder.file = file
+	recorder.checksum = crc32.NewIEEE()
+	recorder.counter = &countingWriter{writer: io.MultiWriter(file, recorder.checksum)}
+	recorder.index = RecordIndex{File: name}
+	recorder.opened = now
+	recorder.written = 0
+	atomic.AddUint64(&recorder.stats.Files, 1)
+	return nil
+}
+
+// Closes the current file and writes its index next to it.
+func (recorder *Recorder) finishFile() error {
+	if recorder.block != nil {
+		if error := recorder.block.Close(); error != nil {
+			return error
+		}
+		recorder.block = nil
+	}
+	if error := recorder.file.Close(); error != nil {
+		return error
+	}
+	recorder.file = nil
+	recorder.index.Size = recorder.counter.count
+	recorder.index.CRC32 = recorder.checksum.Sum32()
+	data, error := json.Marshal(recorder.index)
+	if error != nil {
+		return error
+	}
+	path := filepath.Join(recorder.session, strings.TrimSuffix(recorder.index.File, kRecordSuffix)+kIndexSuffix)
+	if error := ioutil.WriteFile(path+".tmp", data, 0644); error != nil {
+		return error
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// RecordingReader reads the frames of a session in the order they were
+// received. Files without an index, e.g. after a crash, are read from
+// the start and end at the first incomplete frame.
+type RecordingReader struct {
+	files   []string
+	indexes map[string]*RecordIndex
+	current int
+	file    *os.File
+	reader  *bufio.Reader
+	// Frames before this time are skipped, see Seek
+	from int64
+}
+
+// OpenRecording opens the session directory of a Recorder.
+func OpenRecording(session string) (*RecordingReader, error) {
+	files, error := filepath.Glob(filepath.Join(session, "frames-*"+kRecordSuffix))
+	if error != nil {
+		return nil, error
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("cexio: no recording in %s", session)
+	}
+	sort.Strings(files)
+	reader := &RecordingReader{files: files, indexes: make(map[string]*RecordIndex), current: -1}
+	for _, file := range files {
+		data, error := ioutil.ReadFile(strings.TrimSuffix(file, kRecordSuffix) + kIndexSuffix)
+		if os.IsNotExist(error) {
+			continue
+		}
+		if error != nil {
+			return nil, error
+		}
+		index := &RecordIndex{}
+		if error := json.Unmarshal(data, index); error != nil {
+			return nil, fmt.Errorf("%w: index of %s: %s", ErrCorruptRecording, file, error)
+		}
+		reader.indexes[file] = index
+	}
+	return reader, nil
+}
+
+// Opens file number i at the block offset, expecting the magic at the
+// start of the file.
+func (reader *RecordingReader) open(i int, offset int64) error {
+	reader.closeFile()
+	reader.current = i
+	file, error := os.Open(reader.files[i])
+	if error != nil {
+		return error
+	}
+	reader.file = file
+	if _, error := file.Seek(offset, io.SeekStart); error != nil {
+		return error
+	}
+	decompressed, error := gzip.NewReader(bufio.NewReader(file))
+	if error != nil {
+		return fmt.Errorf("%w: %s: %s", ErrCorruptRecording, reader.files[i], error)
+	}
+	reader.reader = bufio.NewReader(decompressed)
+	if offset == 0 {
+		magic := make([]byte, len(kRecordMagic))
+		if _, error := io.ReadFull(reader.reader, magic); error != nil || string(magic) != kRecordMagic {
+			return fmt.Errorf("%w: %s is no recording", ErrCorruptRecording, reader.files[i])
+		}
+	}
+	return nil
+}
+
+func (reader *RecordingReader) closeFile() {
+	if reader.file != nil {
+		reader.file.Close()
+		reader.file = nil
+		reader.reader = nil
+	}
+}
+
+// Next returns the next frame, or io.EOF at the end of the session.
+// Damaged frames fail with ErrCorruptRecording.
+func (reader *RecordingReader) Next() (RecordedFrame, error) {
+	for {
+		if reader.reader == nil {
+			if reader.current+1 >= len(reader.files) {
+				return RecordedFrame{}, io.EOF
+			}
+			if error := reader.open(reader.current+1, 0); error != nil {
+				return RecordedFrame{}, error
+			}
+		}
+		frame, error := reader.readFrame()
+		if error == io.EOF {
+			reader.closeFile()
+			continue
+		}
+		if error != nil {
+			return frame, error
+		}
+		if frame.Time.UnixNano() >= reader.from {
+			return frame, nil
+		}
+	}
+}
+
+func (reader *RecordingReader) readFrame() (RecordedFrame, error) {
+	header := make([]byte, 12)
+	if _, error := io.ReadFull(reader.reader, header); error != nil {
+		if error == io.EOF {
+			return RecordedFrame{}, io.EOF
+		}
+		return RecordedFrame{}, fmt.Errorf("%w: %s: truncated frame", ErrCorruptRecording, reader.files[reader.current])
+	}
+	length := binary.BigEndian.Uint32(header)
+	if length > kMaxRecordedFrame {
+		return RecordedFrame{}, fmt.Errorf("%w: %s: frame of %d bytes", ErrCorruptRecording, reader.files[reader.current], length)
+	}
+	body := make([]byte, length+4)
+	if _, error := io.ReadFull(reader.reader, body); error != nil {
+		return RecordedFrame{}, fmt.Errorf("%w: %s: truncated frame", ErrCorruptRecording, reader.files[reader.current])
+	}
+	checksum := crc32.NewIEEE()
+	checksum.Write(header[4:])
+	checksum.Write(body[:length])
+	if checksum.Sum32() != binary.BigEndian.Uint32(body[length:]) {
+		return RecordedFrame{}, fmt.Errorf("%w: %s: checksum mismatch", ErrCorruptRecording, reader.files[reader.current])
+	}
+	nanos := int64(binary.BigEndian.Uint64(header[4:]))
+	return RecordedFrame{Time: time.Unix(0, nanos), Data: body[:length]}, nil
+}
+
+// Seek positions the reader before the first frame received at or
+// after when, using the indexes to skip whole files and blocks.
+func (reader *RecordingReader) Seek(when time.Time) error {
+	target := when.UnixNano()
+	reader.from = target
+	start, offset := 0, int64(0)
+	for i, file := range reader.files {
+		index := reader.indexes[file]
+		if index == nil {
+			// Unknown times, read from here
+			start, offset = i, 0
+			break
+		}
+		if index.Last < target && i+1 < len(reader.files) {
+			continue
+		}
+		start = i
+		for _, block := range index.Blocks {
+			if block.Time > target {
+				break
+			}
+			offset = block.Offset
+		}
+		break
+	}
+	return reader.open(start, offset)
+}
+
+func (reader *RecordingReader) Close() error {
+	reader.closeFile()
+	return nil
+}
+
+// VerifyRecording checks the size and checksum of every indexed file
+// of a session and the checksum of every frame.
+func VerifyRecording(session string) error {
+	reader, error := OpenRecording(session)
+	if error != nil {
+		return error
+	}
+	defer reader.Close()
+	for _, file := range reader.files {
+		index := reader.indexes[file]
+		if index == nil {
+			continue
+		}
+		data, error := ioutil.ReadFile(file)
+		if error != nil {
+			return error
+		}
+		if int64(len(data)) != index.Size || crc32.ChecksumIEEE(data) != index.CRC32 {
+			return fmt.Errorf("%w: %s does not match its index", ErrCorruptRecording, file)
+		}
+	}
+	for {
+		if _, error := reader.Next(); error == io.EOF {
+			return nil
+		} else if error != nil {
+			return error
+		}
+	}
+}
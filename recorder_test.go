@@ -0,0 +1,134 @@
+package cexio
+
+import (
+	"errors"
+	"fmt"
+	"github.com/sahmad98/cex.io/cexiotest"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func readRecording(t *testing.T, reader *RecordingReader) []RecordedFrame {
+	frames := []RecordedFrame{}
+	for {
+		frame, error := reader.Next()
+		if error == io.EOF {
+			return frames
+		}
+		if error != nil {
+			t.Fatal(error)
+		}
+		frames = append(frames, frame)
+	}
+}
+
+func TestRecorderRoundTrip(t *testing.T) {
+	recorder, error := NewRecorder(RecordOptions{Dir: t.TempDir(), Pairs: []string{"BTC:USD"}, MaxFileSize: 1024})
+	if error != nil {
+		t.Fatal(error)
+	}
+	start := time.Unix(1600000000, 0)
+	expected := []string{}
+	for i := 0; i < 40; i++ {
+		frame := fmt.Sprintf(`{"e":"md_update","data":{"pair":"BTC:USD","id":%d}}`, i)
+		recorder.Write([]byte(frame), start.Add(time.Duration(i)*300*time.Millisecond))
+		expected = append(expected, frame)
+		if i%10 == 0 {
+			recorder.Write([]byte(`{"e":"tick","data":{"symbol1":"ETH","symbol2":"USD","price":"10"}}`), start)
+		}
+	}
+	recorder.Write([]byte(`{"e":"ping"}`), start.Add(time.Minute))
+	expected = append(expected, `{"e":"ping"}`)
+	if error := recorder.Close(); error != nil {
+		t.Fatal(error)
+	}
+	stats := recorder.Stats()
+	if stats.Frames != 41 || stats.Skipped != 4 || stats.Dropped != 0 || stats.Files < 2 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+	if error := VerifyRecording(recorder.Session()); error != nil {
+		t.Fatal(error)
+	}
+
+	reader, error := OpenRecording(recorder.Session())
+	if error != nil {
+		t.Fatal(error)
+	}
+	defer reader.Close()
+	frames := readRecording(t, reader)
+	if len(frames) != len(expected) {
+		t.Fatalf("Expected %d frames, got %d", len(expected), len(frames))
+	}
+	for i, frame := range frames[:40] {
+		if string(frame.Data) != expected[i] || !frame.Time.Equal(start.Add(time.Duration(i)*300*time.Millisecond)) {
+			t.Fatalf("Unexpected frame %d %s at %s", i, frame.Data, frame.Time)
+		}
+	}
+
+	if error := reader.Seek(start.Add(7 * time.Second)); error != nil {
+		t.Fatal(error)
+	}
+	frames = readRecording(t, reader)
+	if len(frames) != 41-24 || string(frames[0].Data) != expected[24] {
+		t.Fatalf("Unexpected frames after seek %d %s", len(frames), frames[0].Data)
+	}
+}
+
+func TestRecordingCorruption(t *testing.T) {
+	recorder, error := NewRecorder(RecordOptions{Dir: t.TempDir()})
+	if error != nil {
+		t.Fatal(error)
+	}
+	for i := 0; i < 10; i++ {
+		recorder.Write([]byte(fmt.Sprintf(`{"e":"ping","i":%d}`, i)), time.Now())
+	}
+	if error := recorder.Close(); error != nil {
+		t.Fatal(error)
+	}
+	path := filepath.Join(recorder.Session(), "frames-000001"+kRecordSuffix)
+	data, error := ioutil.ReadFile(path)
+	if error != nil {
+		t.Fatal(error)
+	}
+	data[len(data)/2] ^= 0xff
+	if error := ioutil.WriteFile(path, data, 0644); error != nil {
+		t.Fatal(error)
+	}
+	if error := VerifyRecording(recorder.Session()); !errors.Is(error, ErrCorruptRecording) {
+		t.Fatalf("Expected ErrCorruptRecording, got %v", error)
+	}
+}
+
+func TestContextRecordsFrames(t *testing.T) {
+	server := newHandledServer(t, func(request cexiotest.Request, conn *cexiotest.Conn) {
+		if request.Type == "ticker" {
+			conn.Reply(map[string]interface{}{"pair": []string{"BTC", "USD"}, "last": "100"})
+		}
+	})
+	context, error := NewContext(Options{Endpoint: server.URL, Record: RecordOptions{Dir: t.TempDir()}})
+	if error != nil {
+		t.Fatal(error)
+	}
+	if _, error := context.Send("ticker", []string{"BTC", "USD"}); error != nil {
+		t.Fatal(error)
+	}
+	// The connected push and the ticker
+	deadline := time.Now().Add(2 * time.Second)
+	for context.Recorder().Stats().Frames+uint64(len(context.Recorder().frames)) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	context.shutdown()
+
+	reader, error := OpenRecording(context.Recorder().Session())
+	if error != nil {
+		t.Fatal(error)
+	}
+	defer reader.Close()
+	frames := readRecording(t, reader)
+	if len(frames) == 0 || framePair(frames[len(frames)-1].Data) != "BTC:USD" {
+		t.Fatalf("Unexpected recorded frames %d", len(frames))
+	}
+}
@@ -0,0 +1,234 @@
+package cexio
+
+import (
+	gocontext "context"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Replay speeds, other speeds multiply the pace of the recording.
+const (
+	ReplayMaxSpeed       = 0
+	ReplayWallClockSpeed = 1
+)
+
+var ErrReplayFinished = errors.New("cexio: replay finished")
+
+// SimulatedClock is the Clock of a Replay, set to the receive time of
+// the frame being replayed.
+type SimulatedClock struct {
+	now int64
+}
+
+func (clock *SimulatedClock) Now() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&clock.now))
+}
+
+func (clock *SimulatedClock) Set(now time.Time) {
+	atomic.StoreInt64(&clock.now, now.UnixNano())
+}
+
+// Replay feeds a recorded session into a Context in place of the
+// websocket, so that a MarketDataAdapter on it rebuilds the books and
+// tickers as they were live:
+//
+//	replay, _ := NewReplay(session, ReplayMaxSpeed)
+//	context, _ := NewContext(Options{Dial: replay.Dial, Clock: replay.Clock()})
+//	md := NewMarketDataAdapter(context)
+//	md.OnBook("", handler)
+//	replay.Start()
+//	<-replay.Done()
+//	md.Sync(ctx)
+//
+// No frame is read before Start, so that the adapter and its handlers
+// are in place for the first one whatever the speed. Done only tells
+// that every frame was read, Sync waits until the adapter has applied
+// them. Requests written
+// by the context are discarded, the recorded responses are replayed
+// instead.
+type Replay struct {
+	reader *RecordingReader
+	speed  float64
+	clock  *SimulatedClock
+	// Guards dialed, reader and finishing
+	lock    sync.Mutex
+	dialed  bool
+	started chan struct{}
+	start   sync.Once
+	done    chan struct{}
+	err     error
+	frames  uint64
+}
+
+// NewReplay opens a session written by a Recorder. Speed is
+// ReplayWallClockSpeed for the pace of the recording, a multiple of it
+// like 10, or ReplayMaxSpeed for as fast as the context consumes.
+func NewReplay(session string, speed float64) (*Replay, error) {
+	if speed < 0 {
+		return nil, errors.New("cexio: negative replay speed")
+	}
+	reader, error := OpenRecording(session)
+	if error != nil {
+		return nil, error
+	}
+	return &Replay{reader: reader, speed: speed, clock: &SimulatedClock{}, started: make(chan struct{}), done: make(chan struct{})}, nil
+}
+
+// Start releases the frames to the dialed context, call it once every
+// adapter and handler of the context is set up.
+func (replay *Replay) Start() {
+	replay.start.Do(func() { close(replay.started) })
+}
+
+// Seek skips the frames received before when, must be called before
+// Start.
+func (replay *Replay) Seek(when time.Time) error {
+	return replay.reader.Seek(when)
+}
+
+func (replay *Replay) Clock() *SimulatedClock {
+	return replay.clock
+}
+
+// Dial returns the transport reading the session, the session can only
+// be replayed once.
+func (replay *Replay) Dial(ctx gocontext.Context) (Transport, error) {
+	replay.lock.Lock()
+	defer replay.lock.Unlock()
+	if replay.dialed {
+		return nil, ErrReplayFinished
+	}
+	replay.dialed = true
+	return &replayTransport{replay: replay, closed: make(chan struct{})}, nil
+}
+
+// Done is closed once every frame was read, reading failed or the
+// context was closed. The adapters of the context may still be
+// applying the last frames, see MarketDataAdapter.Sync.
+func (replay *Replay) Done() <-chan struct{} {
+	return replay.done
+}
+
+// Err returns why the replay stopped early, e.g. ErrClosed if the
+// context was closed before the last frame, otherwise nil.
+func (replay *Replay) Err() error {
+	<-replay.done
+	return replay.err
+}
+
+// Frames returns the number of frames replayed so far.
+func (replay *Replay) Frames() uint64 {
+	return atomic.LoadUint64(&replay.frames)
+}
+
+// Stops the replay with error unless it already stopped.
+func (replay *Replay) finish(error error) {
+	replay.lock.Lock()
+	defer replay.lock.Unlock()
+	replay.finishLocked(error)
+}
+
+// Requires lock to be held.
+func (replay *Replay) finishLocked(error error) {
+	select {
+	case <-replay.done:
+		return
+	default:
+	}
+	replay.err = error
+	replay.reader.Close()
+	close(replay.done)
+}
+
+// Returns the next frame, false once the replay has finished.
+func (replay *Replay) next() (RecordedFrame, bool) {
+	replay.lock.Lock()
+	defer replay.lock.Unlock()
+	select {
+	case <-replay.done:
+		return RecordedFrame{}, false
+	default:
+	}
+	frame, error := replay.reader.Next()
+	if error != nil {
+		if error == io.EOF {
+			error = nil
+		}
+		replay.finishLocked(error)
+		return frame, false
+	}
+	return frame, true
+}
+
+type replayTransport struct {
+	replay    *Replay
+	closed    chan struct{}
+	closeOnce sync.Once
+	// Recording and wall time of the first frame, see pace
+	first   time.Time
+	started time.Time
+}
+
+// Waits until frame_time is due at the speed of the replay, returns
+// false if the transport is closed meanwhile.
+func (transport *replayTransport) pace(frame_time time.Time) bool {
+	if transport.first.IsZero() {
+		transport.first, transport.started = frame_time, time.Now()
+	}
+	if transport.replay.speed == ReplayMaxSpeed {
+		return true
+	}
+	due := transport.started.Add(time.Duration(float64(frame_time.Sub(transport.first)) / transport.replay.speed))
+	wait := time.Until(due)
+	if wait <= 0 {
+		return true
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-transport.closed:
+		return false
+	}
+}
+
+// Returns the recorded frames in order once the replay is started and
+// then blocks like an idle connection until closed.
+func (transport *replayTransport) ReadFrame() ([]byte, error) {
+	replay := transport.replay
+	select {
+	case <-replay.started:
+	case <-transport.closed:
+		return nil, ErrClosed
+	}
+	frame, ok := replay.next()
+	if !ok || !transport.pace(frame.Time) {
+		<-transport.closed
+		return nil, ErrClosed
+	}
+	replay.clock.Set(frame.Time)
+	atomic.AddUint64(&replay.frames, 1)
+	return frame.Data, nil
+}
+
+func (transport *replayTransport) WriteFrame(frame []byte) error {
+	select {
+	case <-transport.closed:
+		return ErrClosed
+	default:
+		return nil
+	}
+}
+
+// Stops the replay with ErrClosed unless every frame was read.
+func (transport *replayTransport) Close() error {
+	transport.closeOnce.Do(func() {
+		close(transport.closed)
+		transport.replay.finish(ErrClosed)
+	})
+	return nil
+}
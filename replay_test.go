@@ -0,0 +1,220 @@
+package cexio
+
+import (
+	gocontext "context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Records a snapshot, two updates and a ticker of BTC:USD a second
+// apart, returns the session and the time of the first frame.
+func recordSession(t *testing.T) (string, time.Time) {
+	recorder, error := NewRecorder(RecordOptions{Dir: t.TempDir()})
+	if error != nil {
+		t.Fatal(error)
+	}
+	start := time.Unix(1600000000, 0)
+	for i, frame := range []string{
+		`{"e":"order-book-subscribe","oid":"1_order-book-subscribe","ok":"ok","data":{"pair":"BTC:USD","id":1,"bids":[["100","1"]],"asks":[["101","1"]]}}`,
+		`{"e":"md_update","data":{"pair":"BTC:USD","id":2,"bids":[["99","2"]],"asks":[]}}`,
+		`{"e":"md_update","data":{"pair":"BTC:USD","id":3,"bids":[],"asks":[["101","0"],["102","3"]]}}`,
+		`{"e":"ticker","oid":"2_ticker","ok":"ok","data":{"pair":["BTC","USD"],"last":"100.5","bid":"100","ask":"102"}}`,
+	} {
+		recorder.Write([]byte(frame), start.Add(time.Duration(i)*time.Second))
+	}
+	if error := recorder.Close(); error != nil {
+		t.Fatal(error)
+	}
+	return recorder.Session(), start
+}
+
+func TestReplay(t *testing.T) {
+	session, start := recordSession(t)
+	replay, error := NewReplay(session, ReplayMaxSpeed)
+	if error != nil {
+		t.Fatal(error)
+	}
+	context, error := NewContext(Options{Dial: replay.Dial, Clock: replay.Clock()})
+	if error != nil {
+		t.Fatal(error)
+	}
+	defer context.shutdown()
+	md := NewMarketDataAdapter(context)
+	books := make(chan BookEvent, 16)
+	md.OnBook("BTC:USD", func(event BookEvent) { books <- event })
+	replay.Start()
+
+	select {
+	case <-replay.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("Replay did not finish")
+	}
+	if replay.Err() != nil || replay.Frames() != 4 {
+		t.Fatalf("Unexpected replay result %v after %d frames", replay.Err(), replay.Frames())
+	}
+	if error := md.Sync(gocontext.Background()); error != nil {
+		t.Fatal(error)
+	}
+	if orderbook, _ := md.Book("BTC:USD"); orderbook.LastPrice != d("100.5") {
+		t.Fatalf("Last frame not applied after Sync %+v", orderbook)
+	}
+	for i := 0; i < 4; i++ {
+		event := receiveBook(t, books)
+		if !event.Time.Equal(start.Add(time.Duration(i) * time.Second)) {
+			t.Fatalf("Event %d at %s instead of the recorded time", i, event.Time)
+		}
+		if i < 3 && event.Book.Id != int32(i+1) {
+			t.Fatalf("Unexpected book %d", event.Book.Id)
+		}
+		if i == 3 {
+			best, _ := event.Book.Asks.Best()
+			if event.Book.LastPrice != d("100.5") || best.Price != d("102") || event.Book.Bids.Len() != 2 {
+				t.Fatalf("Unexpected replayed book %+v", event.Book)
+			}
+		}
+	}
+	if !context.now().Equal(start.Add(3 * time.Second)) {
+		t.Fatalf("Clock not at the last frame, %s", context.now())
+	}
+}
+
+func TestReplaySpeed(t *testing.T) {
+	session, start := recordSession(t)
+	replay, error := NewReplay(session, 20)
+	if error != nil {
+		t.Fatal(error)
+	}
+	if error := replay.Seek(start.Add(time.Second)); error != nil {
+		t.Fatal(error)
+	}
+	started := time.Now()
+	context, error := NewContext(Options{Dial: replay.Dial, Clock: replay.Clock()})
+	if error != nil {
+		t.Fatal(error)
+	}
+	defer context.shutdown()
+	NewMarketDataAdapter(context)
+	replay.Start()
+	<-replay.Done()
+	// Two seconds of recording at 20 times the speed
+	if elapsed := time.Since(started); elapsed < 100*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("Replay took %s", elapsed)
+	}
+	if replay.Frames() != 3 {
+		t.Fatalf("Expected 3 frames after seek, got %d", replay.Frames())
+	}
+
+	// Stopped before the end
+	replay, _ = NewReplay(session, ReplayWallClockSpeed)
+	context, error = NewContext(Options{Dial: replay.Dial, Clock: replay.Clock()})
+	if error != nil {
+		t.Fatal(error)
+	}
+	time.Sleep(50 * time.Millisecond)
+	context.shutdown()
+	if !errors.Is(replay.Err(), ErrClosed) {
+		t.Fatalf("Expected ErrClosed, got %v", replay.Err())
+	}
+}
+
+// Pushes at the start of a session reach the handlers of an adapter
+// created after the context dialed.
+func TestReplayHoldsFramesUntilStart(t *testing.T) {
+	recorder, error := NewRecorder(RecordOptions{Dir: t.TempDir()})
+	if error != nil {
+		t.Fatal(error)
+	}
+	start := time.Unix(1600000000, 0)
+	recorder.Write([]byte(`{"e":"tick","data":{"symbol1":"BTC","symbol2":"USD","price":"100.5","volume":"5"}}`), start)
+	if error := recorder.Close(); error != nil {
+		t.Fatal(error)
+	}
+	replay, error := NewReplay(recorder.Session(), ReplayMaxSpeed)
+	if error != nil {
+		t.Fatal(error)
+	}
+	context, error := NewContext(Options{Dial: replay.Dial, Clock: replay.Clock()})
+	if error != nil {
+		t.Fatal(error)
+	}
+	defer context.shutdown()
+	time.Sleep(50 * time.Millisecond)
+	if replay.Frames() != 0 {
+		t.Fatal("Frames replayed before Start")
+	}
+
+	md := NewMarketDataAdapter(context)
+	md.lock.Lock()
+	md.subscriptions["BTC:USD"] = 5
+	md.lock.Unlock()
+	tickers := make(chan TickerEvent, 1)
+	md.OnTicker("BTC:USD", func(event TickerEvent) { tickers <- event })
+	replay.Start()
+	select {
+	case event := <-tickers:
+		if event.Last != d("100.5") || !event.Time.Equal(start) {
+			t.Fatalf("Unexpected ticker %+v", event)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Tick push lost")
+	}
+}
+
+// Local candles of a replay are completed by the recorded time of the
+// frames, trades of a completed candle arriving late are ignored.
+func TestReplayLocalCandles(t *testing.T) {
+	recorder, error := NewRecorder(RecordOptions{Dir: t.TempDir()})
+	if error != nil {
+		t.Fatal(error)
+	}
+	start := time.Unix(1599999960, 0)
+	trades := func(rows ...string) string {
+		return `{"e":"history-update","pair":"BTC:USD","data":[` + strings.Join(rows, ",") + `]}`
+	}
+	trade := func(offset time.Duration, price string) string {
+		return `["buy","` + strconv.FormatInt(start.Add(offset).UnixNano()/int64(time.Millisecond), 10) + `","100000000","` + price + `","` +
+			strconv.FormatInt(int64(offset/time.Second), 10) + `"]`
+	}
+	for _, frame := range []struct {
+		offset time.Duration
+		data   string
+	}{
+		{0, trades(trade(0, "100"))},
+		{10 * time.Second, trades(trade(10*time.Second, "101"))},
+		{70 * time.Second, trades()},
+		{75 * time.Second, trades(trade(50*time.Second, "90"))},
+		{130 * time.Second, trades(trade(125*time.Second, "102"))},
+	} {
+		recorder.Write([]byte(frame.data), start.Add(frame.offset))
+	}
+	if error := recorder.Close(); error != nil {
+		t.Fatal(error)
+	}
+	replay, error := NewReplay(recorder.Session(), ReplayMaxSpeed)
+	if error != nil {
+		t.Fatal(error)
+	}
+	context, error := NewContext(Options{Dial: replay.Dial, Clock: replay.Clock()})
+	if error != nil {
+		t.Fatal(error)
+	}
+	defer context.shutdown()
+	md := NewMarketDataAdapter(context)
+	candles := make(chan Candle, 16)
+	md.OnCandle("", func(candle Candle) { candles <- candle })
+	md.AggregateCandles("BTC:USD", time.Minute)
+	replay.Start()
+
+	candle := receiveCandle(t, candles)
+	if !candle.Local || !candle.Time.Equal(start) || candle.Open != d("100") || candle.Close != d("101") || candle.Volume != d("2") {
+		t.Fatalf("Unexpected candle %+v", candle)
+	}
+	select {
+	case candle := <-candles:
+		t.Fatalf("Unexpected candle %+v", candle)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
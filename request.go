@@ -0,0 +1,384 @@
+package cexio
+
+import (
+	gocontext "context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Number of abandoned oids remembered to tell late replies apart
+// from replies that never belonged to any request.
+const kMaxExpiredOids = 1024
+
+var ErrRequestTimeout = errors.New("cexio: request timed out")
+
+// Returned for a request whose response is matched by type while
+// another one of the type is still waiting, e.g. concurrent auths.
+var ErrRequestPending = errors.New("cexio: request of the same type pending")
+
+// Outgoing request carrying an oid which the server echoes back
+// in the matching response.
+type Request struct {
+	Type string      `json:"e"`
+	Data interface{} `json:"data"`
+	Oid  string      `json:"oid"`
+}
+
+// Response envelope of a request/response exchange. Data is kept
+// raw so every call can decode it into its own result type.
+type Response struct {
+	Type string          `json:"e"`
+	Data json.RawMessage `json:"data"`
+	Oid  string          `json:"oid"`
+	Ok   string          `json:"ok"`
+	// Fields some pushes send outside of data
+	Pair     json.RawMessage `json:"pair"`
+	Interval string          `json:"i"`
+	// The whole message, for pushes with fields outside of data
+	Raw json.RawMessage `json:"-"`
+}
+
+// Decodes the envelope of an inbound frame once for the requests, the
+// push handlers and RecvChannel.
+func decodeResponse(frame []byte) (*Response, error) {
+	response := &Response{Raw: frame}
+	if error := json.Unmarshal(frame, response); error != nil {
+		return nil, error
+	}
+	return response, nil
+}
+
+// Pair the server sent outside of data, empty if none.
+func (response *Response) pair() string {
+	pair := ""
+	json.Unmarshal(response.Pair, &pair)
+	return pair
+}
+
+// Message of a response nobody consumed, only the data of which is
+// decoded again.
+func (response *Response) message() (*Message, error) {
+	message := &Message{Type: response.Type, Oid: response.Oid}
+	if len(response.Data) > 0 {
+		if error := json.Unmarshal(response.Data, &message.Data); error != nil {
+			return nil, error
+		}
+	}
+	return message, nil
+}
+
+// Error returned by the server for a request.
+type ResponseError struct {
+	Type    string
+	Oid     string
+	Message string
+}
+
+func (err *ResponseError) Error() string {
+	return err.Type + " failed: " + err.Message
+}
+
+// Counters of the request/response correlation.
+type RequestStats struct {
+	Sent      uint64 // Requests registered for a response
+	Completed uint64 // Responses with ok status
+	Failed    uint64 // Responses with error status
+	TimedOut  uint64 // Requests abandoned before a response arrived
+	Late      uint64 // Responses received after their request was abandoned
+	Unmatched uint64 // Responses with an oid nobody asked for
+}
+
+// Future is the pending result of a request. It completes when the
+// matching response arrives or the request expires.
+type Future struct {
+	Oid  string
+	Type string
+
+	owner    *Context
+	deadline time.Time
+	// Forwarded responses also go through RecvChannel, so the
+	// adapter still sees e.g. order-book-subscribe snapshots.
+	forward  bool
+	done     chan struct{}
+	response *Response
+	err      error
+}
+
+// Done is closed once the future has completed.
+func (future *Future) Done() <-chan struct{} {
+	return future.done
+}
+
+// Wait blocks until the response arrives or ctx is done. A request
+// abandoned this way is counted as timed out, a response arriving
+// later is counted as late and dropped.
+func (future *Future) Wait(ctx gocontext.Context) (*Response, error) {
+	select {
+	case <-future.done:
+		return future.response, future.err
+	case <-ctx.Done():
+		future.owner.expire(future, ErrRequestTimeout)
+		<-future.done
+		return future.response, future.err
+	}
+}
+
+// Result waits like Wait and decodes the response data into result.
+func (future *Future) Result(ctx gocontext.Context, result interface{}) error {
+	response, error := future.Wait(ctx)
+	if error != nil {
+		return error
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(response.Data, result)
+}
+
+func (future *Future) complete(response *Response, error error) {
+	future.response = response
+	future.err = error
+	close(future.done)
+}
+
+// Returns a future failed with error, for requests rejected before
+// they are sent.
+func (context *Context) failedFuture(request_type string, error error) *Future {
+	future := &Future{Type: request_type, owner: context, done: make(chan struct{})}
+	future.complete(nil, error)
+	return future
+}
+
+// Key under which requests are registered whose responses carry no oid.
+func typeKey(request_type string) string {
+	return "@" + request_type
+}
+
+func (context *Context) nextOid(request_type string) string {
+	counter := atomic.AddUint64(&context.oidCounter, 1)
+	return strconv.FormatInt(time.Now().UnixNano(), 10) + "_" + strconv.FormatUint(counter, 10) + "_" + request_type
+}
+
+// Registers a request under key which expires at the deadline of ctx,
+// or after REQUEST_TIMEOUT if ctx has none. Only one request can wait
+// on a type key.
+func (context *Context) register(ctx gocontext.Context, key, request_type string, forward bool) (*Future, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(REQUEST_TIMEOUT)
+	}
+	future := &Future{
+		Oid:      key,
+		Type:     request_type,
+		owner:    context,
+		deadline: deadline,
+		forward:  forward,
+		done:     make(chan struct{}),
+	}
+	context.pendingLock.Lock()
+	if _, ok := context.pending[key]; ok {
+		context.pendingLock.Unlock()
+		return nil, fmt.Errorf("%w: %s", ErrRequestPending, request_type)
+	}
+	context.pending[key] = future
+	context.pendingLock.Unlock()
+	atomic.AddUint64(&context.stats.Sent, 1)
+	return future, nil
+}
+
+// Completes future with error unless a response already did.
+func (context *Context) expire(future *Future, error error) {
+	context.pendingLock.Lock()
+	if context.pending[future.Oid] != future {
+		context.pendingLock.Unlock()
+		return
+	}
+	delete(context.pending, future.Oid)
+	context.expired[future.Oid] = struct{}{}
+	context.expiredOids = append(context.expiredOids, future.Oid)
+	if len(context.expiredOids) > kMaxExpiredOids {
+		delete(context.expired, context.expiredOids[0])
+		context.expiredOids = context.expiredOids[1:]
+	}
+	context.pendingLock.Unlock()
+	atomic.AddUint64(&context.stats.TimedOut, 1)
+	context.log().Warningf("Request %s expired: %s", future.Oid, error)
+	future.complete(nil, error)
+}
+
+// Hands a response to the request waiting on its oid, or on its type
+// for responses without oid, otherwise to the push handlers of its
+// type. Returns true if the message was consumed and must not go
+// through RecvChannel.
+func (context *Context) dispatchResponse(response *Response) bool {
+	if response.Type == "" {
+		return false
+	}
+	key := response.Oid
+	if key == "" {
+		key = typeKey(response.Type)
+	}
+
+	context.pendingLock.Lock()
+	future, ok := context.pending[key]
+	delete(context.pending, key)
+	_, late := context.expired[key]
+	context.pendingLock.Unlock()
+
+	if !ok {
+		if late {
+			atomic.AddUint64(&context.stats.Late, 1)
+			context.log().Warningf("Late response for %s", key)
+			return true
+		}
+		if response.Oid != "" {
+			atomic.AddUint64(&context.stats.Unmatched, 1)
+			context.log().Warningf("Unmatched response for %s", key)
+		}
+		return context.dispatchPush(response)
+	}
+
+	failure := struct {
+		Error string `json:"error"`
+	}{}
+	json.Unmarshal(response.Data, &failure)
+	if response.Ok == "error" || failure.Error != "" {
+		atomic.AddUint64(&context.stats.Failed, 1)
+		future.complete(response, &ResponseError{Type: response.Type, Oid: response.Oid, Message: failure.Error})
+	} else {
+		atomic.AddUint64(&context.stats.Completed, 1)
+		future.complete(response, nil)
+	}
+	return !future.forward
+}
+
+// Completes every pending request with error.
+func (context *Context) failPending(error error) {
+	context.pendingLock.Lock()
+	pending := context.pending
+	context.pending = make(map[string]*Future)
+	context.pendingLock.Unlock()
+	for _, future := range pending {
+		atomic.AddUint64(&context.stats.Failed, 1)
+		future.complete(nil, error)
+	}
+}
+
+// Registers handler for messages of push_type the server sends on its
+// own, e.g. balance changes. Handlers run on the reader goroutine.
+func (context *Context) onPush(push_type string, handler func(push *Response)) {
+	context.pushLock.Lock()
+	if context.pushHandlers == nil {
+		context.pushHandlers = make(map[string][]func(*Response))
+	}
+	context.pushHandlers[push_type] = append(context.pushHandlers[push_type], handler)
+	context.pushLock.Unlock()
+}
+
+// Returns false if nobody handles pushes of the response type.
+func (context *Context) dispatchPush(response *Response) bool {
+	context.pushLock.RLock()
+	handlers := context.pushHandlers[response.Type]
+	context.pushLock.RUnlock()
+	for _, handler := range handlers {
+		handler(response)
+	}
+	return len(handlers) > 0
+}
+
+// Expires requests nobody waits on once their deadline has passed.
+func runRequestExpiry(context *Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		var now time.Time
+		select {
+		case now = <-ticker.C:
+		case <-context.done:
+			return
+		}
+		expired := []*Future{}
+		context.pendingLock.Lock()
+		for _, future := range context.pending {
+			if now.After(future.deadline) {
+				expired = append(expired, future)
+			}
+		}
+		context.pendingLock.Unlock()
+		for _, future := range expired {
+			context.expire(future, ErrRequestTimeout)
+		}
+	}
+}
+
+// RequestStats returns a snapshot of the correlation counters.
+func (context *Context) RequestStats() RequestStats {
+	return RequestStats{
+		Sent:      atomic.LoadUint64(&context.stats.Sent),
+		Completed: atomic.LoadUint64(&context.stats.Completed),
+		Failed:    atomic.LoadUint64(&context.stats.Failed),
+		TimedOut:  atomic.LoadUint64(&context.stats.TimedOut),
+		Late:      atomic.LoadUint64(&context.stats.Late),
+		Unmatched: atomic.LoadUint64(&context.stats.Unmatched),
+	}
+}
+
+// Send sends a request with a fresh oid and returns its future. The
+// request expires after REQUEST_TIMEOUT, see SendContext.
+func (context *Context) Send(request_type string, data interface{}) (*Future, error) {
+	return context.SendContext(gocontext.Background(), request_type, data)
+}
+
+// SendContext is like Send, the request expires at the deadline of ctx
+// or after REQUEST_TIMEOUT if ctx has none. Orders and positions pass
+// the risk gate like those of PlaceOrder, CancelReplaceOrder and
+// OpenPosition.
+func (context *Context) SendContext(ctx gocontext.Context, request_type string, data interface{}) (*Future, error) {
+	if error := context.checkRequest(request_type, data); error != nil {
+		return nil, error
+	}
+	return context.sendUnchecked(ctx, request_type, data)
+}
+
+// Like SendContext without the risk gate, for requests checked already.
+func (context *Context) sendUnchecked(ctx gocontext.Context, request_type string, data interface{}) (*Future, error) {
+	request := Request{Type: request_type, Data: data, Oid: context.nextOid(request_type)}
+	json_string, error := json.Marshal(request)
+	if error != nil {
+		return nil, error
+	}
+	future, error := context.register(ctx, request.Oid, request_type, false)
+	if error != nil {
+		return nil, error
+	}
+	if error := context.sendJson(json_string); error != nil {
+		context.expire(future, error)
+		return nil, error
+	}
+	return future, nil
+}
+
+// Call sends a request and blocks until its response arrives or ctx
+// is done. Response data is decoded into result unless it is nil.
+func (context *Context) Call(ctx gocontext.Context, request_type string, data interface{}, result interface{}) error {
+	future, error := context.SendContext(ctx, request_type, data)
+	if error != nil {
+		return error
+	}
+	return future.Result(ctx, result)
+}
+
+// Like Call with a REQUEST_TIMEOUT deadline, without the risk gate.
+func (context *Context) request(request_type string, data interface{}, result interface{}) error {
+	ctx, cancel := gocontext.WithTimeout(gocontext.Background(), REQUEST_TIMEOUT)
+	defer cancel()
+	future, error := context.sendUnchecked(ctx, request_type, data)
+	if error != nil {
+		return error
+	}
+	return future.Result(ctx, result)
+}
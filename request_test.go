@@ -0,0 +1,128 @@
+package cexio
+
+import (
+	gocontext "context"
+	"errors"
+	"github.com/sahmad98/cex.io/cexiotest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRequestCorrelation(t *testing.T) {
+	var lock sync.Mutex
+	held := []cexiotest.Request{}
+	server := newHandledServer(t, func(request cexiotest.Request, conn *cexiotest.Conn) {
+		switch request.Type {
+		case "auth":
+			conn.Reply(map[string]string{"ok": "ok"})
+		case "order-book-subscribe":
+			conn.Reply(map[string]interface{}{"pair": "BTC:USD", "id": 1, "bids": [][]string{}, "asks": [][]string{}})
+		case "ticker":
+		case "get-order":
+			// Answered late by the next request
+			lock.Lock()
+			held = append(held, request)
+			lock.Unlock()
+		default:
+			conn.Reply(map[string]string{"oid": request.Oid})
+		}
+	})
+	context := newTestContext(t, server)
+
+	if error := context.Authenticate(); error != nil {
+		t.Fatalf("auth: %s", error)
+	}
+
+	md := newMarketDataAdapter(context)
+	future := md.Subscribe("BTC", "USD", 5)
+	ctx, cancel := gocontext.WithTimeout(gocontext.Background(), time.Second)
+	defer cancel()
+	if _, error := future.Wait(ctx); error != nil {
+		t.Fatalf("subscribe: %s", error)
+	}
+
+	// Requests are matched by oid, not by arrival order
+	first, _ := context.Send("open-orders", nil)
+	second, _ := context.Send("open-orders", nil)
+	result := map[string]string{}
+	if error := second.Result(ctx, &result); error != nil || result["oid"] != second.Oid {
+		t.Fatalf("Mismatched response %+v %v", result, error)
+	}
+	if error := first.Result(ctx, &result); error != nil || result["oid"] != first.Oid {
+		t.Fatalf("Mismatched response %+v %v", result, error)
+	}
+
+	short, short_cancel := gocontext.WithTimeout(gocontext.Background(), 20*time.Millisecond)
+	defer short_cancel()
+	if error := context.Call(short, "get-order", nil, nil); error != ErrRequestTimeout {
+		t.Fatalf("Expected timeout, got %v", error)
+	}
+
+	// Reply to the abandoned request and send one nobody asked for
+	lock.Lock()
+	late := held[0]
+	lock.Unlock()
+	for _, oid := range []string{late.Oid, "unknown"} {
+		response, error := decodeResponse([]byte(`{"e":"get-order","oid":"` + oid + `","ok":"ok","data":{}}`))
+		if error != nil {
+			t.Fatal(error)
+		}
+		context.dispatchResponse(response)
+	}
+
+	stats := context.RequestStats()
+	if stats.Sent != 5 || stats.Completed != 4 || stats.TimedOut != 1 || stats.Late != 1 || stats.Unmatched != 1 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestRequestDeadline(t *testing.T) {
+	context, _ := newPipeContext(t)
+	ctx, cancel := gocontext.WithTimeout(gocontext.Background(), time.Hour)
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+	future, error := context.register(ctx, "oid", "get-order", false)
+	if error != nil || !future.deadline.Equal(deadline) {
+		t.Fatalf("Expected the deadline of ctx, got %v %v", future, error)
+	}
+	future, error = context.register(gocontext.Background(), "other", "get-order", false)
+	if error != nil || time.Until(future.deadline) > REQUEST_TIMEOUT {
+		t.Fatalf("Expected a REQUEST_TIMEOUT deadline, got %v %v", future, error)
+	}
+
+	// A second request waiting on the same type fails, the first stays
+	first, _ := context.register(ctx, typeKey("auth"), "auth", true)
+	if _, error := context.register(ctx, typeKey("auth"), "auth", true); !errors.Is(error, ErrRequestPending) {
+		t.Fatalf("Expected ErrRequestPending, got %v", error)
+	}
+	select {
+	case <-first.Done():
+		t.Fatal("First request expired by the second")
+	default:
+	}
+}
+
+// A response after REQUEST_TIMEOUT but within the deadline of the
+// caller completes the call.
+func TestCallOutlivesRequestTimeout(t *testing.T) {
+	timeout := REQUEST_TIMEOUT
+	REQUEST_TIMEOUT = 10 * time.Millisecond
+	defer func() { REQUEST_TIMEOUT = timeout }()
+
+	context, servers := newPipeContext(t)
+	server := acceptPipe(t, servers)
+	ctx, cancel := gocontext.WithTimeout(gocontext.Background(), 5*time.Second)
+	defer cancel()
+	result := make(chan error, 1)
+	go func() {
+		result <- context.Call(ctx, "get-order", nil, nil)
+	}()
+	request := expectRequest(t, server, "get-order")
+	// Past a tick of the expiry goroutine
+	time.Sleep(1200 * time.Millisecond)
+	sendFrame(t, server, map[string]interface{}{"e": "get-order", "oid": request.Oid, "ok": "ok", "data": map[string]string{}})
+	if error := <-result; error != nil {
+		t.Fatalf("Call failed: %v", error)
+	}
+}
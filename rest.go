@@ -0,0 +1,348 @@
+package cexio
+
+import (
+	gocontext "context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Max size of a REST response read.
+const kMaxRestResponse = 16 << 20
+
+// RestError is an error reported by a REST endpoint, or an unexpected
+// HTTP status.
+type RestError struct {
+	Path    string
+	Status  int
+	Message string
+}
+
+func (err *RestError) Error() string {
+	return fmt.Sprintf("cexio: %s failed with status %d: %s", err.Path, err.Status, err.Message)
+}
+
+// Trading limits of a pair, see RestClient.CurrencyLimits.
+type CurrencyLimit struct {
+	Symbol1 string `json:"symbol1"`
+	Symbol2 string `json:"symbol2"`
+	// Amount limits in Symbol1, MinLotSizeS2 in Symbol2
+	MinLotSize   Decimal `json:"minLotSize"`
+	MinLotSizeS2 Decimal `json:"minLotSizeS2"`
+	MaxLotSize   Decimal `json:"maxLotSize"`
+	MinPrice     Decimal `json:"minPrice"`
+	MaxPrice     Decimal `json:"maxPrice"`
+	// Decimals of prices, nil if not limited
+	PricePrecision *int `json:"pricePrecision"`
+}
+
+// Precision of a currency, see RestClient.CurrencyProfiles.
+type CurrencyProfile struct {
+	Code string `json:"code"`
+	// Decimals of amounts of the currency
+	Precision int `json:"precision"`
+}
+
+// Fees of a pair in percent of the amount.
+type Fee struct {
+	Buy       Decimal `json:"buy"`
+	Sell      Decimal `json:"sell"`
+	BuyMaker  Decimal `json:"buyMaker"`
+	SellMaker Decimal `json:"sellMaker"`
+}
+
+type restTicker struct {
+	Timestamp json.Number `json:"timestamp"`
+	Pair      string      `json:"pair"`
+	Low       Decimal     `json:"low"`
+	High      Decimal     `json:"high"`
+	Last      Decimal     `json:"last"`
+	Volume    Decimal     `json:"volume"`
+	Bid       Decimal     `json:"bid"`
+	Ask       Decimal     `json:"ask"`
+}
+
+type restTrade struct {
+	Type   OrderType   `json:"type"`
+	Date   json.Number `json:"date"`
+	Amount Decimal     `json:"amount"`
+	Price  Decimal     `json:"price"`
+	Id     json.Number `json:"tid"`
+}
+
+// Candles of a day as JSON strings of arrays of time in seconds, open,
+// high, low, close and volume.
+type restOhlcv struct {
+	Data1m string `json:"data1m"`
+	Data1h string `json:"data1h"`
+	Data1d string `json:"data1d"`
+}
+
+// RestClient calls the REST API, for data the websocket does not
+// provide and as a fallback while it is down. Private requests are
+// signed like the auth request of a Context, with nonce, user id and
+// key as the message.
+type RestClient struct {
+	options Options
+	lock    sync.Mutex
+	nonce   int64
+}
+
+func NewRestClient(opts Options) (*RestClient, error) {
+	opts, error := opts.withDefaults()
+	if error != nil {
+		return nil, error
+	}
+	return &RestClient{options: opts}, nil
+}
+
+// Returns a nonce greater than every one returned before, as the
+// server rejects nonces not increasing per key.
+func (client *RestClient) nextNonce() int64 {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	nonce := time.Now().UnixNano() / int64(time.Millisecond)
+	if nonce <= client.nonce {
+		nonce = client.nonce + 1
+	}
+	client.nonce = nonce
+	return nonce
+}
+
+// Sends a GET request for path, or a signed POST with params if
+// private, and returns the body of a successful response.
+func (client *RestClient) call(ctx gocontext.Context, path string, params url.Values, private bool) ([]byte, error) {
+	address := strings.TrimSuffix(client.options.RestEndpoint, "/") + "/" + path
+	var request *http.Request
+	var error error
+	if private {
+		if params == nil {
+			params = url.Values{}
+		}
+		nonce := client.nextNonce()
+		params.Set("key", client.options.Key)
+		params.Set("nonce", strconv.FormatInt(nonce, 10))
+		params.Set("signature", strings.ToUpper(GenerateSignature(client.options.UserId+client.options.Key, client.options.Secret, nonce)))
+		request, error = http.NewRequest(http.MethodPost, address, strings.NewReader(params.Encode()))
+		if error == nil {
+			request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	} else {
+		if len(params) > 0 {
+			address += "?" + params.Encode()
+		}
+		request, error = http.NewRequest(http.MethodGet, address, nil)
+	}
+	if error != nil {
+		return nil, error
+	}
+	response, error := client.options.HTTPClient.Do(request.WithContext(ctx))
+	if error != nil {
+		return nil, error
+	}
+	defer response.Body.Close()
+	body, error := ioutil.ReadAll(io.LimitReader(response.Body, kMaxRestResponse))
+	if error != nil {
+		return nil, error
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, &RestError{Path: path, Status: response.StatusCode, Message: strings.TrimSpace(string(body))}
+	}
+	// Errors come as {"error": "..."} with status 200
+	if len(body) > 0 && body[0] == '{' {
+		failure := struct {
+			Error string `json:"error"`
+		}{}
+		if json.Unmarshal(body, &failure) == nil && failure.Error != "" {
+			return nil, &RestError{Path: path, Status: response.StatusCode, Message: failure.Error}
+		}
+	}
+	return body, nil
+}
+
+// Calls path and decodes the data of the {"e", "ok", "data"} envelope
+// into result.
+func (client *RestClient) callData(ctx gocontext.Context, path string, params url.Values, private bool, result interface{}) error {
+	body, error := client.call(ctx, path, params, private)
+	if error != nil {
+		return error
+	}
+	envelope := Response{}
+	if error := json.Unmarshal(body, &envelope); error != nil {
+		return error
+	}
+	if envelope.Ok != "ok" {
+		return &RestError{Path: path, Status: http.StatusOK, Message: string(envelope.Data)}
+	}
+	return json.Unmarshal(envelope.Data, result)
+}
+
+// CurrencyLimits returns the trading limits of every pair.
+func (client *RestClient) CurrencyLimits(ctx gocontext.Context) ([]CurrencyLimit, error) {
+	data := struct {
+		Pairs []CurrencyLimit `json:"pairs"`
+	}{}
+	if error := client.callData(ctx, "currency_limits", nil, false, &data); error != nil {
+		return nil, error
+	}
+	return data.Pairs, nil
+}
+
+// CurrencyProfiles returns the precision of every currency.
+func (client *RestClient) CurrencyProfiles(ctx gocontext.Context) ([]CurrencyProfile, error) {
+	data := struct {
+		Symbols []CurrencyProfile `json:"symbols"`
+	}{}
+	if error := client.callData(ctx, "currency_profile", nil, false, &data); error != nil {
+		return nil, error
+	}
+	return data.Symbols, nil
+}
+
+// Ticker returns the current ticker of sym1:sym2.
+func (client *RestClient) Ticker(ctx gocontext.Context, sym1, sym2 string) (TickerEvent, error) {
+	pair := sym1 + ":" + sym2
+	body, error := client.call(ctx, "ticker/"+sym1+"/"+sym2, nil, false)
+	if error != nil {
+		return TickerEvent{}, error
+	}
+	data := restTicker{}
+	if error := json.Unmarshal(body, &data); error != nil {
+		return TickerEvent{}, error
+	}
+	seconds, _ := data.Timestamp.Int64()
+	return TickerEvent{Pair: pair, Low: data.Low, High: data.High, Last: data.Last, Volume: data.Volume,
+		Bid: data.Bid, Ask: data.Ask, Time: time.Unix(seconds, 0)}, nil
+}
+
+// TradeHistory returns the recent trades of sym1:sym2 after the trade
+// with id since, or the latest ones if since is empty, oldest first.
+func (client *RestClient) TradeHistory(ctx gocontext.Context, sym1, sym2, since string) ([]TradeEvent, error) {
+	params := url.Values{}
+	if since != "" {
+		params.Set("since", since)
+	}
+	body, error := client.call(ctx, "trade_history/"+sym1+"/"+sym2+"/", params, false)
+	if error != nil {
+		return nil, error
+	}
+	data := []restTrade{}
+	if error := json.Unmarshal(body, &data); error != nil {
+		return nil, error
+	}
+	trades := make([]TradeEvent, 0, len(data))
+	for _, trade := range data {
+		seconds, error := trade.Date.Int64()
+		if error != nil {
+			return nil, fmt.Errorf("trade %s date: %w", trade.Id, error)
+		}
+		trades = append(trades, TradeEvent{Pair: sym1 + ":" + sym2, Id: trade.Id.String(), Type: trade.Type,
+			Price: trade.Price, Amount: trade.Amount, Time: time.Unix(seconds, 0)})
+	}
+	sortTrades(trades)
+	return trades, nil
+}
+
+// HistoricalCandles returns the 1m, 1h and 1d candles of sym1:sym2 of
+// the UTC day of day by interval.
+func (client *RestClient) HistoricalCandles(ctx gocontext.Context, sym1, sym2 string, day time.Time) (map[time.Duration][]Candle, error) {
+	path := "ohlcv/hd/" + day.UTC().Format("20060102") + "/" + sym1 + "/" + sym2
+	body, error := client.call(ctx, path, nil, false)
+	if error != nil {
+		return nil, error
+	}
+	data := restOhlcv{}
+	if error := json.Unmarshal(body, &data); error != nil {
+		return nil, error
+	}
+	candles := make(map[time.Duration][]Candle)
+	for interval, rows := range map[time.Duration]string{time.Minute: data.Data1m, time.Hour: data.Data1h, 24 * time.Hour: data.Data1d} {
+		if rows == "" {
+			continue
+		}
+		parsed, error := parseCandles(sym1+":"+sym2, interval, rows)
+		if error != nil {
+			return nil, fmt.Errorf("%s candles of %s: %w", candleIntervals[interval], path, error)
+		}
+		candles[interval] = parsed
+	}
+	return candles, nil
+}
+
+// Parses candles with the volume as a decimal amount, unlike the
+// candles pushed over the websocket.
+func parseCandles(pair string, interval time.Duration, rows string) ([]Candle, error) {
+	data := [][]json.Number{}
+	if error := json.Unmarshal([]byte(rows), &data); error != nil {
+		return nil, error
+	}
+	candles := make([]Candle, 0, len(data))
+	for _, row := range data {
+		if len(row) < 6 {
+			return nil, fmt.Errorf("candle of %d fields", len(row))
+		}
+		seconds, error := row[0].Int64()
+		if error != nil {
+			return nil, error
+		}
+		candle := Candle{Pair: pair, Interval: interval, Time: time.Unix(seconds, 0)}
+		for i, field := range []*Decimal{&candle.Open, &candle.High, &candle.Low, &candle.Close, &candle.Volume} {
+			if *field, error = ParseDecimal(row[i+1].String()); error != nil {
+				return nil, error
+			}
+		}
+		candles = append(candles, candle)
+	}
+	return candles, nil
+}
+
+// Balance returns the available and on-order balance by currency.
+func (client *RestClient) Balance(ctx gocontext.Context) (map[string]Balance, error) {
+	body, error := client.call(ctx, "balance/", nil, true)
+	if error != nil {
+		return nil, error
+	}
+	data := map[string]json.RawMessage{}
+	if error := json.Unmarshal(body, &data); error != nil {
+		return nil, error
+	}
+	balances := make(map[string]Balance)
+	for currency, value := range data {
+		balance := struct {
+			Available Decimal `json:"available"`
+			Orders    Decimal `json:"orders"`
+		}{}
+		// Skips timestamp and username
+		if json.Unmarshal(value, &balance) != nil {
+			continue
+		}
+		balances[currency] = Balance{Currency: currency, Available: balance.Available, OnOrder: balance.Orders}
+	}
+	return balances, nil
+}
+
+// Fees returns the fees of the account by pair like "BTC:USD".
+func (client *RestClient) Fees(ctx gocontext.Context) (map[string]Fee, error) {
+	fees := make(map[string]Fee)
+	if error := client.callData(ctx, "get_myfee", nil, true, &fees); error != nil {
+		return nil, error
+	}
+	return fees, nil
+}
+
+// Address returns the deposit address of the account for currency.
+func (client *RestClient) Address(ctx gocontext.Context, currency string) (string, error) {
+	address := ""
+	params := url.Values{"currency": {currency}}
+	if error := client.callData(ctx, "get_address", params, true, &address); error != nil {
+		return "", error
+	}
+	return address, nil
+}
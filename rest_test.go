@@ -0,0 +1,177 @@
+package cexio
+
+import (
+	gocontext "context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newRestClient(t *testing.T, handler http.HandlerFunc) (*RestClient, *httptest.Server) {
+	server := httptest.NewServer(handler)
+	client, error := NewRestClient(Options{Key: "key", Secret: "secret", UserId: "up123", RestEndpoint: server.URL + "/api",
+		HTTPClient: server.Client()})
+	if error != nil {
+		server.Close()
+		t.Fatal(error)
+	}
+	return client, server
+}
+
+func TestRestPublicRequests(t *testing.T) {
+	client, server := newRestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("Unexpected method %s", r.Method)
+		}
+		switch r.URL.Path {
+		case "/api/currency_limits":
+			w.Write([]byte(`{"e":"currency_limits","ok":"ok","data":{"pairs":[{"symbol1":"BTC","symbol2":"USD",
+				"minLotSize":0.01,"minLotSizeS2":2.5,"maxLotSize":30,"minPrice":"100","maxPrice":"35000"}]}}`))
+		case "/api/currency_profile":
+			w.Write([]byte(`{"e":"currency_profile","ok":"ok","data":{"symbols":[{"code":"BTC","name":"Bitcoin","precision":6,
+				"walletDeposit":true,"minimumCurrencyAmount":"0.00000001"},{"code":"USD","name":"US Dollar","precision":2}]}}`))
+		case "/api/trade_history/BTC/USD/":
+			if r.URL.Query().Get("since") != "2436541" {
+				t.Errorf("Unexpected query %q", r.URL.RawQuery)
+			}
+			w.Write([]byte(`[{"type":"sell","date":"1420224243","amount":"0.5","price":"313.5","tid":"2436543"},
+				{"type":"buy","date":"1420224242","amount":"0.01000000","price":"313.0001","tid":"2436542"}]`))
+		case "/api/ohlcv/hd/20160228/BTC/USD":
+			w.Write([]byte(`{"time":20160228,"data1m":"[[1456617600,434.3867,434.3867,433.781,433.781,4.15450000]]","data1d":"[[1456617600,434.3867,439,425,432,1234.5]]"}`))
+		case "/api/ticker/BTC/USD":
+			w.Write([]byte(`{"timestamp":"1513177918","low":"15000","high":"17000","last":"16500.5","volume":"1234.5","bid":16500,"ask":16501,"pair":"BTC:USD"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	defer server.Close()
+	ctx := gocontext.Background()
+
+	limits, error := client.CurrencyLimits(ctx)
+	if error != nil {
+		t.Fatal(error)
+	}
+	if len(limits) != 1 || limits[0].Symbol2 != "USD" || limits[0].MinLotSize != d("0.01") || limits[0].MaxPrice != d("35000") {
+		t.Fatalf("Unexpected limits %+v", limits)
+	}
+	registry, error := LoadPairRegistry(ctx, client)
+	if error != nil {
+		t.Fatal(error)
+	}
+	if btc, _ := registry.Lookup("BTC", "USD"); btc.LotStep != d("0.000001") || btc.MinLotSize != d("0.01") {
+		t.Fatalf("Unexpected pair %+v", btc)
+	}
+
+	trades, error := client.TradeHistory(ctx, "BTC", "USD", "2436541")
+	if error != nil {
+		t.Fatal(error)
+	}
+	if len(trades) != 2 || trades[0].Id != "2436542" || trades[0].Type != OrderBuy || trades[0].Amount != d("0.01") ||
+		trades[1].Price != d("313.5") || trades[1].Time.Unix() != 1420224243 {
+		t.Fatalf("Unexpected trades %+v", trades)
+	}
+
+	candles, error := client.HistoricalCandles(ctx, "BTC", "USD", time.Date(2016, 2, 28, 12, 0, 0, 0, time.UTC))
+	if error != nil {
+		t.Fatal(error)
+	}
+	minute := candles[time.Minute]
+	if len(candles) != 2 || len(minute) != 1 || minute[0].Open != d("434.3867") || minute[0].Volume != d("4.1545") ||
+		candles[24*time.Hour][0].High != d("439") {
+		t.Fatalf("Unexpected candles %+v", candles)
+	}
+
+	ticker, error := client.Ticker(ctx, "BTC", "USD")
+	if error != nil {
+		t.Fatal(error)
+	}
+	if ticker.Pair != "BTC:USD" || ticker.Last != d("16500.5") || ticker.Bid != d("16500") || ticker.Time.Unix() != 1513177918 {
+		t.Fatalf("Unexpected ticker %+v", ticker)
+	}
+
+	_, error = client.Ticker(ctx, "BTC", "XYZ")
+	rest_error := &RestError{}
+	if !errors.As(error, &rest_error) || rest_error.Status != http.StatusNotFound {
+		t.Fatalf("Expected RestError, got %v", error)
+	}
+}
+
+func TestRestPrivateRequests(t *testing.T) {
+	var lock sync.Mutex
+	nonces := []int64{}
+	client, server := newRestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.ParseForm() != nil {
+			t.Errorf("Unexpected request %s", r.Method)
+		}
+		nonce, _ := strconv.ParseInt(r.PostForm.Get("nonce"), 10, 64)
+		// HMAC-SHA256 of nonce, user id and key with the secret
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write([]byte(r.PostForm.Get("nonce") + "up123key"))
+		expected := strings.ToUpper(hex.EncodeToString(mac.Sum(nil)))
+		if r.PostForm.Get("key") != "key" || r.PostForm.Get("signature") != expected {
+			w.Write([]byte(`{"error":"Invalid Signature"}`))
+			return
+		}
+		lock.Lock()
+		nonces = append(nonces, nonce)
+		lock.Unlock()
+		switch r.URL.Path {
+		case "/api/balance/":
+			w.Write([]byte(`{"timestamp":"1513177918","username":"up123","BTC":{"available":"1.5","orders":"0.25"},"USD":{"available":"100"}}`))
+		case "/api/get_myfee":
+			w.Write([]byte(`{"e":"get_myfee","ok":"ok","data":{"BTC:USD":{"buy":"0.25","sell":"0.25","buyMaker":"0.16","sellMaker":"0.16"}}}`))
+		case "/api/get_address":
+			if r.PostForm.Get("currency") != "BTC" {
+				w.Write([]byte(`{"e":"get_address","ok":"error","data":"Unknown currency"}`))
+				return
+			}
+			w.Write([]byte(`{"e":"get_address","ok":"ok","data":"3JjBsS8yNC1sXmKoDHB5Ms2yyntq1xYtAb"}`))
+		}
+	})
+	defer server.Close()
+	ctx := gocontext.Background()
+
+	balances, error := client.Balance(ctx)
+	if error != nil {
+		t.Fatal(error)
+	}
+	if len(balances) != 2 || balances["BTC"].Available != d("1.5") || balances["BTC"].OnOrder != d("0.25") || balances["USD"].Currency != "USD" {
+		t.Fatalf("Unexpected balances %+v", balances)
+	}
+	fees, error := client.Fees(ctx)
+	if error != nil {
+		t.Fatal(error)
+	}
+	if fees["BTC:USD"].BuyMaker != d("0.16") {
+		t.Fatalf("Unexpected fees %+v", fees)
+	}
+	if address, error := client.Address(ctx, "BTC"); error != nil || address != "3JjBsS8yNC1sXmKoDHB5Ms2yyntq1xYtAb" {
+		t.Fatalf("Unexpected address %q, %v", address, error)
+	}
+	if _, error := client.Address(ctx, "XYZ"); error == nil {
+		t.Fatal("Expected error for unknown currency")
+	}
+
+	lock.Lock()
+	for i := 1; i < len(nonces); i++ {
+		if nonces[i] <= nonces[i-1] {
+			t.Fatalf("Nonces not increasing %v", nonces)
+		}
+	}
+	lock.Unlock()
+
+	client.options.Secret = "wrong"
+	_, error = client.Fees(ctx)
+	rest_error := &RestError{}
+	if !errors.As(error, &rest_error) || rest_error.Message != "Invalid Signature" {
+		t.Fatalf("Expected signature error, got %v", error)
+	}
+}
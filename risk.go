@@ -0,0 +1,317 @@
+package cexio
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	kRuleKillSwitch  = "kill_switch"
+	kRuleMaxNotional = "max_notional"
+	kRuleMaxPosition = "max_position"
+	kRulePriceCollar = "price_collar"
+	kRuleRateLimit   = "rate_limit"
+)
+
+var ErrRiskRejected = errors.New("cexio: order rejected by risk checks")
+
+// Order about to be sent, as seen by the risk checks.
+type RiskOrder struct {
+	Symbol1 string
+	Symbol2 string
+	Type    OrderType
+	Amount  Decimal
+	Price   Decimal
+}
+
+func (order RiskOrder) Pair() string {
+	return order.Symbol1 + ":" + order.Symbol2
+}
+
+// RiskError tells which rule rejected an order and why. It matches
+// ErrRiskRejected with errors.Is.
+type RiskError struct {
+	Rule   string
+	Order  RiskOrder
+	Reason string
+}
+
+func (err *RiskError) Error() string {
+	return fmt.Sprintf("cexio: %s %s %s at %s rejected by %s: %s",
+		err.Order.Type, err.Order.Amount, err.Order.Pair(), err.Order.Price, err.Rule, err.Reason)
+}
+
+func (err *RiskError) Is(target error) bool {
+	return target == ErrRiskRejected
+}
+
+// RiskRule returns an error describing why order must not be sent.
+type RiskRule func(order RiskOrder) error
+
+// Source of the orderbooks for the price collar, e.g. a
+// MarketDataAdapter.
+type BookSource interface {
+	Book(pair string) (Orderbook, bool)
+}
+
+// Limits of the RiskGate, zero values disable a check. Pairs are
+// written like "BTC:USD".
+type RiskLimits struct {
+	// Max price times amount per pair, in Symbol2
+	MaxNotional map[string]Decimal
+	// Max holdings of Symbol1 a buy may lead to, per pair, counting the
+	// available and on-order balances of the context
+	MaxPosition map[string]Decimal
+	// Max distance of the price beyond the best ask for buys, or below
+	// the best bid for sells, as a fraction like 0.05
+	PriceCollar Decimal
+	// Max orders per RateInterval, which defaults to a second
+	MaxOrders    int
+	RateInterval time.Duration
+	// Rejects every order until Resume is called
+	KillSwitch bool
+}
+
+func (limits RiskLimits) validate() error {
+	for pair, notional := range limits.MaxNotional {
+		if notional <= 0 {
+			return fmt.Errorf("max notional of %s is not positive", pair)
+		}
+	}
+	for pair, position := range limits.MaxPosition {
+		if position < 0 {
+			return fmt.Errorf("max position of %s is negative", pair)
+		}
+	}
+	if limits.PriceCollar < 0 || limits.MaxOrders < 0 || limits.RateInterval < 0 {
+		return errors.New("negative risk limit")
+	}
+	return nil
+}
+
+type riskRule struct {
+	name  string
+	check RiskRule
+}
+
+// RiskGate checks every order of a Context before it is sent. Rejected
+// orders fail with a *RiskError and are counted by rule.
+type RiskGate struct {
+	lock       sync.Mutex
+	limits     RiskLimits
+	killed     bool
+	rules      []riskRule
+	books      BookSource
+	balances   func() map[string]Balance
+	sent       []time.Time
+	rejections map[string]uint64
+}
+
+func NewRiskGate(limits RiskLimits) *RiskGate {
+	if limits.RateInterval == 0 {
+		limits.RateInterval = time.Second
+	}
+	return &RiskGate{limits: limits, killed: limits.KillSwitch, rejections: make(map[string]uint64)}
+}
+
+// AddRule adds a check run after the configured ones, rejections are
+// counted under name.
+func (gate *RiskGate) AddRule(name string, rule RiskRule) {
+	gate.lock.Lock()
+	gate.rules = append(gate.rules, riskRule{name, rule})
+	gate.lock.Unlock()
+}
+
+// UseBooks sets where the price collar takes the best bid and ask
+// from. Orders of pairs without a book are rejected by the collar.
+func (gate *RiskGate) UseBooks(books BookSource) {
+	gate.lock.Lock()
+	gate.books = books
+	gate.lock.Unlock()
+}
+
+// Sets books unless UseBooks was called.
+func (gate *RiskGate) defaultBooks(books BookSource) {
+	gate.lock.Lock()
+	if gate.books == nil {
+		gate.books = books
+	}
+	gate.lock.Unlock()
+}
+
+// Kill rejects every order until Resume is called.
+func (gate *RiskGate) Kill() {
+	gate.lock.Lock()
+	gate.killed = true
+	gate.lock.Unlock()
+}
+
+func (gate *RiskGate) Resume() {
+	gate.lock.Lock()
+	gate.killed = false
+	gate.lock.Unlock()
+}
+
+func (gate *RiskGate) Killed() bool {
+	gate.lock.Lock()
+	defer gate.lock.Unlock()
+	return gate.killed
+}
+
+// Rejections returns the number of rejected orders by rule.
+func (gate *RiskGate) Rejections() map[string]uint64 {
+	gate.lock.Lock()
+	defer gate.lock.Unlock()
+	rejections := make(map[string]uint64, len(gate.rejections))
+	for rule, count := range gate.rejections {
+		rejections[rule] = count
+	}
+	return rejections
+}
+
+// Check returns a *RiskError if order breaks a limit or a rule, else
+// counts it against the rate limit. Rules and the book source run
+// without the lock held, so they may call the gate.
+func (gate *RiskGate) Check(order RiskOrder) error {
+	gate.lock.Lock()
+	checks := riskChecks{gate.limits, gate.killed, gate.rules, gate.books, gate.balances}
+	gate.lock.Unlock()
+	rule, reason := checks.check(order)
+	gate.lock.Lock()
+	defer gate.lock.Unlock()
+	if rule == "" {
+		rule, reason = gate.checkRate()
+	}
+	if rule != "" {
+		gate.rejections[rule]++
+		return &RiskError{Rule: rule, Order: order, Reason: reason}
+	}
+	gate.sent = append(gate.sent, time.Now())
+	return nil
+}
+
+// Checks of a gate, copied so that they run without its lock.
+type riskChecks struct {
+	limits   RiskLimits
+	killed   bool
+	rules    []riskRule
+	books    BookSource
+	balances func() map[string]Balance
+}
+
+// Returns the rule rejecting order and why.
+func (checks riskChecks) check(order RiskOrder) (string, string) {
+	limits := &checks.limits
+	pair := order.Pair()
+	if checks.killed {
+		return kRuleKillSwitch, "kill switch engaged"
+	}
+	if max, ok := limits.MaxNotional[pair]; ok {
+		if notional := order.Amount.Mul(order.Price); notional > max {
+			return kRuleMaxNotional, fmt.Sprintf("notional %s exceeds %s", notional, max)
+		}
+	}
+	if max, ok := limits.MaxPosition[pair]; ok && order.Type == OrderBuy && checks.balances != nil {
+		balance := checks.balances()[order.Symbol1]
+		if position := balance.Available + balance.OnOrder + order.Amount; position > max {
+			return kRuleMaxPosition, fmt.Sprintf("position %s %s exceeds %s", position, order.Symbol1, max)
+		}
+	}
+	if limits.PriceCollar > 0 {
+		if reason := checks.checkCollar(order); reason != "" {
+			return kRulePriceCollar, reason
+		}
+	}
+	for _, rule := range checks.rules {
+		if error := rule.check(order); error != nil {
+			return rule.name, error.Error()
+		}
+	}
+	return "", ""
+}
+
+func (checks riskChecks) checkCollar(order RiskOrder) string {
+	if checks.books == nil {
+		return "no orderbook source"
+	}
+	orderbook, ok := checks.books.Book(order.Pair())
+	if !ok || orderbook.Stale {
+		return "no current orderbook"
+	}
+	if order.Type == OrderBuy {
+		best, ok := orderbook.Asks.Best()
+		if !ok {
+			return "no ask"
+		}
+		if limit := best.Price + best.Price.Mul(checks.limits.PriceCollar); order.Price > limit {
+			return fmt.Sprintf("price above %s", limit)
+		}
+		return ""
+	}
+	best, ok := orderbook.Bids.Best()
+	if !ok {
+		return "no bid"
+	}
+	if limit := best.Price - best.Price.Mul(checks.limits.PriceCollar); order.Price < limit {
+		return fmt.Sprintf("price below %s", limit)
+	}
+	return ""
+}
+
+// Rejects an order killed meanwhile or beyond the rate limit. Requires
+// lock to be held.
+func (gate *RiskGate) checkRate() (string, string) {
+	if gate.killed {
+		return kRuleKillSwitch, "kill switch engaged"
+	}
+	limits := &gate.limits
+	if limits.MaxOrders > 0 {
+		since := time.Now().Add(-limits.RateInterval)
+		for len(gate.sent) > 0 && gate.sent[0].Before(since) {
+			gate.sent = gate.sent[1:]
+		}
+		if len(gate.sent) >= limits.MaxOrders {
+			return kRuleRateLimit, fmt.Sprintf("more than %d orders in %s", limits.MaxOrders, limits.RateInterval)
+		}
+	}
+	return "", ""
+}
+
+// Fields of order and position requests read by the risk checks,
+// whatever the type of the request data.
+type riskRequest struct {
+	Pair         []string     `json:"pair"`
+	Type         OrderType    `json:"type"`
+	Amount       Decimal      `json:"amount"`
+	Price        Decimal      `json:"price"`
+	PositionType PositionType `json:"ptype"`
+	Leverage     Decimal      `json:"leverage"`
+	OpenPrice    Decimal      `json:"eoprice"`
+}
+
+// Checks the orders and positions sent with Send or Call, which are
+// rejected if they cannot be read.
+func (context *Context) checkRequest(request_type string, data interface{}) error {
+	if request_type != "place-order" && request_type != "cancel-replace-order" && request_type != "open-position" {
+		return nil
+	}
+	request := riskRequest{}
+	encoded, error := json.Marshal(data)
+	if error == nil {
+		error = json.Unmarshal(encoded, &request)
+	}
+	if error == nil && len(request.Pair) != 2 {
+		error = errors.New("no pair")
+	}
+	if error != nil {
+		return fmt.Errorf("%w: unreadable %s request: %s", ErrRiskRejected, request_type, error)
+	}
+	if request_type == "open-position" {
+		order := positionRiskOrder(request.Pair[0], request.Pair[1], request.PositionType, request.Amount, request.Leverage, request.OpenPrice)
+		return context.risk.Check(order)
+	}
+	return context.risk.Check(RiskOrder{request.Pair[0], request.Pair[1], request.Type, request.Amount, request.Price})
+}
@@ -0,0 +1,189 @@
+package cexio
+
+import (
+	gocontext "context"
+	"errors"
+	"github.com/sahmad98/cex.io/cexiotest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type staticBooks map[string]Orderbook
+
+func (books staticBooks) Book(pair string) (Orderbook, bool) {
+	orderbook, ok := books[pair]
+	return orderbook, ok
+}
+
+func TestRiskGate(t *testing.T) {
+	orderbook := Orderbook{Pair: "BTC:USD"}
+	orderbook.initalize(5)
+	orderbook.updateLevel(d("100"), d("1"), kBuy)
+	orderbook.updateLevel(d("101"), d("1"), kSell)
+
+	gate := NewRiskGate(RiskLimits{
+		MaxNotional:  map[string]Decimal{"BTC:USD": d("1000")},
+		MaxPosition:  map[string]Decimal{"BTC:USD": d("5")},
+		PriceCollar:  d("0.05"),
+		MaxOrders:    3,
+		RateInterval: time.Hour,
+	})
+	gate.UseBooks(staticBooks{"BTC:USD": orderbook})
+	gate.balances = func() map[string]Balance {
+		return map[string]Balance{"BTC": {Currency: "BTC", Available: d("3"), OnOrder: d("1")}}
+	}
+	for _, test := range []struct {
+		order RiskOrder
+		rule  string
+	}{
+		{RiskOrder{"BTC", "USD", OrderBuy, d("0.5"), d("102")}, ""},
+		{RiskOrder{"BTC", "USD", OrderBuy, d("11"), d("100")}, kRuleMaxNotional},
+		{RiskOrder{"BTC", "USD", OrderBuy, d("2"), d("100")}, kRuleMaxPosition},
+		{RiskOrder{"BTC", "USD", OrderSell, d("2"), d("100")}, ""},
+		{RiskOrder{"BTC", "USD", OrderBuy, d("0.5"), d("107")}, kRulePriceCollar},
+		{RiskOrder{"BTC", "USD", OrderSell, d("0.5"), d("94")}, kRulePriceCollar},
+		{RiskOrder{"BTC", "EUR", OrderSell, d("0.5"), d("94")}, kRulePriceCollar},
+		{RiskOrder{"ETH", "USD", OrderSell, d("0.5"), d("94")}, kRulePriceCollar},
+		{RiskOrder{"BTC", "USD", OrderSell, d("0.5"), d("96")}, ""},
+		{RiskOrder{"BTC", "USD", OrderSell, d("0.5"), d("96")}, kRuleRateLimit},
+	} {
+		error := gate.Check(test.order)
+		if test.rule == "" {
+			if error != nil {
+				t.Fatalf("Unexpected rejection of %+v: %s", test.order, error)
+			}
+			continue
+		}
+		risk_error, ok := error.(*RiskError)
+		if !ok || risk_error.Rule != test.rule || !errors.Is(error, ErrRiskRejected) {
+			t.Fatalf("Expected %s rejection of %+v, got %v", test.rule, test.order, error)
+		}
+	}
+
+	gate.Kill()
+	if error := gate.Check(RiskOrder{"BTC", "USD", OrderSell, d("0.5"), d("100")}); error.(*RiskError).Rule != kRuleKillSwitch {
+		t.Fatalf("Expected kill switch, got %v", error)
+	}
+	rejections := gate.Rejections()
+	if rejections[kRulePriceCollar] != 4 || rejections[kRuleMaxNotional] != 1 || rejections[kRuleKillSwitch] != 1 {
+		t.Fatalf("Unexpected rejections %v", rejections)
+	}
+}
+
+func TestRiskGateCustomRule(t *testing.T) {
+	gate := NewRiskGate(RiskLimits{})
+	gate.AddRule("no_eth", func(order RiskOrder) error {
+		if order.Symbol1 == "ETH" {
+			return errors.New("ETH not traded")
+		}
+		return nil
+	})
+	error := gate.Check(RiskOrder{"ETH", "USD", OrderBuy, d("1"), d("100")})
+	if risk_error, ok := error.(*RiskError); !ok || risk_error.Rule != "no_eth" || risk_error.Reason != "ETH not traded" {
+		t.Fatalf("Unexpected error %v", error)
+	}
+	if error := gate.Check(RiskOrder{"BTC", "USD", OrderBuy, d("1"), d("100")}); error != nil {
+		t.Fatal(error)
+	}
+}
+
+func TestRiskRuleCallsGate(t *testing.T) {
+	gate := NewRiskGate(RiskLimits{})
+	gate.AddRule("kill_large", func(order RiskOrder) error {
+		if gate.Killed() || gate.Rejections()["kill_large"] > 0 {
+			return errors.New("unexpected gate state")
+		}
+		if order.Amount > d("10") {
+			gate.Kill()
+		}
+		return nil
+	})
+	checked := make(chan error, 1)
+	go func() { checked <- gate.Check(RiskOrder{"BTC", "USD", OrderBuy, d("20"), d("100")}) }()
+	select {
+	case error := <-checked:
+		// Killed while checked
+		if risk_error, ok := error.(*RiskError); !ok || risk_error.Rule != kRuleKillSwitch {
+			t.Fatalf("Unexpected error %v", error)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Check deadlocked")
+	}
+	if rejections := gate.Rejections(); rejections[kRuleKillSwitch] != 1 {
+		t.Fatalf("Unexpected rejections %v", rejections)
+	}
+}
+
+func TestRejectedOrderNotSent(t *testing.T) {
+	var sent int32
+	server := newHandledServer(t, func(request cexiotest.Request, conn *cexiotest.Conn) {
+		atomic.AddInt32(&sent, 1)
+		conn.Reply(map[string]interface{}{"id": "1", "type": "buy", "price": "100", "amount": "1", "pending": "1"})
+	})
+	context, error := NewContext(Options{Endpoint: server.URL, Risk: RiskLimits{KillSwitch: true}})
+	if error != nil {
+		t.Fatal(error)
+	}
+	defer context.closeConnection()
+
+	if _, error := context.PlaceOrder("BTC", "USD", OrderBuy, d("1"), d("100")); !errors.Is(error, ErrRiskRejected) {
+		t.Fatalf("Expected risk rejection, got %v", error)
+	}
+	context.Risk().Resume()
+	if _, error := context.PlaceOrder("BTC", "USD", OrderBuy, d("1"), d("100")); error != nil {
+		t.Fatal(error)
+	}
+	if atomic.LoadInt32(&sent) != 1 {
+		t.Fatalf("Expected only the accepted order to be sent, got %d", sent)
+	}
+}
+
+// Positions and orders sent with Send or Call pass the gate as well.
+func TestRiskGatePositionsAndRawRequests(t *testing.T) {
+	var sent int32
+	server := newHandledServer(t, func(request cexiotest.Request, conn *cexiotest.Conn) {
+		atomic.AddInt32(&sent, 1)
+		conn.Reply(map[string]interface{}{"id": "1"})
+	})
+	limits := RiskLimits{MaxNotional: map[string]Decimal{"BTC:USD": d("150")}}
+	context, error := NewContext(Options{Endpoint: server.URL, Risk: limits})
+	if error != nil {
+		t.Fatal(error)
+	}
+	defer context.closeConnection()
+
+	// 1 BTC at leverage 2 is a notional of 200 USD
+	if _, error := context.OpenPosition("BTC", "USD", PositionLong, d("1"), 2, d("100"), d("90")); !errors.Is(error, ErrRiskRejected) {
+		t.Fatalf("Expected risk rejection of long position, got %v", error)
+	}
+	// Short positions are sized in USD
+	if _, error := context.OpenPosition("BTC", "USD", PositionShort, d("100"), 2, d("100"), d("110")); !errors.Is(error, ErrRiskRejected) {
+		t.Fatalf("Expected risk rejection of short position, got %v", error)
+	}
+	if _, error := context.OpenPosition("BTC", "USD", PositionLong, d("0.5"), 2, d("100"), d("90")); error != nil {
+		t.Fatal(error)
+	}
+
+	order := map[string]interface{}{"pair": []string{"BTC", "USD"}, "type": "buy", "amount": "2", "price": "100"}
+	if _, error := context.Send("place-order", order); !errors.Is(error, ErrRiskRejected) {
+		t.Fatalf("Expected risk rejection of sent order, got %v", error)
+	}
+	position := map[string]interface{}{"pair": []string{"BTC", "USD"}, "symbol": "BTC", "amount": 1, "leverage": "3", "ptype": "long", "eoprice": "100"}
+	if error := context.Call(gocontext.Background(), "open-position", position, nil); !errors.Is(error, ErrRiskRejected) {
+		t.Fatalf("Expected risk rejection of called position, got %v", error)
+	}
+	if _, error := context.Send("cancel-replace-order", nil); !errors.Is(error, ErrRiskRejected) {
+		t.Fatalf("Expected unreadable order to be rejected, got %v", error)
+	}
+	order["amount"] = "1"
+	if error := context.Call(gocontext.Background(), "place-order", order, nil); error != nil {
+		t.Fatal(error)
+	}
+	if atomic.LoadInt32(&sent) != 2 {
+		t.Fatalf("Expected only the accepted requests to be sent, got %d", sent)
+	}
+	if rejections := context.Risk().Rejections(); rejections[kRuleMaxNotional] != 4 {
+		t.Fatalf("Unexpected rejections %v", rejections)
+	}
+}
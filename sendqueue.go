@@ -0,0 +1,220 @@
+package cexio
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Priority of an outgoing request, lower values are sent first.
+type Priority int
+
+const (
+	// Pongs, cancels and auth
+	PriorityControl Priority = iota
+	// Orders and every other request
+	PriorityOrder
+	// Subscriptions and ticker polls
+	PrioritySubscription
+	kNumPriorities
+)
+
+var controlRequests = map[string]bool{
+	"pong":          true,
+	"auth":          true,
+	"cancel-order":  true,
+	"cancel-orders": true,
+}
+
+var subscriptionRequests = map[string]bool{
+	"order-book-subscribe":   true,
+	"order-book-unsubscribe": true,
+	"subscribe":              true,
+	"ticker":                 true,
+	"init-ohlcv":             true,
+}
+
+// Counters of the send path, see Context.SendStats.
+type SendStats struct {
+	// Requests waiting to be written by priority
+	Queued [kNumPriorities]int
+	// Requests written by priority
+	Sent [kNumPriorities]uint64
+	// Number of waits for the rate limit and their total duration
+	Throttled     uint64
+	ThrottledTime time.Duration
+	// Ticker polls dropped because the same poll was already queued
+	Coalesced uint64
+}
+
+// Orders encoded requests by priority and hands them out at the rate
+// of a token bucket, see Options.SendRate.
+type sendQueue struct {
+	lock     sync.Mutex
+	queues   [kNumPriorities][][]byte
+	size     int
+	limit    int
+	rate     float64
+	burst    float64
+	tokens   float64
+	last     time.Time
+	coalesce bool
+	stats    SendStats
+}
+
+func newSendQueue(opts Options) *sendQueue {
+	queue := &sendQueue{limit: opts.SendQueueSize, rate: opts.SendRate, burst: float64(opts.SendBurst), coalesce: opts.CoalesceTickers}
+	if queue.burst < 1 {
+		queue.burst = 1
+	}
+	queue.tokens = queue.burst
+	queue.last = time.Now()
+	return queue
+}
+
+func classify(request []byte) (Priority, string) {
+	header := struct {
+		Type string `json:"e"`
+	}{}
+	json.Unmarshal(request, &header)
+	if controlRequests[header.Type] {
+		return PriorityControl, header.Type
+	}
+	if subscriptionRequests[header.Type] {
+		return PrioritySubscription, header.Type
+	}
+	return PriorityOrder, header.Type
+}
+
+func (queue *sendQueue) full() bool {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	return queue.size >= queue.limit
+}
+
+// Queues request, the nil flush marker goes last so that it is handed
+// out once everything queued before it has been.
+func (queue *sendQueue) push(request []byte) {
+	priority, request_type := PrioritySubscription, ""
+	if request != nil {
+		priority, request_type = classify(request)
+	}
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	if queue.coalesce && request_type == "ticker" {
+		for _, queued := range queue.queues[priority] {
+			if bytes.Equal(queued, request) {
+				queue.stats.Coalesced++
+				return
+			}
+		}
+	}
+	queue.queues[priority] = append(queue.queues[priority], request)
+	queue.size++
+}
+
+// Returns the next request, or how long to wait for a token if it is
+// rate limited. Returns false if nothing is queued.
+func (queue *sendQueue) pop() ([]byte, time.Duration, bool) {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	for priority := range queue.queues {
+		if len(queue.queues[priority]) == 0 {
+			continue
+		}
+		request := queue.queues[priority][0]
+		if request != nil && queue.rate > 0 {
+			now := time.Now()
+			queue.tokens += now.Sub(queue.last).Seconds() * queue.rate
+			if queue.tokens > queue.burst {
+				queue.tokens = queue.burst
+			}
+			queue.last = now
+			if queue.tokens < 1 {
+				return nil, time.Duration((1 - queue.tokens) / queue.rate * float64(time.Second)), true
+			}
+			queue.tokens--
+		}
+		queue.queues[priority][0] = nil
+		queue.queues[priority] = queue.queues[priority][1:]
+		queue.size--
+		if request != nil {
+			queue.stats.Sent[priority]++
+		}
+		return request, 0, true
+	}
+	return nil, 0, false
+}
+
+func (queue *sendQueue) throttled(waited time.Duration) {
+	queue.lock.Lock()
+	queue.stats.Throttled++
+	queue.stats.ThrottledTime += waited
+	queue.lock.Unlock()
+}
+
+func (queue *sendQueue) snapshot() SendStats {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	stats := queue.stats
+	for priority := range queue.queues {
+		stats.Queued[priority] = len(queue.queues[priority])
+	}
+	return stats
+}
+
+// SendStats returns the depth of the send queues and the counters of
+// the rate limiter.
+func (context *Context) SendStats() SendStats {
+	return context.sendQueue.snapshot()
+}
+
+// Returns the next request to write, taking requests off the
+// SendJsonChannel meanwhile so that urgent ones overtake the others.
+// Returns false once the context is done.
+func (context *Context) nextRequest() ([]byte, bool) {
+	queue := context.sendQueue
+	for {
+		for !queue.full() {
+			select {
+			case request := <-context.SendJsonChannel:
+				queue.push(request)
+				continue
+			default:
+			}
+			break
+		}
+		request, wait, ok := queue.pop()
+		if !ok {
+			select {
+			case request := <-context.SendJsonChannel:
+				queue.push(request)
+				continue
+			case <-context.done:
+				return nil, false
+			}
+		}
+		if wait == 0 {
+			return request, true
+		}
+		// Requests are left in the channel while the queue is full
+		incoming := context.SendJsonChannel
+		if queue.full() {
+			incoming = nil
+		}
+		started := time.Now()
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case request := <-incoming:
+			// Might be more urgent than the throttled one
+			timer.Stop()
+			queue.push(request)
+		case <-context.done:
+			timer.Stop()
+			return nil, false
+		}
+		queue.throttled(time.Since(started))
+	}
+}
@@ -0,0 +1,130 @@
+package cexio
+
+import (
+	"github.com/sahmad98/cex.io/cexiotest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSendQueuePriorities(t *testing.T) {
+	queue := newSendQueue(Options{SendQueueSize: 16, CoalesceTickers: true})
+	ticker := []byte(`{"e":"ticker","data":["BTC","USD"]}`)
+	for _, request := range []string{
+		`{"e":"order-book-subscribe","data":{"pair":["BTC","USD"]},"oid":"1"}`,
+		string(ticker),
+		`{"e":"place-order","data":{},"oid":"2"}`,
+		string(ticker),
+		`{"e":"cancel-order","data":{},"oid":"3"}`,
+		`{"e":"pong"}`,
+	} {
+		queue.push([]byte(request))
+	}
+	queue.push(nil)
+
+	expected := []string{"cancel-order", "pong", "place-order", "order-book-subscribe", "ticker", ""}
+	for _, request_type := range expected {
+		request, wait, ok := queue.pop()
+		if !ok || wait != 0 {
+			t.Fatalf("Unexpected pop %v %v", wait, ok)
+		}
+		if _, popped := classify(request); popped != request_type || (request_type == "" && request != nil) {
+			t.Fatalf("Expected %q, got %s", request_type, request)
+		}
+	}
+	if _, _, ok := queue.pop(); ok {
+		t.Fatal("Queue not empty")
+	}
+	stats := queue.snapshot()
+	if stats.Coalesced != 1 || stats.Sent[PriorityControl] != 2 || stats.Sent[PrioritySubscription] != 2 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestSendQueueTokenBucket(t *testing.T) {
+	queue := newSendQueue(Options{SendQueueSize: 16, SendRate: 10, SendBurst: 2})
+	for i := 0; i < 3; i++ {
+		queue.push([]byte(`{"e":"place-order"}`))
+	}
+	for i := 0; i < 2; i++ {
+		if _, wait, _ := queue.pop(); wait != 0 {
+			t.Fatalf("Burst throttled after %d requests", i)
+		}
+	}
+	_, wait, ok := queue.pop()
+	if !ok || wait <= 0 || wait > 100*time.Millisecond {
+		t.Fatalf("Expected wait for a token, got %v", wait)
+	}
+	if queue.snapshot().Queued[PriorityOrder] != 1 {
+		t.Fatal("Throttled request dequeued")
+	}
+}
+
+func TestSendRateLimit(t *testing.T) {
+	var lock sync.Mutex
+	received := []time.Time{}
+	server := newHandledServer(t, func(cexiotest.Request, *cexiotest.Conn) {
+		lock.Lock()
+		received = append(received, time.Now())
+		lock.Unlock()
+	})
+	context, error := NewContext(Options{Endpoint: server.URL, SendRate: 50, SendBurst: 1})
+	if error != nil {
+		t.Fatal(error)
+	}
+	defer context.closeConnection()
+
+	for i := 0; i < 5; i++ {
+		if _, error := context.Send("get-balance", nil); error != nil {
+			t.Fatal(error)
+		}
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		lock.Lock()
+		count := len(received)
+		lock.Unlock()
+		if count == 5 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Received %d requests", count)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if elapsed := received[4].Sub(received[0]); elapsed < 70*time.Millisecond {
+		t.Fatalf("5 requests within %s at 50 per second", elapsed)
+	}
+	if stats := context.SendStats(); stats.Throttled == 0 || stats.Sent[PriorityOrder] != 5 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestSendQueueFullWhileThrottled(t *testing.T) {
+	done := make(chan struct{})
+	context := &Context{
+		sendQueue:       newSendQueue(Options{SendQueueSize: 2, SendRate: 1, SendBurst: 1}),
+		SendJsonChannel: make(chan []byte, 8),
+		done:            done,
+	}
+	context.SendJsonChannel <- []byte(`{"e":"place-order"}`)
+	if _, ok := context.nextRequest(); !ok {
+		t.Fatal("No request")
+	}
+	for i := 0; i < 6; i++ {
+		context.SendJsonChannel <- []byte(`{"e":"place-order"}`)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		context.nextRequest()
+		close(stopped)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	close(done)
+	<-stopped
+	if queued := context.sendQueue.snapshot().Queued[PriorityOrder]; queued != 2 || len(context.SendJsonChannel) != 4 {
+		t.Fatalf("Expected 2 queued and 4 in the channel, got %d and %d", queued, len(context.SendJsonChannel))
+	}
+}
@@ -0,0 +1,223 @@
+package cexio
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SubscriberOptions configure a MulticastSubscriber.
+type SubscriberOptions struct {
+	// Group address of the publisher, see MulticastOptions.Group
+	Group string
+	// Name of the network interface joining the group, the system
+	// default if empty
+	Interface string
+	// Address of the retransmission service of the publisher, gaps are
+	// not recovered if empty
+	RetransmitAddress string
+	// Called with the packets of every pair in sequence, payload is
+	// only valid during the call
+	Handler func(header PacketHeader, payload []byte)
+	// Called with the sequences of pair that were lost and could not
+	// be recovered
+	GapHandler func(pair string, from, to uint64)
+}
+
+// Counters of a MulticastSubscriber.
+type SubscriberStats struct {
+	Packets uint64
+	// Packets delivered from the retransmission service
+	Recovered uint64
+	// Packets neither received nor recovered
+	Lost       uint64
+	Duplicates uint64
+	Invalid    uint64
+}
+
+// Sequence expected next of a pair.
+type subscriberState struct {
+	session uint64
+	next    uint64
+}
+
+// MulticastSubscriber receives the packets of a multicast publisher,
+// see Options.Multicast. It delivers the packets of each pair in
+// sequence and fetches the packets it missed from the retransmission
+// service before delivering the packet after a gap.
+type MulticastSubscriber struct {
+	options SubscriberOptions
+	conn    *net.UDPConn
+	// Only used by the receiving goroutine
+	states  map[string]*subscriberState
+	stats   SubscriberStats
+	stopped sync.WaitGroup
+}
+
+// NewMulticastSubscriber joins opts.Group and starts receiving, until
+// Close is called.
+func NewMulticastSubscriber(opts SubscriberOptions) (*MulticastSubscriber, error) {
+	if opts.Handler == nil {
+		return nil, errors.New("cexio: subscriber without handler")
+	}
+	if opts.GapHandler == nil {
+		opts.GapHandler = func(pair string, from, to uint64) {}
+	}
+	group, error := net.ResolveUDPAddr("udp4", opts.Group)
+	if error != nil {
+		return nil, error
+	}
+	var conn *net.UDPConn
+	if group.IP.IsMulticast() {
+		var iface *net.Interface
+		if opts.Interface != "" {
+			if iface, error = net.InterfaceByName(opts.Interface); error != nil {
+				return nil, error
+			}
+		}
+		conn, error = net.ListenMulticastUDP("udp4", iface, group)
+	} else {
+		conn, error = net.ListenUDP("udp4", group)
+	}
+	if error != nil {
+		return nil, error
+	}
+	subscriber := &MulticastSubscriber{options: opts, conn: conn, states: make(map[string]*subscriberState)}
+	subscriber.stopped.Add(1)
+	go subscriber.receive()
+	return subscriber, nil
+}
+
+// Addr returns the address the subscriber listens on.
+func (subscriber *MulticastSubscriber) Addr() net.Addr {
+	return subscriber.conn.LocalAddr()
+}
+
+func (subscriber *MulticastSubscriber) Stats() SubscriberStats {
+	return SubscriberStats{
+		Packets:    atomic.LoadUint64(&subscriber.stats.Packets),
+		Recovered:  atomic.LoadUint64(&subscriber.stats.Recovered),
+		Lost:       atomic.LoadUint64(&subscriber.stats.Lost),
+		Duplicates: atomic.LoadUint64(&subscriber.stats.Duplicates),
+		Invalid:    atomic.LoadUint64(&subscriber.stats.Invalid),
+	}
+}
+
+// Close leaves the group and waits until the handlers returned.
+func (subscriber *MulticastSubscriber) Close() error {
+	error := subscriber.conn.Close()
+	subscriber.stopped.Wait()
+	return error
+}
+
+func (subscriber *MulticastSubscriber) receive() {
+	defer subscriber.stopped.Done()
+	buffer := make([]byte, kMaxPacketSize)
+	for {
+		size, _, error := subscriber.conn.ReadFromUDP(buffer)
+		if error != nil {
+			if errors.Is(error, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		header, payload, error := DecodePacket(buffer[:size])
+		if error != nil {
+			atomic.AddUint64(&subscriber.stats.Invalid, 1)
+			continue
+		}
+		subscriber.handle(header, payload)
+	}
+}
+
+func (subscriber *MulticastSubscriber) handle(header PacketHeader, payload []byte) {
+	state, ok := subscriber.states[header.Pair]
+	// The first packet of a pair, or of a restarted publisher, starts
+	// the sequence
+	if !ok || state.session != header.Session {
+		state = &subscriberState{session: header.Session, next: header.Sequence}
+		subscriber.states[header.Pair] = state
+	}
+	if header.Sequence < state.next {
+		atomic.AddUint64(&subscriber.stats.Duplicates, 1)
+		return
+	}
+	if header.Sequence > state.next {
+		subscriber.recover(header.Pair, state, header.Sequence-1)
+	}
+	subscriber.deliver(state, header, payload)
+}
+
+func (subscriber *MulticastSubscriber) deliver(state *subscriberState, header PacketHeader, payload []byte) {
+	state.next = header.Sequence + 1
+	atomic.AddUint64(&subscriber.stats.Packets, 1)
+	subscriber.options.Handler(header, payload)
+}
+
+// Delivers the packets from state.next to to fetched from the
+// retransmission service, reporting those it no longer has.
+func (subscriber *MulticastSubscriber) recover(pair string, state *subscriberState, to uint64) {
+	packets, _ := subscriber.fetch(RetransmitRequest{Session: state.session, Pair: pair, From: state.next, To: to})
+	for _, packet := range packets {
+		header, payload, error := DecodePacket(packet)
+		if error != nil || header.Pair != pair || header.Session != state.session || header.Sequence < state.next || header.Sequence > to {
+			continue
+		}
+		if header.Sequence > state.next {
+			subscriber.lost(pair, state.next, header.Sequence-1)
+		}
+		atomic.AddUint64(&subscriber.stats.Recovered, 1)
+		subscriber.deliver(state, header, payload)
+	}
+	if state.next <= to {
+		subscriber.lost(pair, state.next, to)
+		state.next = to + 1
+	}
+}
+
+func (subscriber *MulticastSubscriber) lost(pair string, from, to uint64) {
+	atomic.AddUint64(&subscriber.stats.Lost, to-from+1)
+	subscriber.options.GapHandler(pair, from, to)
+}
+
+// Requests packets from the retransmission service, returns those
+// received before it failed.
+func (subscriber *MulticastSubscriber) fetch(request RetransmitRequest) ([][]byte, error) {
+	if subscriber.options.RetransmitAddress == "" {
+		return nil, nil
+	}
+	conn, error := net.DialTimeout("tcp", subscriber.options.RetransmitAddress, kRetransmitTimeout)
+	if error != nil {
+		return nil, error
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(kRetransmitTimeout))
+	line, _ := json.Marshal(request)
+	if _, error := conn.Write(append(line, '\n')); error != nil {
+		return nil, error
+	}
+	packets := [][]byte{}
+	length := make([]byte, 4)
+	for {
+		if _, error := io.ReadFull(conn, length); error != nil {
+			if error == io.EOF {
+				error = nil
+			}
+			return packets, error
+		}
+		size := binary.BigEndian.Uint32(length)
+		if size > kMaxPacketSize {
+			return packets, ErrInvalidPacket
+		}
+		packet := make([]byte, size)
+		if _, error := io.ReadFull(conn, packet); error != nil {
+			return packets, error
+		}
+		packets = append(packets, packet)
+	}
+}
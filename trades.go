@@ -0,0 +1,230 @@
+package cexio
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/google/flatbuffers/go"
+	buffer "github.com/sahmad98/cex.io/types"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Pushes of the pair-X-Y room, see Subscribe. history carries the
+// recent trades when the room is joined, history-update the following
+// ones.
+const (
+	kHistoryPush       = "history"
+	kHistoryUpdatePush = "history-update"
+)
+
+// Number of trade ids remembered per pair to drop duplicates.
+const kTradeIdWindow = 1024
+
+// File identifier telling trades apart from orderbooks on the UDP
+// channel, see schema/trade.fbs.
+const kTradeIdentifier = "TRAD"
+
+// Called when the trades of pair between last and next may have been
+// missed, e.g. after a reconnect. next is zero if the trades of pair
+// after last are dropped from now on, see OnTrade.
+type TradeGapFunc func(pair string, last, next TradeEvent)
+
+// Pairs whose trade updates are dropped from now on, as several pair
+// rooms are joined, put on the update channel.
+type tradeLoss []string
+
+// Trades of a history or history-update push, put on the update
+// channel.
+type tradeBatch struct {
+	pair     string
+	trades   []TradeEvent
+	history  bool
+	received time.Time
+}
+
+// Trades seen of a pair, only used by the update goroutine.
+type tradeHistory struct {
+	seen map[string]bool
+	// Ids in seen, oldest first
+	ids  []string
+	last TradeEvent
+}
+
+func (history *tradeHistory) remember(trade TradeEvent) {
+	history.seen[trade.Id] = true
+	history.ids = append(history.ids, trade.Id)
+	if len(history.ids) > kTradeIdWindow {
+		delete(history.seen, history.ids[0])
+		history.ids = history.ids[1:]
+	}
+	if !trade.Time.Before(history.last.Time) {
+		history.last = trade
+	}
+}
+
+// Parses the fields type, time in milliseconds, amount in the smallest
+// unit of the currency, price and id of a trade.
+func parseTrade(pair string, fields []string) (TradeEvent, error) {
+	trade := TradeEvent{Pair: pair}
+	if len(fields) < 5 {
+		return trade, fmt.Errorf("trade of %d fields", len(fields))
+	}
+	trade.Type = OrderType(fields[0])
+	if trade.Type != OrderBuy && trade.Type != OrderSell {
+		return trade, fmt.Errorf("trade type %q", fields[0])
+	}
+	millis, error := strconv.ParseInt(fields[1], 10, 64)
+	if error != nil {
+		return trade, error
+	}
+	trade.Time = time.Unix(0, millis*int64(time.Millisecond))
+	if trade.Amount, error = parseVolume(json.Number(fields[2])); error != nil {
+		return trade, error
+	}
+	if trade.Price, error = ParseDecimal(fields[3]); error != nil {
+		return trade, error
+	}
+	trade.Id = fields[4]
+	return trade, nil
+}
+
+// Orders trades by time, then by id.
+func sortTrades(trades []TradeEvent) {
+	sort.SliceStable(trades, func(i, j int) bool {
+		if !trades[i].Time.Equal(trades[j].Time) {
+			return trades[i].Time.Before(trades[j].Time)
+		}
+		first, first_error := strconv.ParseInt(trades[i].Id, 10, 64)
+		second, second_error := strconv.ParseInt(trades[j].Id, 10, 64)
+		if first_error == nil && second_error == nil {
+			return first < second
+		}
+		return trades[i].Id < trades[j].Id
+	})
+}
+
+// Pair of a trade push, which only carries one if the server sends it
+// outside of data. Otherwise a history push belongs to the oldest room
+// join not answered yet, and a history-update push to the single pair
+// room joined, see joinRooms. Also tells if several pair rooms are
+// joined.
+func (md *MarketDataAdapter) tradePair(push *Response) (string, bool) {
+	md.lock.Lock()
+	defer md.lock.Unlock()
+	pair := push.pair()
+	if push.Type == kHistoryPush && len(md.historyRooms) > 0 {
+		if pair == "" {
+			pair = md.historyRooms[0]
+		}
+		md.historyRooms = md.historyRooms[1:]
+	}
+	if pair == "" && len(md.tradeRooms) == 1 {
+		pair = md.tradeRooms[0]
+	}
+	return pair, len(md.tradeRooms) > 1
+}
+
+func (md *MarketDataAdapter) putTrades(push *Response, rows [][]string) {
+	pair, shared := md.tradePair(push)
+	if pair == "" {
+		// Reported once by handleTradeLoss
+		if push.Type != kHistoryUpdatePush || !shared {
+			md.Context.log().Warningf("Dropping %s of unknown pair", push.Type)
+		}
+		return
+	}
+	batch := &tradeBatch{pair: pair, history: push.Type == kHistoryPush, received: md.Context.now()}
+	for _, row := range rows {
+		trade, error := parseTrade(pair, row)
+		if error != nil {
+			md.Context.log().Errorf("Unable to parse %s trade %v: %s", push.Type, row, error)
+			continue
+		}
+		batch.trades = append(batch.trades, trade)
+	}
+	sortTrades(batch.trades)
+	md.UpdateChannel.Put(batch)
+}
+
+// Trades of history pushes are strings like
+// "buy:1457703218519:41140000:423.7125:735480".
+func (md *MarketDataAdapter) handleHistoryPush(push *Response) {
+	data := []string{}
+	if error := json.Unmarshal(push.Data, &data); error != nil {
+		md.Context.log().Errorf("Unable to parse history: %s", error)
+		return
+	}
+	rows := make([][]string, 0, len(data))
+	for _, trade := range data {
+		rows = append(rows, strings.Split(trade, ":"))
+	}
+	md.putTrades(push, rows)
+}
+
+func (md *MarketDataAdapter) handleHistoryUpdatePush(push *Response) {
+	rows := [][]string{}
+	if error := json.Unmarshal(push.Data, &rows); error != nil {
+		md.Context.log().Errorf("Unable to parse history-update: %s", error)
+		return
+	}
+	md.putTrades(push, rows)
+}
+
+// Publishes the trades of batch not seen before. A history that does
+// not reach back to the last trade seen of the pair is reported to the
+// TradeGapHandler.
+func (md *MarketDataAdapter) handleTrades(batch *tradeBatch) {
+	history := md.tradeHistories[batch.pair]
+	if history == nil {
+		history = &tradeHistory{seen: make(map[string]bool)}
+		md.tradeHistories[batch.pair] = history
+	}
+	if batch.history && history.last.Id != "" && len(batch.trades) > 0 {
+		first := batch.trades[0]
+		if !history.seen[first.Id] && first.Time.After(history.last.Time) {
+			md.Context.log().Warningf("Trade gap of %s between %s and %s", batch.pair, history.last.Id, first.Id)
+			md.TradeGapHandler(batch.pair, history.last, first)
+		}
+	}
+	for _, trade := range batch.trades {
+		if history.seen[trade.Id] {
+			continue
+		}
+		history.remember(trade)
+		md.publish(kTopicTrade, trade.Pair, trade)
+	}
+}
+
+// Reports the trade updates of pairs dropped from now on to the
+// TradeGapHandler, with a zero next trade.
+func (md *MarketDataAdapter) handleTradeLoss(pairs tradeLoss) {
+	md.Context.log().Errorf("Trade updates of %s cannot be told apart, only their histories are delivered", strings.Join(pairs, " and "))
+	for _, pair := range pairs {
+		last := TradeEvent{}
+		if history := md.tradeHistories[pair]; history != nil {
+			last = history.last
+		}
+		md.TradeGapHandler(pair, last, TradeEvent{})
+	}
+}
+
+func (trade *TradeEvent) getBuffer() []byte {
+	builder := flatbuffers.NewBuilder(128)
+	pair := builder.CreateString(trade.Pair)
+	id := builder.CreateString(trade.Id)
+	side := buffer.SideBuy
+	if trade.Type == OrderSell {
+		side = buffer.SideSell
+	}
+	buffer.TradeStart(builder)
+	buffer.TradeAddPair(builder, pair)
+	buffer.TradeAddId(builder, id)
+	buffer.TradeAddSide(builder, side)
+	buffer.TradeAddTime(builder, trade.Time.UnixNano()/int64(time.Millisecond))
+	buffer.TradeAddPrice(builder, int64(trade.Price))
+	buffer.TradeAddAmount(builder, int64(trade.Amount))
+	builder.FinishWithFileIdentifier(buffer.TradeEnd(builder), []byte(kTradeIdentifier))
+	return builder.FinishedBytes()
+}
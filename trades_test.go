@@ -0,0 +1,154 @@
+package cexio
+
+import (
+	"github.com/sahmad98/cex.io/cexiotest"
+	buffer "github.com/sahmad98/cex.io/types"
+	"testing"
+	"time"
+)
+
+func receiveTrade(t *testing.T, trades chan TradeEvent) TradeEvent {
+	select {
+	case trade := <-trades:
+		return trade
+	case <-time.After(2 * time.Second):
+		t.Fatal("No trade received")
+	}
+	return TradeEvent{}
+}
+
+func TestTradeHistory(t *testing.T) {
+	server := newFakeExchange(t)
+	server.Handle("subscribe", func(request cexiotest.Request, conn *cexiotest.Conn) {
+		// Newest first
+		conn.Send(map[string]interface{}{"e": "history", "data": []string{
+			"sell:1457703218600:20000000:423.5:735481",
+			"buy:1457703218519:41140000:423.7125:735480"}})
+		conn.Send(map[string]interface{}{"e": "history-update", "data": [][]string{
+			{"buy", "1457703219000", "100000000", "424", "735482"},
+			{"sell", "1457703218600", "20000000", "423.5", "735481"}}})
+	})
+	context := newTestContext(t, server)
+	md := NewMarketDataAdapter(context)
+	trades := make(chan TradeEvent, 16)
+	md.OnTrade("BTC:USD", func(trade TradeEvent) { trades <- trade })
+	md.Subscribe("BTC", "USD", 5)
+
+	first := receiveTrade(t, trades)
+	if first.Id != "735480" || first.Type != OrderBuy || first.Price != d("423.7125") || first.Amount != d("0.4114") ||
+		first.Time.UnixNano() != 1457703218519*int64(time.Millisecond) {
+		t.Fatalf("Unexpected trade %+v", first)
+	}
+	if second := receiveTrade(t, trades); second.Id != "735481" || second.Type != OrderSell {
+		t.Fatalf("Unexpected trade %+v", second)
+	}
+	if third := receiveTrade(t, trades); third.Id != "735482" || third.Amount != d("1") {
+		t.Fatalf("Unexpected trade %+v", third)
+	}
+	select {
+	case trade := <-trades:
+		t.Fatalf("Duplicate trade %+v", trade)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestTradeGap(t *testing.T) {
+	md := newMarketDataAdapter(&Context{SendChannel: make(chan Message, 16)})
+	gaps := make(chan [2]string, 4)
+	md.TradeGapHandler = func(pair string, last, next TradeEvent) { gaps <- [2]string{last.Id, next.Id} }
+	start := time.Unix(1457703218, 0)
+	trade := func(id string, offset time.Duration) TradeEvent {
+		return TradeEvent{Pair: "BTC:USD", Id: id, Type: OrderBuy, Price: d("100"), Amount: d("1"), Time: start.Add(offset)}
+	}
+
+	md.handleTrades(&tradeBatch{pair: "BTC:USD", history: true, trades: []TradeEvent{trade("1", 0), trade("2", time.Second)}})
+	// Overlapping history after a reconnect
+	md.handleTrades(&tradeBatch{pair: "BTC:USD", history: true, trades: []TradeEvent{trade("2", time.Second), trade("3", 2*time.Second)}})
+	select {
+	case gap := <-gaps:
+		t.Fatalf("Unexpected gap %v", gap)
+	default:
+	}
+	md.handleTrades(&tradeBatch{pair: "BTC:USD", history: true, trades: []TradeEvent{trade("7", time.Minute), trade("8", time.Minute)}})
+	select {
+	case gap := <-gaps:
+		if gap != [2]string{"3", "7"} {
+			t.Fatalf("Unexpected gap %v", gap)
+		}
+	default:
+		t.Fatal("Gap not detected")
+	}
+}
+
+func TestTradeBuffer(t *testing.T) {
+	trade := TradeEvent{Pair: "BTC:USD", Id: "735480", Type: OrderSell, Price: d("423.7125"), Amount: d("0.4114"),
+		Time: time.Unix(0, 1457703218519*int64(time.Millisecond))}
+	data := trade.getBuffer()
+	if string(data[4:8]) != "TRAD" {
+		t.Fatalf("Unexpected file identifier %q", data[4:8])
+	}
+	decoded := buffer.GetRootAsTrade(data, 0)
+	if string(decoded.Pair()) != "BTC:USD" || string(decoded.Id()) != "735480" || decoded.Side() != buffer.SideSell ||
+		decoded.Time() != 1457703218519 || Decimal(decoded.Price()) != d("423.7125") || Decimal(decoded.Amount()) != d("0.4114") {
+		t.Fatalf("Unexpected buffer contents")
+	}
+}
+
+// Histories are told apart by the order the pair rooms were joined in,
+// updates are dropped and reported as gaps once more than one pair
+// room is joined.
+func TestTradeHistoryOfTwoPairs(t *testing.T) {
+	joins := 0
+	server := newHandledServer(t, func(request cexiotest.Request, conn *cexiotest.Conn) {
+		if request.Type != "subscribe" {
+			return
+		}
+		joins++
+		if joins == 1 {
+			conn.Send(map[string]interface{}{"e": "history", "data": []string{"buy:1457703218519:41140000:423.7125:735480"}})
+			return
+		}
+		conn.Send(map[string]interface{}{"e": "history", "data": []string{"sell:1457703218600:200000000:0.0213:735481"}})
+		conn.Send(map[string]interface{}{"e": "history-update", "data": [][]string{{"buy", "1457703219000", "100000000", "424", "735482"}}})
+		// Pairs sent by the server are used as is
+		conn.Send(map[string]interface{}{"e": "history-update", "pair": "BTC:USD", "data": [][]string{{"sell", "1457703219500", "100000000", "423", "735483"}}})
+	})
+	context := newTestContext(t, server)
+	md := NewMarketDataAdapter(context)
+	gaps := make(chan [2]TradeEvent, 4)
+	md.TradeGapHandler = func(pair string, last, next TradeEvent) {
+		gaps <- [2]TradeEvent{{Pair: pair, Id: last.Id}, next}
+	}
+	trades := make(chan TradeEvent, 16)
+	md.OnTrade("", func(trade TradeEvent) { trades <- trade })
+	md.Subscribe("BTC", "USD", 5)
+	if first := receiveTrade(t, trades); first.Id != "735480" || first.Pair != "BTC:USD" {
+		t.Fatalf("Unexpected trade %+v", first)
+	}
+	md.Subscribe("ETH", "BTC", 5)
+	// Both pairs lose their updates once
+	for _, expected := range []string{"BTC:USD", "ETH:BTC"} {
+		select {
+		case gap := <-gaps:
+			if gap[0].Pair != expected || gap[1] != (TradeEvent{}) || (expected == "BTC:USD" && gap[0].Id != "735480") {
+				t.Fatalf("Unexpected gap %+v", gap)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("No gap reported for %s", expected)
+		}
+	}
+
+	if second := receiveTrade(t, trades); second.Id != "735481" || second.Pair != "ETH:BTC" || second.Amount != d("2") {
+		t.Fatalf("Unexpected trade %+v", second)
+	}
+	if third := receiveTrade(t, trades); third.Id != "735483" || third.Pair != "BTC:USD" {
+		t.Fatalf("Unexpected trade %+v", third)
+	}
+	select {
+	case trade := <-trades:
+		t.Fatalf("Unattributable trade delivered %+v", trade)
+	case gap := <-gaps:
+		t.Fatalf("Unexpected gap %+v", gap)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,178 @@
+package cexio
+
+import (
+	"time"
+)
+
+type OrderType string
+
+const (
+	OrderBuy  OrderType = "buy"
+	OrderSell OrderType = "sell"
+)
+
+// Result of place-order and cancel-replace-order requests.
+type PlacedOrder struct {
+	Id       string    `json:"id"`
+	Time     int64     `json:"time"`
+	Complete bool      `json:"complete"`
+	Type     OrderType `json:"type"`
+	Price    Decimal   `json:"price"`
+	Amount   Decimal   `json:"amount"`
+	Pending  Decimal   `json:"pending"`
+}
+
+// Result of cancel-order request.
+type CancelledOrder struct {
+	OrderId string  `json:"order_id"`
+	Remains Decimal `json:"fremains"`
+}
+
+// Order details returned by get-order request.
+type Order struct {
+	Id      string    `json:"orderId"`
+	Type    OrderType `json:"type"`
+	Symbol1 string    `json:"symbol1"`
+	Symbol2 string    `json:"symbol2"`
+	Amount  Decimal   `json:"amount"`
+	Remains Decimal   `json:"remains"`
+	Price   Decimal   `json:"price"`
+	Time    int64     `json:"time"`
+	Status  string    `json:"status"`
+}
+
+// Entry of open-orders response.
+type OpenOrder struct {
+	Id      string    `json:"id"`
+	Time    int64     `json:"time,string"`
+	Type    OrderType `json:"type"`
+	Price   Decimal   `json:"price"`
+	Amount  Decimal   `json:"amount"`
+	Pending Decimal   `json:"pending"`
+}
+
+// Entry of archived-orders response.
+type ArchivedOrder struct {
+	Id      string    `json:"orderId"`
+	Type    OrderType `json:"type"`
+	Symbol1 string    `json:"symbol1"`
+	Symbol2 string    `json:"symbol2"`
+	Amount  Decimal   `json:"amount"`
+	Remains Decimal   `json:"remains"`
+	Price   Decimal   `json:"price"`
+	Time    time.Time `json:"time"`
+	Status  string    `json:"status"`
+}
+
+type orderRequest struct {
+	OrderId string    `json:"order_id,omitempty"`
+	Pair    []string  `json:"pair"`
+	Amount  Decimal   `json:"amount"`
+	Price   string    `json:"price"`
+	Type    OrderType `json:"type"`
+}
+
+type orderIdRequest struct {
+	OrderId string `json:"order_id"`
+}
+
+type pairRequest struct {
+	Pair []string `json:"pair"`
+}
+
+type archivedOrdersRequest struct {
+	Pair     []string `json:"pair"`
+	Limit    int      `json:"limit,omitempty"`
+	DateFrom int64    `json:"dateFrom,omitempty"`
+	DateTo   int64    `json:"dateTo,omitempty"`
+}
+
+// PlaceOrder places a limit order of amount sym1 at price sym2. With
+// Options.Pairs set, amount and price are rounded to the increments of
+// the pair and checked against its limits. The order is not sent if
+// the risk gate rejects it, see Risk.
+func (context *Context) PlaceOrder(sym1, sym2 string, order_type OrderType, amount, price Decimal) (*PlacedOrder, error) {
+	amount, price, error := context.normalizeOrder(sym1, sym2, amount, price)
+	if error != nil {
+		return nil, error
+	}
+	if error := context.risk.Check(RiskOrder{sym1, sym2, order_type, amount, price}); error != nil {
+		return nil, error
+	}
+	request := orderRequest{
+		Pair:   []string{sym1, sym2},
+		Amount: amount,
+		Price:  price.String(),
+		Type:   order_type,
+	}
+	order := &PlacedOrder{}
+	if error := context.request("place-order", request, order); error != nil {
+		return nil, error
+	}
+	return order, nil
+}
+
+func (context *Context) CancelOrder(order_id string) (*CancelledOrder, error) {
+	cancelled := &CancelledOrder{}
+	if error := context.request("cancel-order", orderIdRequest{order_id}, cancelled); error != nil {
+		return nil, error
+	}
+	return cancelled, nil
+}
+
+// CancelReplaceOrder atomically cancels order_id and places a new
+// order in its place.
+func (context *Context) CancelReplaceOrder(order_id, sym1, sym2 string, order_type OrderType, amount, price Decimal) (*PlacedOrder, error) {
+	amount, price, error := context.normalizeOrder(sym1, sym2, amount, price)
+	if error != nil {
+		return nil, error
+	}
+	if error := context.risk.Check(RiskOrder{sym1, sym2, order_type, amount, price}); error != nil {
+		return nil, error
+	}
+	request := orderRequest{
+		OrderId: order_id,
+		Pair:    []string{sym1, sym2},
+		Amount:  amount,
+		Price:   price.String(),
+		Type:    order_type,
+	}
+	order := &PlacedOrder{}
+	if error := context.request("cancel-replace-order", request, order); error != nil {
+		return nil, error
+	}
+	return order, nil
+}
+
+func (context *Context) GetOrder(order_id string) (*Order, error) {
+	order := &Order{}
+	if error := context.request("get-order", orderIdRequest{order_id}, order); error != nil {
+		return nil, error
+	}
+	return order, nil
+}
+
+func (context *Context) OpenOrders(sym1, sym2 string) ([]OpenOrder, error) {
+	orders := []OpenOrder{}
+	if error := context.request("open-orders", pairRequest{[]string{sym1, sym2}}, &orders); error != nil {
+		return nil, error
+	}
+	return orders, nil
+}
+
+// ArchivedOrders returns at most limit completed or cancelled orders
+// placed between from and to. Zero values leave the bound unset.
+func (context *Context) ArchivedOrders(sym1, sym2 string, limit int, from, to time.Time) ([]ArchivedOrder, error) {
+	request := archivedOrdersRequest{Pair: []string{sym1, sym2}, Limit: limit}
+	if !from.IsZero() {
+		request.DateFrom = from.Unix()
+	}
+	if !to.IsZero() {
+		request.DateTo = to.Unix()
+	}
+	orders := []ArchivedOrder{}
+	if error := context.request("archived-orders", request, &orders); error != nil {
+		return nil, error
+	}
+	return orders, nil
+}
@@ -0,0 +1,115 @@
+package cexio
+
+import (
+	"encoding/json"
+	"github.com/sahmad98/cex.io/cexiotest"
+	"testing"
+	"time"
+)
+
+// Starts a fake exchange answering every request with handler.
+func newHandledServer(t *testing.T, handler cexiotest.Handler) *cexiotest.Server {
+	server := cexiotest.NewServer()
+	t.Cleanup(server.Close)
+	server.Handle("", handler)
+	return server
+}
+
+func newTestContext(t *testing.T, server *cexiotest.Server) *Context {
+	context, error := NewContext(Options{Endpoint: server.URL})
+	if error != nil {
+		t.Fatal(error)
+	}
+	t.Cleanup(context.closeConnection)
+	return context
+}
+
+func TestPlaceOrder(t *testing.T) {
+	server := newHandledServer(t, func(request cexiotest.Request, conn *cexiotest.Conn) {
+		order := orderRequest{}
+		json.Unmarshal(request.Data, &order)
+		if request.Type != "place-order" || order.Price != "241.9477" || order.Pair[0] != "BTC" {
+			conn.Fail("unexpected request")
+			return
+		}
+		conn.Reply(map[string]interface{}{
+			"complete": false,
+			"id":       "2689090",
+			"time":     1435927928885,
+			"pending":  "0.02000000",
+			"amount":   "0.02000000",
+			"type":     "buy",
+			"price":    "241.9477",
+		})
+	})
+	context := newTestContext(t, server)
+
+	order, error := context.PlaceOrder("BTC", "USD", OrderBuy, d("0.02"), d("241.9477"))
+	if error != nil {
+		t.Fatal(error)
+	}
+	if order.Id != "2689090" || order.Type != OrderBuy || order.Pending != d("0.02") || order.Price != d("241.9477") {
+		t.Fatalf("Unexpected order %+v", order)
+	}
+}
+
+func TestOrderErrors(t *testing.T) {
+	server := newHandledServer(t, func(request cexiotest.Request, conn *cexiotest.Conn) {
+		// get-order is never answered
+		if request.Type != "get-order" {
+			conn.Fail("Order not found")
+		}
+	})
+	context := newTestContext(t, server)
+
+	_, error := context.CancelOrder("123")
+	response_error, ok := error.(*ResponseError)
+	if !ok || response_error.Message != "Order not found" || response_error.Type != "cancel-order" {
+		t.Fatalf("Expected ResponseError, got %v", error)
+	}
+
+	timeout := REQUEST_TIMEOUT
+	REQUEST_TIMEOUT = 50 * time.Millisecond
+	defer func() { REQUEST_TIMEOUT = timeout }()
+	if _, error = context.GetOrder("123"); error != ErrRequestTimeout {
+		t.Fatalf("Expected timeout, got %v", error)
+	}
+}
+
+func TestOrderQueries(t *testing.T) {
+	server := newHandledServer(t, func(request cexiotest.Request, conn *cexiotest.Conn) {
+		switch request.Type {
+		case "cancel-replace-order":
+			conn.Reply(map[string]interface{}{"id": "2", "type": "sell", "price": "250", "amount": "1", "pending": "1"})
+		case "get-order":
+			conn.Reply(map[string]interface{}{"orderId": "2", "type": "sell", "symbol1": "BTC", "symbol2": "USD",
+				"amount": "1.00000000", "remains": "0.50000000", "price": "250", "time": 1450214742160, "status": "a"})
+		case "open-orders":
+			conn.Reply([]map[string]interface{}{{"id": "2", "time": "1435927928885", "type": "sell",
+				"price": "250", "amount": "1.00000000", "pending": "0.50000000"}})
+		case "archived-orders":
+			conn.Reply([]map[string]interface{}{{"orderId": "1", "type": "buy", "symbol1": "BTC", "symbol2": "USD",
+				"amount": "1.00000000", "remains": "0.00000000", "price": "240", "time": "2015-12-15T13:22:27.506Z", "status": "d"}})
+		default:
+			conn.Fail("unexpected request")
+		}
+	})
+	context := newTestContext(t, server)
+
+	placed, error := context.CancelReplaceOrder("1", "BTC", "USD", OrderSell, d("1"), d("250"))
+	if error != nil || placed.Id != "2" {
+		t.Fatalf("cancel-replace-order: %+v %v", placed, error)
+	}
+	order, error := context.GetOrder("2")
+	if error != nil || order.Remains != d("0.5") || order.Status != "a" {
+		t.Fatalf("get-order: %+v %v", order, error)
+	}
+	open, error := context.OpenOrders("BTC", "USD")
+	if error != nil || len(open) != 1 || open[0].Time != 1435927928885 {
+		t.Fatalf("open-orders: %+v %v", open, error)
+	}
+	archived, error := context.ArchivedOrders("BTC", "USD", 10, time.Time{}, time.Now())
+	if error != nil || len(archived) != 1 || archived[0].Time.Year() != 2015 {
+		t.Fatalf("archived-orders: %+v %v", archived, error)
+	}
+}
@@ -0,0 +1,147 @@
+package cexio
+
+import (
+	gocontext "context"
+	"github.com/gorilla/websocket"
+	"io"
+	"sync"
+	"time"
+)
+
+// Frames buffered by each end of a pipe before writes block.
+const kPipeBuffer = 64
+
+// Transport carries the frames of a Context: a websocket connection,
+// an in-memory pipe or a recorded session, see Options.Dial.
+type Transport interface {
+	// ReadFrame blocks until the next frame arrives. A transport that
+	// fails or is closed returns an error, after which the context
+	// dials a new one.
+	ReadFrame() ([]byte, error)
+	WriteFrame(frame []byte) error
+	// Close makes pending and future reads and writes fail.
+	Close() error
+}
+
+// Implemented by transports that can tell the other side they are
+// closing, see Context.Close.
+type gracefulCloser interface {
+	closeGracefully(deadline time.Time) error
+}
+
+// Clock tells the time to a Context, e.g. the receive time of frames.
+// A Replay sets it to the time frames were recorded at.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (context *Context) now() time.Time {
+	if context.options.Clock == nil {
+		return time.Now()
+	}
+	return context.options.Clock.Now()
+}
+
+type websocketTransport struct {
+	connection *websocket.Conn
+}
+
+// NewWebsocketTransport wraps a gorilla websocket connection. Reads
+// fail once nothing was received for PING_TIMEOUT.
+func NewWebsocketTransport(connection *websocket.Conn) Transport {
+	return &websocketTransport{connection}
+}
+
+func (transport *websocketTransport) ReadFrame() ([]byte, error) {
+	transport.connection.SetReadDeadline(time.Now().Add(PING_TIMEOUT))
+	_, frame, error := transport.connection.ReadMessage()
+	return frame, error
+}
+
+func (transport *websocketTransport) WriteFrame(frame []byte) error {
+	return transport.connection.WriteMessage(websocket.TextMessage, frame)
+}
+
+func (transport *websocketTransport) Close() error {
+	return transport.connection.Close()
+}
+
+func (transport *websocketTransport) closeGracefully(deadline time.Time) error {
+	message := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	return transport.connection.WriteControl(websocket.CloseMessage, message, deadline)
+}
+
+// Dials opts.Endpoint with opts.Dialer.
+func dialWebsocket(ctx gocontext.Context, opts Options) (Transport, error) {
+	connection, _, error := opts.Dialer.DialContext(ctx, opts.Endpoint, nil)
+	if error != nil {
+		return nil, error
+	}
+	return NewWebsocketTransport(connection), nil
+}
+
+type pipeEnd struct {
+	incoming chan []byte
+	peer     *pipeEnd
+	// Shared by both ends
+	closed    chan struct{}
+	closeOnce *sync.Once
+}
+
+// NewPipe returns the two ends of an in-memory Transport, frames written
+// to one end are read from the other in order. Closing either end
+// closes both, like a dropped connection. Unlike a websocket it never
+// times out, so tests can play the server on the other end:
+//
+//	client, server := NewPipe()
+//	context, _ := NewContext(Options{Dial: func(gocontext.Context) (Transport, error) { return client, nil }})
+//	request, _ := server.ReadFrame()
+func NewPipe() (Transport, Transport) {
+	closed, once := make(chan struct{}), &sync.Once{}
+	client := &pipeEnd{incoming: make(chan []byte, kPipeBuffer), closed: closed, closeOnce: once}
+	server := &pipeEnd{incoming: make(chan []byte, kPipeBuffer), closed: closed, closeOnce: once}
+	client.peer, server.peer = server, client
+	return client, server
+}
+
+// Frames already received are still returned after the pipe closed.
+func (end *pipeEnd) ReadFrame() ([]byte, error) {
+	select {
+	case frame := <-end.incoming:
+		return frame, nil
+	default:
+	}
+	select {
+	case frame := <-end.incoming:
+		return frame, nil
+	case <-end.closed:
+		return nil, io.ErrClosedPipe
+	}
+}
+
+func (end *pipeEnd) WriteFrame(frame []byte) error {
+	// The reader of the peer may keep frame
+	frame = append([]byte(nil), frame...)
+	select {
+	case <-end.closed:
+		return io.ErrClosedPipe
+	default:
+	}
+	select {
+	case end.peer.incoming <- frame:
+		return nil
+	case <-end.closed:
+		return io.ErrClosedPipe
+	}
+}
+
+func (end *pipeEnd) Close() error {
+	end.closeOnce.Do(func() { close(end.closed) })
+	return nil
+}
@@ -0,0 +1,154 @@
+package cexio
+
+import (
+	gocontext "context"
+	"encoding/json"
+	"github.com/sahmad98/cex.io/cexiotest"
+	"io"
+	"testing"
+	"time"
+)
+
+// Returns a context dialing a new pipe on every connect, the server
+// ends are handed to the test in order.
+func newPipeContext(t *testing.T) (*Context, chan Transport) {
+	servers := make(chan Transport, 4)
+	context, error := NewContext(Options{Dial: func(ctx gocontext.Context) (Transport, error) {
+		client, server := NewPipe()
+		servers <- server
+		return client, nil
+	}})
+	if error != nil {
+		t.Fatal(error)
+	}
+	t.Cleanup(context.closeConnection)
+	return context, servers
+}
+
+func acceptPipe(t *testing.T, servers chan Transport) Transport {
+	select {
+	case server := <-servers:
+		return server
+	case <-time.After(2 * time.Second):
+		t.Fatal("Context did not dial")
+	}
+	return nil
+}
+
+// Reads requests from server until one of request_type arrives.
+func expectRequest(t *testing.T, server Transport, request_type string) cexiotest.Request {
+	requests := make(chan cexiotest.Request)
+	go func() {
+		defer close(requests)
+		for {
+			frame, error := server.ReadFrame()
+			if error != nil {
+				return
+			}
+			request := cexiotest.Request{}
+			if json.Unmarshal(frame, &request) == nil && request.Type == request_type {
+				requests <- request
+				return
+			}
+		}
+	}()
+	select {
+	case request, ok := <-requests:
+		if !ok {
+			t.Fatalf("Pipe closed before %s", request_type)
+		}
+		return request
+	case <-time.After(2 * time.Second):
+		server.Close()
+		t.Fatalf("No %s request received", request_type)
+	}
+	return cexiotest.Request{}
+}
+
+func sendFrame(t *testing.T, server Transport, message interface{}) {
+	frame, _ := json.Marshal(message)
+	if error := server.WriteFrame(frame); error != nil {
+		t.Fatal(error)
+	}
+}
+
+func TestPipe(t *testing.T) {
+	client, server := NewPipe()
+	frame := []byte("one")
+	client.WriteFrame(frame)
+	frame[0] = 'x'
+	server.WriteFrame([]byte("two"))
+	if received, _ := server.ReadFrame(); string(received) != "one" {
+		t.Fatalf("Unexpected frame %q", received)
+	}
+	if received, _ := client.ReadFrame(); string(received) != "two" {
+		t.Fatalf("Unexpected frame %q", received)
+	}
+
+	read := make(chan error)
+	go func() {
+		_, error := client.ReadFrame()
+		read <- error
+	}()
+	server.Close()
+	select {
+	case error := <-read:
+		if error != io.ErrClosedPipe {
+			t.Fatalf("Unexpected error %v", error)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close did not unblock the read of the other end")
+	}
+	if client.WriteFrame([]byte("three")) != io.ErrClosedPipe {
+		t.Fatal("Write after close succeeded")
+	}
+}
+
+func TestPipeSnapshotAndUpdate(t *testing.T) {
+	context, servers := newPipeContext(t)
+	server := acceptPipe(t, servers)
+	md := NewMarketDataAdapter(context)
+	books := make(chan BookEvent, 16)
+	md.OnBook("BTC:USD", func(event BookEvent) { books <- event })
+	md.Subscribe("BTC", "USD", 5)
+
+	request := expectRequest(t, server, "order-book-subscribe")
+	sendFrame(t, server, map[string]interface{}{"e": request.Type, "oid": request.Oid, "ok": "ok",
+		"data": map[string]interface{}{"pair": "BTC:USD", "id": 1, "bids": [][]string{{"100", "1"}}, "asks": [][]string{{"101", "1"}}}})
+	if event := receiveBook(t, books); event.Book.Id != 1 || event.Book.Bids.Len() != 1 || event.Book.Asks.Len() != 1 {
+		t.Fatalf("Unexpected snapshot %+v", event.Book)
+	}
+
+	sendFrame(t, server, map[string]interface{}{"e": "md_update",
+		"data": map[string]interface{}{"pair": "BTC:USD", "id": 2, "bids": [][]string{{"99", "2"}, {"100", "0"}}, "asks": [][]string{}}})
+	event := receiveBook(t, books)
+	if best, _ := event.Book.Bids.Best(); event.Book.Id != 2 || event.Book.Bids.Len() != 1 || best != (Level{d("99"), d("2")}) {
+		t.Fatalf("Unexpected update %+v", event.Book)
+	}
+}
+
+func TestPipePingPong(t *testing.T) {
+	context, servers := newPipeContext(t)
+	server := acceptPipe(t, servers)
+	NewMarketDataAdapter(context)
+	sendFrame(t, server, map[string]string{"e": "ping"})
+	expectRequest(t, server, "pong")
+}
+
+func TestPipeResubscribeAfterDrop(t *testing.T) {
+	backoff := RECONNECT_MIN_BACKOFF
+	RECONNECT_MIN_BACKOFF = 10 * time.Millisecond
+	defer func() { RECONNECT_MIN_BACKOFF = backoff }()
+
+	context, servers := newPipeContext(t)
+	server := acceptPipe(t, servers)
+	md := NewMarketDataAdapter(context)
+	md.Subscribe("BTC", "USD", 5)
+	expectRequest(t, server, "order-book-subscribe")
+	server.Close()
+
+	server = acceptPipe(t, servers)
+	if request := expectRequest(t, server, "order-book-subscribe"); string(request.Data) == "" {
+		t.Fatal("Resubscribed without data")
+	}
+}
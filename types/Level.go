@@ -19,23 +19,23 @@ func (rcv *Level) Table() flatbuffers.Table {
 	return rcv._tab.Table
 }
 
-func (rcv *Level) Price() float32 {
-	return rcv._tab.GetFloat32(rcv._tab.Pos + flatbuffers.UOffsetT(0))
+func (rcv *Level) Price() int64 {
+	return rcv._tab.GetInt64(rcv._tab.Pos + flatbuffers.UOffsetT(0))
 }
-func (rcv *Level) MutatePrice(n float32) bool {
-	return rcv._tab.MutateFloat32(rcv._tab.Pos+flatbuffers.UOffsetT(0), n)
+func (rcv *Level) MutatePrice(n int64) bool {
+	return rcv._tab.MutateInt64(rcv._tab.Pos+flatbuffers.UOffsetT(0), n)
 }
 
-func (rcv *Level) Qty() float32 {
-	return rcv._tab.GetFloat32(rcv._tab.Pos + flatbuffers.UOffsetT(4))
+func (rcv *Level) Qty() int64 {
+	return rcv._tab.GetInt64(rcv._tab.Pos + flatbuffers.UOffsetT(8))
 }
-func (rcv *Level) MutateQty(n float32) bool {
-	return rcv._tab.MutateFloat32(rcv._tab.Pos+flatbuffers.UOffsetT(4), n)
+func (rcv *Level) MutateQty(n int64) bool {
+	return rcv._tab.MutateInt64(rcv._tab.Pos+flatbuffers.UOffsetT(8), n)
 }
 
-func CreateLevel(builder *flatbuffers.Builder, Price float32, Qty float32) flatbuffers.UOffsetT {
-	builder.Prep(4, 8)
-	builder.PrependFloat32(Qty)
-	builder.PrependFloat32(Price)
+func CreateLevel(builder *flatbuffers.Builder, Price int64, Qty int64) flatbuffers.UOffsetT {
+	builder.Prep(8, 16)
+	builder.PrependInt64(Qty)
+	builder.PrependInt64(Price)
 	return builder.Offset()
 }
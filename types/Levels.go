@@ -30,7 +30,7 @@ func (rcv *Levels) Data(obj *Level, j int) bool {
 	o := flatbuffers.UOffsetT(rcv._tab.Offset(4))
 	if o != 0 {
 		x := rcv._tab.Vector(o)
-		x += flatbuffers.UOffsetT(j) * 8
+		x += flatbuffers.UOffsetT(j) * 16
 		obj.Init(rcv._tab.Bytes, x)
 		return true
 	}
@@ -52,7 +52,7 @@ func LevelsAddData(builder *flatbuffers.Builder, Data flatbuffers.UOffsetT) {
 	builder.PrependUOffsetTSlot(0, flatbuffers.UOffsetT(Data), 0)
 }
 func LevelsStartDataVector(builder *flatbuffers.Builder, numElems int) flatbuffers.UOffsetT {
-	return builder.StartVector(8, numElems, 4)
+	return builder.StartVector(16, numElems, 8)
 }
 func LevelsEnd(builder *flatbuffers.Builder) flatbuffers.UOffsetT {
 	return builder.EndObject()
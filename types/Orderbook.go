@@ -72,76 +72,76 @@ func (rcv *Orderbook) Asks(obj *Levels) *Levels {
 	return nil
 }
 
-func (rcv *Orderbook) Low() float32 {
+func (rcv *Orderbook) Low() int64 {
 	o := flatbuffers.UOffsetT(rcv._tab.Offset(12))
 	if o != 0 {
-		return rcv._tab.GetFloat32(o + rcv._tab.Pos)
+		return rcv._tab.GetInt64(o + rcv._tab.Pos)
 	}
-	return 0.0
+	return 0
 }
 
-func (rcv *Orderbook) MutateLow(n float32) bool {
-	return rcv._tab.MutateFloat32Slot(12, n)
+func (rcv *Orderbook) MutateLow(n int64) bool {
+	return rcv._tab.MutateInt64Slot(12, n)
 }
 
-func (rcv *Orderbook) High() float32 {
+func (rcv *Orderbook) High() int64 {
 	o := flatbuffers.UOffsetT(rcv._tab.Offset(14))
 	if o != 0 {
-		return rcv._tab.GetFloat32(o + rcv._tab.Pos)
+		return rcv._tab.GetInt64(o + rcv._tab.Pos)
 	}
-	return 0.0
+	return 0
 }
 
-func (rcv *Orderbook) MutateHigh(n float32) bool {
-	return rcv._tab.MutateFloat32Slot(14, n)
+func (rcv *Orderbook) MutateHigh(n int64) bool {
+	return rcv._tab.MutateInt64Slot(14, n)
 }
 
-func (rcv *Orderbook) LastPrice() float32 {
+func (rcv *Orderbook) LastPrice() int64 {
 	o := flatbuffers.UOffsetT(rcv._tab.Offset(16))
 	if o != 0 {
-		return rcv._tab.GetFloat32(o + rcv._tab.Pos)
+		return rcv._tab.GetInt64(o + rcv._tab.Pos)
 	}
-	return 0.0
+	return 0
 }
 
-func (rcv *Orderbook) MutateLastPrice(n float32) bool {
-	return rcv._tab.MutateFloat32Slot(16, n)
+func (rcv *Orderbook) MutateLastPrice(n int64) bool {
+	return rcv._tab.MutateInt64Slot(16, n)
 }
 
-func (rcv *Orderbook) Volume() float32 {
+func (rcv *Orderbook) Volume() int64 {
 	o := flatbuffers.UOffsetT(rcv._tab.Offset(18))
 	if o != 0 {
-		return rcv._tab.GetFloat32(o + rcv._tab.Pos)
+		return rcv._tab.GetInt64(o + rcv._tab.Pos)
 	}
-	return 0.0
+	return 0
 }
 
-func (rcv *Orderbook) MutateVolume(n float32) bool {
-	return rcv._tab.MutateFloat32Slot(18, n)
+func (rcv *Orderbook) MutateVolume(n int64) bool {
+	return rcv._tab.MutateInt64Slot(18, n)
 }
 
-func (rcv *Orderbook) Bid() float32 {
+func (rcv *Orderbook) Bid() int64 {
 	o := flatbuffers.UOffsetT(rcv._tab.Offset(20))
 	if o != 0 {
-		return rcv._tab.GetFloat32(o + rcv._tab.Pos)
+		return rcv._tab.GetInt64(o + rcv._tab.Pos)
 	}
-	return 0.0
+	return 0
 }
 
-func (rcv *Orderbook) MutateBid(n float32) bool {
-	return rcv._tab.MutateFloat32Slot(20, n)
+func (rcv *Orderbook) MutateBid(n int64) bool {
+	return rcv._tab.MutateInt64Slot(20, n)
 }
 
-func (rcv *Orderbook) Ask() float32 {
+func (rcv *Orderbook) Ask() int64 {
 	o := flatbuffers.UOffsetT(rcv._tab.Offset(22))
 	if o != 0 {
-		return rcv._tab.GetFloat32(o + rcv._tab.Pos)
+		return rcv._tab.GetInt64(o + rcv._tab.Pos)
 	}
-	return 0.0
+	return 0
 }
 
-func (rcv *Orderbook) MutateAsk(n float32) bool {
-	return rcv._tab.MutateFloat32Slot(22, n)
+func (rcv *Orderbook) MutateAsk(n int64) bool {
+	return rcv._tab.MutateInt64Slot(22, n)
 }
 
 func OrderbookStart(builder *flatbuffers.Builder) {
@@ -159,23 +159,23 @@ func OrderbookAddBids(builder *flatbuffers.Builder, Bids flatbuffers.UOffsetT) {
 func OrderbookAddAsks(builder *flatbuffers.Builder, Asks flatbuffers.UOffsetT) {
 	builder.PrependUOffsetTSlot(3, flatbuffers.UOffsetT(Asks), 0)
 }
-func OrderbookAddLow(builder *flatbuffers.Builder, Low float32) {
-	builder.PrependFloat32Slot(4, Low, 0.0)
+func OrderbookAddLow(builder *flatbuffers.Builder, Low int64) {
+	builder.PrependInt64Slot(4, Low, 0)
 }
-func OrderbookAddHigh(builder *flatbuffers.Builder, High float32) {
-	builder.PrependFloat32Slot(5, High, 0.0)
+func OrderbookAddHigh(builder *flatbuffers.Builder, High int64) {
+	builder.PrependInt64Slot(5, High, 0)
 }
-func OrderbookAddLastPrice(builder *flatbuffers.Builder, LastPrice float32) {
-	builder.PrependFloat32Slot(6, LastPrice, 0.0)
+func OrderbookAddLastPrice(builder *flatbuffers.Builder, LastPrice int64) {
+	builder.PrependInt64Slot(6, LastPrice, 0)
 }
-func OrderbookAddVolume(builder *flatbuffers.Builder, Volume float32) {
-	builder.PrependFloat32Slot(7, Volume, 0.0)
+func OrderbookAddVolume(builder *flatbuffers.Builder, Volume int64) {
+	builder.PrependInt64Slot(7, Volume, 0)
 }
-func OrderbookAddBid(builder *flatbuffers.Builder, Bid float32) {
-	builder.PrependFloat32Slot(8, Bid, 0.0)
+func OrderbookAddBid(builder *flatbuffers.Builder, Bid int64) {
+	builder.PrependInt64Slot(8, Bid, 0)
 }
-func OrderbookAddAsk(builder *flatbuffers.Builder, Ask float32) {
-	builder.PrependFloat32Slot(9, Ask, 0.0)
+func OrderbookAddAsk(builder *flatbuffers.Builder, Ask int64) {
+	builder.PrependInt64Slot(9, Ask, 0)
 }
 func OrderbookEnd(builder *flatbuffers.Builder) flatbuffers.UOffsetT {
 	return builder.EndObject()
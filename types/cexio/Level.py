@@ -12,12 +12,12 @@ class Level(object):
         self._tab = flatbuffers.table.Table(buf, pos)
 
     # Level
-    def Price(self): return self._tab.Get(flatbuffers.number_types.Float32Flags, self._tab.Pos + flatbuffers.number_types.UOffsetTFlags.py_type(0))
+    def Price(self): return self._tab.Get(flatbuffers.number_types.Int64Flags, self._tab.Pos + flatbuffers.number_types.UOffsetTFlags.py_type(0))
     # Level
-    def Qty(self): return self._tab.Get(flatbuffers.number_types.Float32Flags, self._tab.Pos + flatbuffers.number_types.UOffsetTFlags.py_type(4))
+    def Qty(self): return self._tab.Get(flatbuffers.number_types.Int64Flags, self._tab.Pos + flatbuffers.number_types.UOffsetTFlags.py_type(8))
 
 def CreateLevel(builder, Price, Qty):
-    builder.Prep(4, 8)
-    builder.PrependFloat32(Qty)
-    builder.PrependFloat32(Price)
+    builder.Prep(8, 16)
+    builder.PrependInt64(Qty)
+    builder.PrependInt64(Price)
     return builder.Offset()
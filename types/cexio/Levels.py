@@ -23,7 +23,7 @@ class Levels(object):
         o = flatbuffers.number_types.UOffsetTFlags.py_type(self._tab.Offset(4))
         if o != 0:
             x = self._tab.Vector(o)
-            x += flatbuffers.number_types.UOffsetTFlags.py_type(j) * 8
+            x += flatbuffers.number_types.UOffsetTFlags.py_type(j) * 16
             from .Level import Level
             obj = Level()
             obj.Init(self._tab.Bytes, x)
@@ -39,5 +39,5 @@ class Levels(object):
 
 def LevelsStart(builder): builder.StartObject(1)
 def LevelsAddData(builder, Data): builder.PrependUOffsetTRelativeSlot(0, flatbuffers.number_types.UOffsetTFlags.py_type(Data), 0)
-def LevelsStartDataVector(builder, numElems): return builder.StartVector(8, numElems, 4)
+def LevelsStartDataVector(builder, numElems): return builder.StartVector(16, numElems, 8)
 def LevelsEnd(builder): return builder.EndObject()